@@ -1,4 +1,3 @@
-
 package main
 
 import (
@@ -14,7 +13,24 @@ func main() {
 		fmt.Printf("Warning: no .env file found in current directory\n")
 	}
 
-	if err := app.Run("development", "./slx.log"); err != nil {
+	var err error
+	if len(os.Args) < 2 {
+		err = app.Run("development", "./slx.log")
+	} else {
+		switch os.Args[1] {
+		case "replay":
+			err = app.Replay("development", "./slx.log", os.Args[2:])
+		case "checkpoints":
+			err = app.Checkpoints("development", "./slx.log", os.Args[2:])
+		case "quarantine":
+			err = app.Quarantine("development", "./slx.log", os.Args[2:])
+		default:
+			fmt.Println("Usage: slx-unix [replay|checkpoints|quarantine]")
+			os.Exit(1)
+		}
+	}
+
+	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
@@ -43,7 +43,7 @@ func runAsService() error {
 
 func handleInteractiveCommands() {
 	if len(os.Args) < 2 {
-		fmt.Println("Usage: slx-windows [install|uninstall|start|stop|debug]")
+		fmt.Println("Usage: slx-windows [install|uninstall|start|stop|debug|replay|checkpoints|quarantine]")
 		return
 	}
 
@@ -57,6 +57,30 @@ func handleInteractiveCommands() {
 		return
 	}
 
+	if cmd == "replay" {
+		if err := app.Replay("development", "C:/SLX/slx.log", os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "replay failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cmd == "checkpoints" {
+		if err := app.Checkpoints("development", "C:/SLX/slx.log", os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "checkpoints failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if cmd == "quarantine" {
+		if err := app.Quarantine("development", "C:/SLX/slx.log", os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "quarantine failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	var err error
 	switch cmd {
 	case "install":
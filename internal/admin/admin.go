@@ -0,0 +1,248 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/salesworks/s-works/slx/internal/tracker"
+)
+
+// Checker is implemented by components that can report whether they work
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+// CheckFunc adapts a function to the Checker interface
+type CheckFunc func(ctx context.Context) error
+
+// Check calls f(ctx)
+func (f CheckFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+// Tracker exposes the aggregate state to the admin server
+type Tracker interface {
+	// Status returns the status of every configured aggregate
+	Status(ctx context.Context) ([]tracker.AggregateStatus, error)
+	// SetChangeVersion moves the change version of a configured aggregate
+	SetChangeVersion(ctx context.Context, aggregateName string, version int64) error
+}
+
+// Queue exposes the dispatcher queue to the admin server
+type Queue interface {
+	QueueDepth() int
+	QueueCapacity() int
+}
+
+// Config configures the admin server
+type Config struct {
+	// Addr is the address the server listens on, e.g. "127.0.0.1:8080"
+	Addr string
+	// Token is the bearer token required by the status, checkpoint and metrics endpoints, the
+	// health endpoints stay open for probes
+	Token string
+	// Metrics serves /metrics when set
+	Metrics http.Handler
+}
+
+type namedCheck struct {
+	name     string
+	checker  Checker
+	liveness bool
+}
+
+// Server is the embedded admin HTTP server
+type Server struct {
+	cfg     Config
+	tracker Tracker
+	queue   Queue
+	checks  []namedCheck
+	timeout time.Duration
+	logger  *slog.Logger
+}
+
+// NewServer creates a new admin server
+func NewServer(cfg Config, tracker Tracker, queue Queue, logger *slog.Logger) *Server {
+	return &Server{
+		cfg:     cfg,
+		tracker: tracker,
+		queue:   queue,
+		timeout: 5 * time.Second,
+		logger:  logger.With("component", "admin"),
+	}
+}
+
+// AddCheck registers a dependency check. Every check is part of /readyz, liveness checks are
+// also part of /healthz.
+func (s *Server) AddCheck(name string, checker Checker, liveness bool) {
+	s.checks = append(s.checks, namedCheck{name: name, checker: checker, liveness: liveness})
+}
+
+// Handler returns the HTTP handler of the admin endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", s.handleHealth(true))
+	mux.HandleFunc("GET /readyz", s.handleHealth(false))
+	mux.Handle("GET /status", s.authorize(http.HandlerFunc(s.handleStatus)))
+	mux.Handle("PUT /checkpoints/{aggregate}", s.authorize(http.HandlerFunc(s.handleSetCheckpoint)))
+	if s.cfg.Metrics != nil {
+		mux.Handle("GET /metrics", s.authorize(s.cfg.Metrics))
+	}
+	return mux
+}
+
+// Start listens on the configured address and serves until the context is cancelled, a bind
+// error is returned immediately
+func (s *Server) Start(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.cfg.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.cfg.Addr, err)
+	}
+	if s.cfg.Token == "" {
+		s.logger.Warn("admin server runs without a token, status and checkpoint endpoints are open")
+	}
+
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			s.logger.Error("failed to shut down admin server", "error", err)
+		}
+	}()
+
+	go func() {
+		s.logger.Info("admin server listening", "addr", listener.Addr().String())
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error("admin server stopped", "error", err)
+		}
+	}()
+	return nil
+}
+
+// healthResponse is the body of /healthz and /readyz
+type healthResponse struct {
+	Status string            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
+
+func (s *Server) handleHealth(liveness bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+		defer cancel()
+
+		response := healthResponse{Status: "ok", Checks: make(map[string]string)}
+		for _, check := range s.checks {
+			if liveness && !check.liveness {
+				continue
+			}
+			if err := check.checker.Check(ctx); err != nil {
+				response.Status = "fail"
+				response.Checks[check.name] = err.Error()
+				continue
+			}
+			response.Checks[check.name] = "ok"
+		}
+
+		status := http.StatusOK
+		if response.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+		s.writeJSON(w, status, response)
+	}
+}
+
+// statusResponse is the body of /status
+type statusResponse struct {
+	Aggregates    []tracker.AggregateStatus `json:"aggregates"`
+	QueueDepth    int                       `json:"queue_depth"`
+	QueueCapacity int                       `json:"queue_capacity"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	aggregates, err := s.tracker.Status(r.Context())
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.writeJSON(w, http.StatusOK, statusResponse{
+		Aggregates:    aggregates,
+		QueueDepth:    s.queue.QueueDepth(),
+		QueueCapacity: s.queue.QueueCapacity(),
+	})
+}
+
+// setCheckpointRequest is the body of PUT /checkpoints/{aggregate}
+type setCheckpointRequest struct {
+	ChangeVersion *int64 `json:"change_version"`
+}
+
+func (s *Server) handleSetCheckpoint(w http.ResponseWriter, r *http.Request) {
+	aggregate := r.PathValue("aggregate")
+
+	var request setCheckpointRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+		return
+	}
+	if request.ChangeVersion == nil {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("change_version is required"))
+		return
+	}
+
+	if *request.ChangeVersion < 0 {
+		s.writeError(w, http.StatusBadRequest, fmt.Errorf("change_version must not be negative"))
+		return
+	}
+
+	err := s.tracker.SetChangeVersion(r.Context(), aggregate, *request.ChangeVersion)
+	if errors.Is(err, tracker.ErrAggregateNotConfigured) {
+		s.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	if err != nil {
+		s.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.logger.Info("checkpoint set through admin server", "aggregate", aggregate, "version", *request.ChangeVersion)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authorize requires the bearer token when one is configured
+func (s *Server) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Token != "" {
+			expected := "Bearer " + s.cfg.Token
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) != 1 {
+				s.writeError(w, http.StatusUnauthorized, fmt.Errorf("unauthorized"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) writeError(w http.ResponseWriter, status int, err error) {
+	s.writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+func (s *Server) writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.logger.Error("failed to write response", "error", err)
+	}
+}
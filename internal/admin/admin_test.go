@@ -0,0 +1,171 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/salesworks/s-works/slx/internal/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockTracker struct {
+	statuses   []tracker.AggregateStatus
+	setName    string
+	setVersion int64
+}
+
+func (m *mockTracker) Status(ctx context.Context) ([]tracker.AggregateStatus, error) {
+	return m.statuses, nil
+}
+
+func (m *mockTracker) SetChangeVersion(ctx context.Context, aggregateName string, version int64) error {
+	if aggregateName != "customer" {
+		return fmt.Errorf("%w: '%s'", tracker.ErrAggregateNotConfigured, aggregateName)
+	}
+	m.setName = aggregateName
+	m.setVersion = version
+	return nil
+}
+
+type mockQueue struct{}
+
+func (mockQueue) QueueDepth() int    { return 3 }
+func (mockQueue) QueueCapacity() int { return 100 }
+
+func newTestServer(token string, tr *mockTracker) *Server {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	return NewServer(Config{Addr: "127.0.0.1:0", Token: token}, tr, mockQueue{}, logger)
+}
+
+func TestServer_Health(t *testing.T) {
+	// --- Arrange ---
+	server := newTestServer("secret", &mockTracker{})
+	server.AddCheck("bbolt", CheckFunc(func(ctx context.Context) error { return nil }), true)
+	server.AddCheck("sqlserver", CheckFunc(func(ctx context.Context) error { return errors.New("connection refused") }), false)
+	handler := server.Handler()
+
+	// --- Act ---
+	live := httptest.NewRecorder()
+	handler.ServeHTTP(live, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	ready := httptest.NewRecorder()
+	handler.ServeHTTP(ready, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+	// --- Assert ---
+	assert.Equal(t, http.StatusOK, live.Code, "a failing readiness check must not fail liveness")
+	assert.Equal(t, http.StatusServiceUnavailable, ready.Code)
+
+	var response healthResponse
+	require.NoError(t, json.NewDecoder(ready.Body).Decode(&response))
+	assert.Equal(t, "fail", response.Status)
+	assert.Equal(t, "ok", response.Checks["bbolt"])
+	assert.Equal(t, "connection refused", response.Checks["sqlserver"])
+}
+
+func TestServer_Status(t *testing.T) {
+	// --- Arrange ---
+	tr := &mockTracker{statuses: []tracker.AggregateStatus{
+		{Checkpoint: tracker.Checkpoint{Aggregate: "customer", ChangeVersion: 42, LastError: "timeout"}, Interval: 30},
+	}}
+	handler := newTestServer("secret", tr).Handler()
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{name: "missing token", header: "", status: http.StatusUnauthorized},
+		{name: "wrong token", header: "Bearer nope", status: http.StatusUnauthorized},
+		{name: "valid token", header: "Bearer secret", status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			req := httptest.NewRequest(http.MethodGet, "/status", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			// --- Assert ---
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status != http.StatusOK {
+				return
+			}
+			var response statusResponse
+			require.NoError(t, json.NewDecoder(rec.Body).Decode(&response))
+			assert.Equal(t, 3, response.QueueDepth)
+			assert.Equal(t, 100, response.QueueCapacity)
+			require.Len(t, response.Aggregates, 1)
+			assert.Equal(t, int64(42), response.Aggregates[0].ChangeVersion)
+			assert.Equal(t, "timeout", response.Aggregates[0].LastError)
+		})
+	}
+}
+
+func TestServer_SetCheckpoint(t *testing.T) {
+	tests := []struct {
+		name      string
+		aggregate string
+		body      string
+		status    int
+	}{
+		{name: "valid", aggregate: "customer", body: `{"change_version": 17}`, status: http.StatusNoContent},
+		{name: "missing version", aggregate: "customer", body: `{}`, status: http.StatusBadRequest},
+		{name: "negative version", aggregate: "customer", body: `{"change_version": -1}`, status: http.StatusBadRequest},
+		{name: "unknown aggregate", aggregate: "missing", body: `{"change_version": 1}`, status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			tr := &mockTracker{}
+			handler := newTestServer("", tr).Handler()
+			req := httptest.NewRequest(http.MethodPut, "/checkpoints/"+tt.aggregate, strings.NewReader(tt.body))
+			rec := httptest.NewRecorder()
+
+			// --- Act ---
+			handler.ServeHTTP(rec, req)
+
+			// --- Assert ---
+			assert.Equal(t, tt.status, rec.Code)
+			if tt.status == http.StatusNoContent {
+				assert.Equal(t, "customer", tr.setName)
+				assert.Equal(t, int64(17), tr.setVersion)
+			}
+		})
+	}
+}
+
+func TestServer_Metrics(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	metricsHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "slx_dispatcher_queue_depth 0\n")
+	})
+	server := NewServer(Config{Token: "secret", Metrics: metricsHandler}, &mockTracker{}, mockQueue{}, logger)
+	handler := server.Handler()
+
+	// --- Act ---
+	anonymous := httptest.NewRecorder()
+	handler.ServeHTTP(anonymous, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	authorized := httptest.NewRecorder()
+	handler.ServeHTTP(authorized, req)
+
+	// --- Assert ---
+	assert.Equal(t, http.StatusUnauthorized, anonymous.Code)
+	assert.Equal(t, http.StatusOK, authorized.Code)
+	assert.Contains(t, authorized.Body.String(), "slx_dispatcher_queue_depth")
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/salesworks/s-works/slx/internal/tracker"
+)
+
+// errNoInstance is returned when no running instance can take a checkpoint change
+var errNoInstance = errors.New("no running instance reachable")
+
+// adminClient changes checkpoints through the admin server of a running instance, so the
+// change waits for the cycle of the aggregate instead of racing it
+type adminClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+// newAdminClient creates a client of the admin server listening on the address, an empty
+// host is the local host
+func newAdminClient(cfg adminConfig) *adminClient {
+	host, port, err := net.SplitHostPort(cfg.addr)
+	if err == nil && (host == "" || host == "0.0.0.0" || host == "::") {
+		cfg.addr = net.JoinHostPort("localhost", port)
+	}
+	return &adminClient{
+		baseURL: "http://" + cfg.addr,
+		token:   cfg.token,
+		client:  &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// checkpoint returns the checkpoint of an aggregate from GET /status. It returns errNoInstance
+// when the admin server cannot be reached and ErrAggregateNotConfigured when the instance does
+// not track the aggregate.
+func (c *adminClient) checkpoint(ctx context.Context, aggregate string) (tracker.Checkpoint, error) {
+	response, err := c.do(ctx, http.MethodGet, "/status", nil)
+	if err != nil {
+		return tracker.Checkpoint{}, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return tracker.Checkpoint{}, responseError(response)
+	}
+
+	var status struct {
+		Aggregates []tracker.AggregateStatus `json:"aggregates"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&status); err != nil {
+		return tracker.Checkpoint{}, fmt.Errorf("failed to decode status: %w", err)
+	}
+	for _, aggregateStatus := range status.Aggregates {
+		if aggregateStatus.Aggregate == aggregate {
+			return aggregateStatus.Checkpoint, nil
+		}
+	}
+	return tracker.Checkpoint{}, fmt.Errorf("%w: '%s'", tracker.ErrAggregateNotConfigured, aggregate)
+}
+
+// setChangeVersion sets the change version through PUT /checkpoints/{aggregate}. It returns
+// errNoInstance when the admin server cannot be reached and ErrAggregateNotConfigured when the
+// instance does not track the aggregate.
+func (c *adminClient) setChangeVersion(ctx context.Context, aggregate string, version int64) error {
+	body, err := json.Marshal(map[string]int64{"change_version": version})
+	if err != nil {
+		return fmt.Errorf("failed to encode request: %w", err)
+	}
+	response, err := c.do(ctx, http.MethodPut, "/checkpoints/"+url.PathEscape(aggregate), body)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusNoContent, http.StatusOK:
+		return nil
+	case http.StatusNotFound:
+		return fmt.Errorf("%w: '%s'", tracker.ErrAggregateNotConfigured, aggregate)
+	default:
+		return responseError(response)
+	}
+}
+
+// do sends an authorized request to the admin server, a nil client has no instance to reach
+func (c *adminClient) do(ctx context.Context, method string, path string, body []byte) (*http.Response, error) {
+	if c == nil {
+		return nil, errNoInstance
+	}
+	request, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("%w at %s: %v", errNoInstance, c.baseURL, err)
+	}
+	return response, nil
+}
+
+// responseError returns the error of an unexpected admin server response
+func responseError(response *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+	return fmt.Errorf("admin server returned %s: %s", response.Status, strings.TrimSpace(string(message)))
+}
@@ -2,27 +2,43 @@ package app
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"syscall"
 	"time"
 
+	"github.com/salesworks/s-works/slx/internal/admin"
 	"github.com/salesworks/s-works/slx/internal/database"
 	"github.com/salesworks/s-works/slx/internal/dispatcher"
+	"github.com/salesworks/s-works/slx/internal/maintenance"
 	"github.com/salesworks/s-works/slx/internal/messaging"
-	"github.com/salesworks/s-works/slx/internal/repository"
+	"github.com/salesworks/s-works/slx/internal/metrics"
+	"github.com/salesworks/s-works/slx/internal/telemetry"
 	"github.com/salesworks/s-works/slx/internal/tracker"
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
 type config struct {
-	db      dbConfig
-	pg		pgConfig
-	disp    dispatcherConfig
-	aggPath string
+	db         dbConfig
+	pg         pgConfig
+	disp       dispatcherConfig
+	maint      maintenanceConfig
+	pub        publisherConfig
+	checkpoint checkpointConfig
+	admin      adminConfig
+	tracing    telemetry.Config
+	aggPath    string
+	// exactlyOnce commits every cycle's events and change version in one Postgres transaction
+	exactlyOnce bool
+	lag         lagConfig
+	pii         piiConfig
+	// environment is the deployment environment the {{env}} placeholder of event names renders
+	environment string
 }
 
 type pgConfig struct {
@@ -42,6 +58,23 @@ type dispatcherConfig struct {
 	jobQueueSize int
 }
 
+type lagConfig struct {
+	interval  time.Duration
+	warnRatio float64
+}
+
+type adminConfig struct {
+	addr  string
+	token string
+}
+
+type maintenanceConfig struct {
+	interval        time.Duration
+	partitioned     bool
+	partitionsAhead int
+	archivePath     string
+}
+
 func Run(env string, logPath string) error {
 	cfg := loadConfig()
 
@@ -55,6 +88,23 @@ func Run(env string, logPath string) error {
 	startupCtx, startupCancel := context.WithTimeout(appCtx, 200*time.Second)
 	defer startupCancel()
 
+	// Initialize tracing, spans are exported to the OTLP collector when an endpoint is set
+	shutdownTracing, err := telemetry.Setup(startupCtx, cfg.tracing)
+	if err != nil {
+		logger.Error("failed to initialize tracing", "error", err)
+		return fmt.Errorf("failed to initialize tracing: %w", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Warn("failed to flush traces", "error", err)
+		}
+	}()
+	if cfg.tracing.Endpoint != "" {
+		logger.Info("tracing initialized", "endpoint", cfg.tracing.Endpoint, "sample_ratio", cfg.tracing.SampleRatio)
+	}
+
 	// Initialize the SQL Server database connection pool
 	db, err := database.New(
 		startupCtx,
@@ -86,9 +136,31 @@ func Run(env string, logPath string) error {
 	}()
 	logger.Info("succesfully connected to postgres database")
 
-	publisher := messaging.NewPostgresPublisher(postgres.Pool, logger)
+	for name, pool := range map[string]*sql.DB{"sqlserver": db.Pool, "postgres": postgres.Pool} {
+		if err := metrics.RegisterDB(pool, name); err != nil {
+			logger.Warn("failed to register database metrics", "database", name, "error", err)
+		}
+	}
+
+	if cfg.exactlyOnce || slices.Contains(cfg.pub.names, "postgres") {
+		if err := messaging.MigrateEvents(startupCtx, postgres.Pool); err != nil {
+			logger.Error("failed to migrate events table", "error", err)
+			return err
+		}
+	}
+
+	publisherNames := cfg.pub.names
+	if cfg.exactlyOnce {
+		// events reach postgres through the cycle commit instead of the dispatcher
+		publisherNames = withoutPublisher(publisherNames, "postgres")
+	}
+	publisher, err := newPublisher(cfg.pub, publisherNames, postgres.Pool, logger)
+	if err != nil {
+		logger.Error("failed to initialize publishers", "error", err)
+		return fmt.Errorf("failed to initialize publishers: %w", err)
+	}
 	defer publisher.Close()
-	logger.Info("Postgres publisher initialized")
+	logger.Info("publishers initialized", "publishers", publisher.Names())
 
 
 	// Initialize Dispatcher
@@ -102,11 +174,33 @@ func Run(env string, logPath string) error {
 	logger.Info("dispatcher initialized", "numWorkers", cfg.disp.numWorkers, "jobQueueSize", cfg.disp.jobQueueSize)
 
 	// Register Aggregates
-	repo, err := repository.NewBBoltRepository(cfg.db.path, logger)
+	repo, err := newCheckpointRepository(startupCtx, cfg.checkpoint.backend, cfg, postgres.Pool, logger)
 	if err != nil {
 		logger.Error("failed to initialize repository", "error", err)
 		return fmt.Errorf("failed to initialize repository: %w", err)
 	}
+	logger.Info("checkpoint repository initialized", "backend", cfg.checkpoint.backend)
+
+	trackerOptions := []tracker.Option{tracker.WithLagMonitor(cfg.lag.interval, cfg.lag.warnRatio)}
+	if cfg.exactlyOnce {
+		committer, ok := repo.(tracker.CycleCommitter)
+		if !ok {
+			repo.Close()
+			return fmt.Errorf("checkpoint backend '%s' cannot commit cycles", cfg.checkpoint.backend)
+		}
+		trackerOptions = append(trackerOptions, tracker.WithCycleCommitter(committer))
+		logger.Info("exactly-once delivery into postgres enabled")
+	}
+	if quarantine, ok := repo.(tracker.Quarantine); ok {
+		trackerOptions = append(trackerOptions, tracker.WithQuarantine(quarantine))
+	}
+	protector, err := newProtector(cfg.pii)
+	if err != nil {
+		repo.Close()
+		logger.Error("failed to initialize pii protection", "error", err)
+		return fmt.Errorf("failed to initialize pii protection: %w", err)
+	}
+	trackerOptions = append(trackerOptions, tracker.WithProtector(protector), tracker.WithEnvironment(cfg.environment))
 	defer func() {
 		logger.Info("closing repository...")
 		repo.Close()
@@ -114,11 +208,19 @@ func Run(env string, logPath string) error {
 	}()
 
 	// Initialize Tracker
-	trackerInstance, err := tracker.NewTracker(startupCtx, cfg.aggPath, repo, logger, db.Pool, disp)
+	trackerInstance, err := tracker.NewTracker(
+		startupCtx, cfg.aggPath, repo, logger, db.Pool, disp, trackerOptions...,
+	)
 	if err != nil {
 		logger.Error("failed to initialize tracker", "error", err)
 		return fmt.Errorf("failed to initialize tracker: %w", err)
 	}
+	if err := trackerInstance.CheckSnapshotIsolation(startupCtx); err != nil {
+		logger.Error("snapshot isolation check failed", "error", err)
+		return fmt.Errorf("snapshot isolation check failed: %w", err)
+	}
+	reportOrphanedCheckpoints(startupCtx, repo, trackerInstance.Aggregates(), logger)
+
 	err = trackerInstance.Start(appCtx)
 	if err != nil {
 		logger.Error("failed to start tracker", "error", err)
@@ -126,6 +228,26 @@ func Run(env string, logPath string) error {
 	}
 	logger.Info("tracker started")
 
+	// Initialize admin server
+	if cfg.admin.addr != "" {
+		adminServer := newAdminServer(cfg, trackerInstance, disp, db.Pool, postgres.Pool, publisher, repo, logger)
+		if err := adminServer.Start(appCtx); err != nil {
+			logger.Error("failed to start admin server", "error", err)
+			return fmt.Errorf("failed to start admin server: %w", err)
+		}
+	}
+
+	// Initialize events table maintenance
+	maintainer, err := newMaintainer(cfg.maint, trackerInstance.Aggregates(), postgres.Pool, logger)
+	if err != nil {
+		logger.Error("failed to initialize events maintenance", "error", err)
+		return fmt.Errorf("failed to initialize events maintenance: %w", err)
+	}
+	if maintainer != nil {
+		maintainer.Start(appCtx, cfg.maint.interval)
+		logger.Info("events maintenance started", "interval", cfg.maint.interval)
+	}
+
 	// Wait for shutdown signal
 	<-appCtx.Done()
 	logger.Info("SLX Service shutdown initiated", "reason", appCtx.Err())
@@ -148,6 +270,54 @@ func Run(env string, logPath string) error {
 	return nil
 }
 
+// newAdminServer creates the admin server with a check for every external dependency, only
+// the local checkpoint file is part of the liveness check
+func newAdminServer(
+	cfg config, tracker admin.Tracker, queue admin.Queue, sqlServer *sql.DB, pg *sql.DB,
+	publisher *dispatcher.MultiPublisher, repo checkpointRepository, logger *slog.Logger,
+) *admin.Server {
+	server := admin.NewServer(admin.Config{
+		Addr:    cfg.admin.addr,
+		Token:   cfg.admin.token,
+		Metrics: metrics.Handler(),
+	}, tracker, queue, logger)
+	server.AddCheck("sqlserver", admin.CheckFunc(sqlServer.PingContext), false)
+	server.AddCheck("postgres", admin.CheckFunc(pg.PingContext), false)
+	server.AddCheck("checkpoints_"+cfg.checkpoint.backend, repo, cfg.checkpoint.backend == "bbolt")
+	for _, p := range publisher.Publishers() {
+		if checker, ok := p.Publisher.(admin.Checker); ok {
+			server.AddCheck(p.Name, checker, false)
+		}
+	}
+	return server
+}
+
+// newMaintainer creates the events table maintainer, it returns nil when no aggregate has
+// a retention set and partitioning is disabled
+func newMaintainer(
+	cfg maintenanceConfig, aggregates []tracker.Aggregate, db *sql.DB, logger *slog.Logger,
+) (*maintenance.Maintainer, error) {
+	policies := make([]maintenance.Policy, 0, len(aggregates))
+	expiring := false
+	for _, agg := range aggregates {
+		policy, err := maintenance.NewPolicy(agg.Name, agg.Retention, agg.Archive)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+		expiring = expiring || !policy.Forever()
+	}
+	if !expiring && !cfg.partitioned {
+		return nil, nil
+	}
+
+	return maintenance.NewMaintainer(db, policies, maintenance.Config{
+		Partitioned:     cfg.partitioned,
+		PartitionsAhead: cfg.partitionsAhead,
+		ArchivePath:     cfg.archivePath,
+	}, logger)
+}
+
 func newLogger(env string, logPath string) *slog.Logger {
 	var handler slog.Handler
 
@@ -217,6 +387,86 @@ func loadConfig() config {
 		panic("DB_PATH must be set in production environment")
 	}
 
+	maintenanceInterval, err := time.ParseDuration(os.Getenv("EVENTS_MAINTENANCE_INTERVAL"))
+	if err != nil || maintenanceInterval <= 0 {
+		maintenanceInterval = time.Hour
+	}
+	cfg.maint.interval = maintenanceInterval
+
+	cfg.maint.partitioned, _ = strconv.ParseBool(os.Getenv("EVENTS_PARTITIONED"))
+
+	partitionsAhead, err := strconv.Atoi(os.Getenv("EVENTS_PARTITIONS_AHEAD"))
+	if err != nil || partitionsAhead < 0 {
+		partitionsAhead = 2
+	}
+	cfg.maint.partitionsAhead = partitionsAhead
+
+	cfg.maint.archivePath = os.Getenv("EVENTS_ARCHIVE_PATH")
+
+	cfg.pub.names = parseList(os.Getenv("PUBLISHERS"))
+	if len(cfg.pub.names) == 0 {
+		cfg.pub.names = []string{"postgres"}
+	}
+	cfg.pub.natsURL = os.Getenv("NATS_URL")
+	cfg.pub.natsCreds = os.Getenv("NATS_CREDS")
+	cfg.pub.webhookURL = os.Getenv("WEBHOOK_URL")
+	cfg.pub.webhookToken = os.Getenv("WEBHOOK_TOKEN")
+
+	webhookTimeout, err := time.ParseDuration(os.Getenv("WEBHOOK_TIMEOUT"))
+	if err != nil || webhookTimeout <= 0 {
+		webhookTimeout = 10 * time.Second
+	}
+	cfg.pub.webhookTimeout = webhookTimeout
+
+	cfg.exactlyOnce, _ = strconv.ParseBool(os.Getenv("EXACTLY_ONCE"))
+
+	// a zero interval disables the lag monitor
+	lagInterval, err := time.ParseDuration(os.Getenv("LAG_CHECK_INTERVAL"))
+	if err != nil || lagInterval < 0 {
+		lagInterval = 5 * time.Minute
+	}
+	cfg.lag.interval = lagInterval
+
+	lagWarnRatio, err := strconv.ParseFloat(os.Getenv("LAG_WARN_RATIO"), 64)
+	if err != nil || lagWarnRatio < 0 || lagWarnRatio > 1 {
+		lagWarnRatio = 0.2
+	}
+	cfg.lag.warnRatio = lagWarnRatio
+
+	cfg.checkpoint.backend = os.Getenv("CHECKPOINT_BACKEND")
+	if cfg.checkpoint.backend == "" {
+		cfg.checkpoint.backend = "bbolt"
+		if cfg.exactlyOnce {
+			cfg.checkpoint.backend = "postgres"
+		}
+	}
+	if cfg.exactlyOnce && cfg.checkpoint.backend != "postgres" {
+		panic("EXACTLY_ONCE requires CHECKPOINT_BACKEND=postgres")
+	}
+
+	cfg.checkpoint.natsBucket = os.Getenv("CHECKPOINT_NATS_BUCKET")
+	if cfg.checkpoint.natsBucket == "" {
+		cfg.checkpoint.natsBucket = "slx_checkpoints"
+	}
+
+	cfg.admin.addr = os.Getenv("ADMIN_ADDR")
+	cfg.admin.token = os.Getenv("ADMIN_TOKEN")
+
+	cfg.tracing.Endpoint = os.Getenv("TRACING_ENDPOINT")
+	cfg.tracing.Insecure, _ = strconv.ParseBool(os.Getenv("TRACING_INSECURE"))
+	cfg.tracing.ServiceName = "slx"
+	sampleRatio, err := strconv.ParseFloat(os.Getenv("TRACING_SAMPLE_RATIO"), 64)
+	if err != nil || sampleRatio <= 0 || sampleRatio > 1 {
+		sampleRatio = 1
+	}
+	cfg.tracing.SampleRatio = sampleRatio
+
+	cfg.pii.hashKey = os.Getenv("PII_HASH_KEY")
+	cfg.pii.encryptionKeys = os.Getenv("PII_ENCRYPTION_KEYS")
+	cfg.pii.encryptionKeyID = os.Getenv("PII_ENCRYPTION_KEY_ID")
+
+	cfg.environment = os.Getenv("SLX_ENV")
+
 	cfg.aggPath = os.Getenv("AGG_PATH")
 	if cfg.aggPath == "" {
 		panic("AGG_PATH must be set in production environment")
@@ -0,0 +1,677 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"slices"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/salesworks/s-works/slx/internal/database"
+	"github.com/salesworks/s-works/slx/internal/repository"
+	"github.com/salesworks/s-works/slx/internal/tracker"
+	"go.etcd.io/bbolt"
+)
+
+type checkpointConfig struct {
+	backend    string
+	natsBucket string
+}
+
+// checkpointRepository is a tracker repository that can list its checkpoints and owns
+// resources released on shutdown
+type checkpointRepository interface {
+	tracker.TrackerRepository
+	// ListChangeVersions returns the change version of every registered aggregate
+	ListChangeVersions(ctx context.Context) (map[string]int64, error)
+	// DeleteAggregate removes the checkpoint and history of an aggregate
+	DeleteAggregate(ctx context.Context, aggregateName string) error
+	// Check reports whether the backend can be reached
+	Check(ctx context.Context) error
+	Close() error
+}
+
+// newCheckpointRepository opens the checkpoint repository of the backend, the postgres
+// connection is only required by the postgres backend
+func newCheckpointRepository(
+	ctx context.Context, backend string, cfg config, pg *sql.DB, logger *slog.Logger,
+) (checkpointRepository, error) {
+	switch backend {
+	case "bbolt":
+		repo, err := repository.NewBBoltRepository(cfg.db.path, logger)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case "postgres":
+		if pg == nil {
+			return nil, fmt.Errorf("postgres checkpoint backend requires a postgres connection")
+		}
+		repo, err := repository.NewPostgresRepository(ctx, pg, logger)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case "nats":
+		conn, err := connectNats(cfg.pub)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := repository.NewNatsKVRepository(ctx, conn, cfg.checkpoint.natsBucket, logger)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return repo, nil
+	default:
+		return nil, fmt.Errorf("unknown checkpoint backend '%s'", backend)
+	}
+}
+
+// checkpointsUsage lists the checkpoints subcommands
+const checkpointsUsage = "usage: checkpoints list|get|set|reset|export|import|reconcile|migrate [flags]"
+
+// Checkpoints administers the aggregate checkpoints, args are the command line arguments
+// following "checkpoints"
+func Checkpoints(env string, logPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(checkpointsUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return listCheckpoints(env, logPath, args[1:])
+	case "get":
+		return getCheckpoint(env, logPath, args[1:])
+	case "set":
+		return setCheckpoint(env, logPath, args[1:])
+	case "reset":
+		return resetCheckpoint(env, logPath, args[1:])
+	case "export":
+		return exportCheckpoints(env, logPath, args[1:])
+	case "import":
+		return importCheckpoints(env, logPath, args[1:])
+	case "reconcile":
+		return reconcileCheckpoints(env, logPath, args[1:])
+	case "migrate":
+		return migrateCheckpoints(env, logPath, args[1:])
+	default:
+		return fmt.Errorf("unknown checkpoints command '%s', %s", args[0], checkpointsUsage)
+	}
+}
+
+// checkpointSession is an open checkpoint repository together with the resources it needs
+type checkpointSession struct {
+	ctx     context.Context
+	repo    checkpointRepository
+	closers []func()
+	// shared is set for backends running instances use at the same time as the session
+	shared bool
+	// admin changes checkpoints through a running instance, nil without ADMIN_ADDR. A session
+	// without repo changes a bbolt file held by the instance only through it.
+	admin *adminClient
+}
+
+// openCheckpoints opens the checkpoint repository of the backend, an empty backend uses the
+// configured one. A bbolt file held by a running instance is reported instead of waited for,
+// unless the session is opened to update change versions and ADMIN_ADDR is set: the session
+// then has no repository and updates the change versions through the admin server.
+func openCheckpoints(env string, logPath string, backend string, update bool) (*checkpointSession, error) {
+	cfg := loadConfig()
+	logger := newLogger(env, logPath)
+	if backend == "" {
+		backend = cfg.checkpoint.backend
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	session := &checkpointSession{ctx: ctx, closers: []func(){stop}}
+	if cfg.admin.addr != "" {
+		session.admin = newAdminClient(cfg.admin)
+	}
+
+	var pg *sql.DB
+	if backend == "postgres" {
+		postgres, err := database.NewPostgres(ctx, cfg.pg.uri, logger)
+		if err != nil {
+			session.Close()
+			return nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+		}
+		session.closers = append(session.closers, postgres.Close)
+		pg = postgres.Pool
+	}
+
+	repo, err := newCheckpointRepository(ctx, backend, cfg, pg, logger)
+	if errors.Is(err, bbolt.ErrTimeout) && session.admin != nil {
+		if update {
+			session.shared = true
+			return session, nil
+		}
+		session.Close()
+		return nil, fmt.Errorf(
+			"failed to open %s checkpoints: %w, the file is held by a running instance, see its GET http://%s/status",
+			backend, err, cfg.admin.addr,
+		)
+	}
+	if err != nil {
+		session.Close()
+		return nil, fmt.Errorf("failed to open %s checkpoints: %w", backend, err)
+	}
+	session.repo = repo
+	session.closers = append(session.closers, func() { repo.Close() })
+	session.shared = backend != "bbolt"
+	return session, nil
+}
+
+// Close releases the session resources in reverse order
+func (s *checkpointSession) Close() {
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		s.closers[i]()
+	}
+}
+
+// checkpointNames returns the registered aggregate names sorted
+func (s *checkpointSession) checkpointNames() ([]string, error) {
+	versions, err := s.repo.ListChangeVersions(s.ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// listCheckpoints prints the checkpoint of every registered aggregate
+func listCheckpoints(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints list", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	session, err := openCheckpoints(env, logPath, *backend, false)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	names, err := session.checkpointNames()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "AGGREGATE\tVERSION\tPREVIOUS\tLAST RUN\tROWS\tERROR")
+	for _, name := range names {
+		checkpoint, err := session.repo.GetCheckpoint(session.ctx, name)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%d\t%s\n",
+			name, checkpoint.ChangeVersion, checkpoint.PreviousVersion,
+			formatTime(checkpoint.LastRunEnd), checkpoint.RowsFetched, checkpoint.LastError,
+		)
+	}
+	return w.Flush()
+}
+
+// getCheckpoint prints the checkpoint and the recent cycles of an aggregate as JSON
+func getCheckpoint(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints get", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	aggregate := fs.String("aggregate", "", "name of the aggregate (required)")
+	history := fs.Int("history", 10, "number of recent cycles to include")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *aggregate == "" {
+		return fmt.Errorf("checkpoints get requires -aggregate")
+	}
+
+	session, err := openCheckpoints(env, logPath, *backend, false)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	checkpoint, err := session.repo.GetCheckpoint(session.ctx, *aggregate)
+	if err != nil {
+		return err
+	}
+	cycles, err := session.repo.CycleHistory(session.ctx, *aggregate, *history)
+	if err != nil {
+		return err
+	}
+	if cycles == nil {
+		cycles = []tracker.CycleRecord{}
+	}
+
+	return writeJSON(os.Stdout, archivedCheckpoint{checkpoint, cycles})
+}
+
+// setCheckpoint moves the change version of an aggregate, the next cycle resumes from it. A
+// running instance is changed through its admin server (see updateCheckpoint).
+func setCheckpoint(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints set", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	aggregate := fs.String("aggregate", "", "name of the aggregate (required)")
+	version := fs.Int64("version", -1, "change version to resume from (required)")
+	force := fs.Bool("force", false, forceUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *aggregate == "" || *version < 0 {
+		return fmt.Errorf("checkpoints set requires -aggregate and -version")
+	}
+
+	session, err := openCheckpoints(env, logPath, *backend, true)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return updateCheckpoint(session, *aggregate, *version, *force)
+}
+
+// resetCheckpoint moves the change version of an aggregate back to 0 or to its previous version
+func resetCheckpoint(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints reset", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	aggregate := fs.String("aggregate", "", "name of the aggregate (required)")
+	previous := fs.Bool("previous", false, "roll back to the version before the last advance instead of 0")
+	force := fs.Bool("force", false, forceUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *aggregate == "" {
+		return fmt.Errorf("checkpoints reset requires -aggregate")
+	}
+
+	session, err := openCheckpoints(env, logPath, *backend, true)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var version int64
+	if *previous {
+		checkpoint, err := session.checkpoint(*aggregate)
+		if err != nil {
+			return err
+		}
+		version = checkpoint.PreviousVersion
+	}
+	return updateCheckpoint(session, *aggregate, version, *force)
+}
+
+// forceUsage describes the -force flag of the commands writing change versions
+const forceUsage = "write the change version of a shared backend directly when no running instance is reachable"
+
+// checkpoint returns the checkpoint of an aggregate from the repository, or from the running
+// instance when the session has no repository
+func (s *checkpointSession) checkpoint(aggregate string) (tracker.Checkpoint, error) {
+	if s.repo == nil {
+		return s.admin.checkpoint(s.ctx, aggregate)
+	}
+	return s.repo.GetCheckpoint(s.ctx, aggregate)
+}
+
+// updateCheckpoint sets the change version of an aggregate. A cycle of a running instance
+// sharing a postgres or nats backend could overwrite a direct write right after it was made, so
+// the change is sent to PUT /checkpoints/{aggregate} of the instance at ADMIN_ADDR, which waits
+// for the cycle of the aggregate. Without a reachable instance the change is only written with
+// force. A bbolt file is locked by the running instance, so it is either written directly or,
+// while the instance holds it, only changed through the admin server. Other instances sharing
+// the backend are not locked by the admin server, stop them before changing checkpoints.
+func updateCheckpoint(session *checkpointSession, aggregate string, version int64, force bool) error {
+	current, err := session.checkpoint(aggregate)
+	if err != nil {
+		return err
+	}
+	if session.shared {
+		err := session.admin.setChangeVersion(session.ctx, aggregate, version)
+		switch {
+		case err == nil:
+			fmt.Printf("%s: %d -> %d (through the running instance)\n", aggregate, current.ChangeVersion, version)
+			return nil
+		case session.repo == nil:
+			return fmt.Errorf("failed to set the change version through the admin server: %w", err)
+		case errors.Is(err, tracker.ErrAggregateNotConfigured):
+			// the instance runs no cycles of the aggregate
+		case errors.Is(err, errNoInstance) && force:
+			fmt.Fprintf(os.Stderr, "%s: %v, writing the change version directly\n", aggregate, err)
+		case errors.Is(err, errNoInstance):
+			return fmt.Errorf(
+				"%w: a cycle of a running instance could overwrite the change version, "+
+					"set ADMIN_ADDR of the instance or stop all instances and use -force", err,
+			)
+		default:
+			return fmt.Errorf("failed to set the change version through the admin server: %w", err)
+		}
+	}
+	if err := session.repo.UpdateChangeVersion(session.ctx, aggregate, version); err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d -> %d\n", aggregate, current.ChangeVersion, version)
+	return nil
+}
+
+// exportCheckpoints writes the checkpoint of every registered aggregate as JSON
+func exportCheckpoints(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints export", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	file := fs.String("file", "", "file to write to, defaults to standard output")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	session, err := openCheckpoints(env, logPath, *backend, false)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	names, err := session.checkpointNames()
+	if err != nil {
+		return err
+	}
+
+	checkpoints := make([]tracker.Checkpoint, 0, len(names))
+	for _, name := range names {
+		checkpoint, err := session.repo.GetCheckpoint(session.ctx, name)
+		if err != nil {
+			return err
+		}
+		checkpoints = append(checkpoints, checkpoint)
+	}
+
+	if *file == "" {
+		return writeJSON(os.Stdout, checkpoints)
+	}
+	f, err := os.Create(*file)
+	if err != nil {
+		return fmt.Errorf("failed to create export file: %w", err)
+	}
+	if err := writeJSON(f, checkpoints); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write export file: %w", err)
+	}
+	fmt.Printf("%d checkpoints exported to %s\n", len(checkpoints), *file)
+	return nil
+}
+
+// importCheckpoints restores change versions from an export, aggregates missing in the
+// backend are registered. Only the change version is applied, run details are left as is.
+func importCheckpoints(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints import", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	file := fs.String("file", "", "file to read from, defaults to standard input")
+	force := fs.Bool("force", false, forceUsage)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var r io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return fmt.Errorf("failed to open import file: %w", err)
+		}
+		defer f.Close()
+		r = f
+	}
+
+	// the history of get and reconcile archives is accepted and ignored
+	var checkpoints []tracker.Checkpoint
+	if err := json.NewDecoder(r).Decode(&checkpoints); err != nil {
+		return fmt.Errorf("failed to parse checkpoints: %w", err)
+	}
+	for _, checkpoint := range checkpoints {
+		if checkpoint.Aggregate == "" {
+			return fmt.Errorf("checkpoint without aggregate name")
+		}
+		if checkpoint.ChangeVersion < 0 {
+			return fmt.Errorf("invalid change version %d for aggregate '%s'", checkpoint.ChangeVersion, checkpoint.Aggregate)
+		}
+	}
+
+	session, err := openCheckpoints(env, logPath, *backend, true)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	for _, checkpoint := range checkpoints {
+		// a running instance holding the bbolt file registers its aggregates itself
+		if session.repo != nil {
+			err := session.repo.RegisterAggregates(session.ctx, []string{checkpoint.Aggregate})
+			if err != nil {
+				return fmt.Errorf("failed to register aggregate '%s': %w", checkpoint.Aggregate, err)
+			}
+		}
+		if err := updateCheckpoint(session, checkpoint.Aggregate, checkpoint.ChangeVersion, *force); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%d checkpoints imported\n", len(checkpoints))
+	return nil
+}
+
+// archivedCheckpoint is a checkpoint together with its cycle history, the format of get and
+// of the reconcile archive. It can be imported like an export.
+type archivedCheckpoint struct {
+	tracker.Checkpoint
+	History []tracker.CycleRecord `json:"history"`
+}
+
+// orphanedCheckpoints returns the sorted names of registered aggregates that are no longer
+// configured
+func orphanedCheckpoints(
+	ctx context.Context, repo checkpointRepository, aggregates []tracker.Aggregate,
+) ([]string, error) {
+	versions, err := repo.ListChangeVersions(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+
+	for _, name := range tracker.Names(aggregates) {
+		delete(versions, name)
+	}
+
+	orphans := make([]string, 0, len(versions))
+	for name := range versions {
+		orphans = append(orphans, name)
+	}
+	sort.Strings(orphans)
+	return orphans, nil
+}
+
+// reportOrphanedCheckpoints logs the checkpoints left behind by aggregates removed from the
+// configuration, failing to list them does not stop the service
+func reportOrphanedCheckpoints(
+	ctx context.Context, repo checkpointRepository, aggregates []tracker.Aggregate, logger *slog.Logger,
+) {
+	orphans, err := orphanedCheckpoints(ctx, repo, aggregates)
+	if err != nil {
+		logger.Warn("failed to look for orphaned checkpoints", "error", err)
+		return
+	}
+	for _, name := range orphans {
+		logger.Warn("checkpoint of an unconfigured aggregate, declare renamed_from or run checkpoints reconcile",
+			"name", name)
+	}
+}
+
+// reconcileCheckpoints reports checkpoints of aggregates missing in the configuration and
+// optionally archives them to a JSON file before removing them
+func reconcileCheckpoints(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints reconcile", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	archive := fs.String("archive", "", "write orphaned checkpoints to the file and remove them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	aggregates, err := tracker.LoadAggregates(loadConfig().aggPath)
+	if err != nil {
+		return err
+	}
+
+	session, err := openCheckpoints(env, logPath, *backend, false)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	orphans, err := orphanedCheckpoints(session.ctx, session.repo, aggregates)
+	if err != nil {
+		return err
+	}
+	if len(orphans) == 0 {
+		fmt.Println("no orphaned checkpoints")
+		return nil
+	}
+
+	archived := make([]archivedCheckpoint, 0, len(orphans))
+	for _, name := range orphans {
+		checkpoint, err := session.repo.GetCheckpoint(session.ctx, name)
+		if err != nil {
+			return err
+		}
+		cycles, err := session.repo.CycleHistory(session.ctx, name, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		archived = append(archived, archivedCheckpoint{checkpoint, cycles})
+		fmt.Printf("%s: %d (last run %s)\n", name, checkpoint.ChangeVersion, formatTime(checkpoint.LastRunEnd))
+	}
+
+	if *archive == "" {
+		fmt.Printf("%d orphaned checkpoints, use -archive <file> to remove them\n", len(orphans))
+		return nil
+	}
+
+	// the archive is written completely before anything is removed
+	f, err := os.OpenFile(*archive, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create archive file: %w", err)
+	}
+	if err := writeJSON(f, archived); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write archive file: %w", err)
+	}
+
+	for _, name := range orphans {
+		if err := session.repo.DeleteAggregate(session.ctx, name); err != nil {
+			return fmt.Errorf("failed to remove aggregate '%s': %w", name, err)
+		}
+	}
+	fmt.Printf("%d orphaned checkpoints archived to %s and removed\n", len(orphans), *archive)
+	return nil
+}
+
+func writeJSON(w io.Writer, v any) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
+
+// formatTime formats a time for tables, the zero time is shown as a dash
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format(time.DateTime)
+}
+
+// migrateCheckpoints copies the change version of every aggregate from one backend to another
+func migrateCheckpoints(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("checkpoints migrate", flag.ContinueOnError)
+	from := fs.String("from", "", "backend to copy the checkpoints from: bbolt, postgres or nats")
+	to := fs.String("to", "", "backend to copy the checkpoints to: bbolt, postgres or nats")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *from == "" || *to == "" {
+		return fmt.Errorf("checkpoints migrate requires -from and -to")
+	}
+	if *from == *to {
+		return fmt.Errorf("source and target backend must differ")
+	}
+
+	cfg := loadConfig()
+	logger := newLogger(env, logPath)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	var pg *sql.DB
+	if slices.Contains([]string{*from, *to}, "postgres") {
+		postgres, err := database.NewPostgres(ctx, cfg.pg.uri, logger)
+		if err != nil {
+			return fmt.Errorf("failed to connect to postgres database: %w", err)
+		}
+		defer postgres.Close()
+		pg = postgres.Pool
+	}
+
+	source, err := newCheckpointRepository(ctx, *from, cfg, pg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to open source backend: %w", err)
+	}
+	defer source.Close()
+
+	target, err := newCheckpointRepository(ctx, *to, cfg, pg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to open target backend: %w", err)
+	}
+	defer target.Close()
+
+	versions, err := source.ListChangeVersions(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoints: %w", err)
+	}
+
+	names := make([]string, 0, len(versions))
+	for name := range versions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if err := target.RegisterAggregates(ctx, names); err != nil {
+		return fmt.Errorf("failed to register aggregates: %w", err)
+	}
+	for _, name := range names {
+		if err := target.UpdateChangeVersion(ctx, name, versions[name]); err != nil {
+			return fmt.Errorf("failed to migrate aggregate '%s': %w", name, err)
+		}
+		fmt.Printf("%s: %d\n", name, versions[name])
+	}
+
+	fmt.Printf("%d checkpoints migrated from %s to %s\n", len(names), *from, *to)
+	return nil
+}
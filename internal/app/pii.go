@@ -0,0 +1,66 @@
+package app
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/salesworks/s-works/slx/internal/tracker"
+	"github.com/salesworks/s-works/slx/pkg/pii"
+)
+
+type piiConfig struct {
+	// hashKey is the base64 encoded HMAC key of the hash policy
+	hashKey string
+	// encryptionKeys are the id:base64 AES keys of the encrypt policy
+	encryptionKeys string
+	// encryptionKeyID is the key new values are encrypted with
+	encryptionKeyID string
+}
+
+// newProtector creates the protector of the PII policies from the configured keys, policies
+// whose keys are not configured fail when the aggregates are prepared
+func newProtector(cfg piiConfig) (*pii.Protector, error) {
+	hashKey, err := base64.StdEncoding.DecodeString(cfg.hashKey)
+	if err != nil {
+		return nil, fmt.Errorf("PII_HASH_KEY is not base64 encoded: %w", err)
+	}
+
+	var keyring *pii.Keyring
+	if cfg.encryptionKeys != "" {
+		keys, err := pii.ParseKeys(cfg.encryptionKeys)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII_ENCRYPTION_KEYS: %w", err)
+		}
+		current := cfg.encryptionKeyID
+		if current == "" && len(keys) == 1 {
+			for id := range keys {
+				current = id
+			}
+		}
+		if current == "" {
+			return nil, fmt.Errorf("PII_ENCRYPTION_KEY_ID must be set when there is more than one key")
+		}
+		keyring, err = pii.NewKeyring(keys, current)
+		if err != nil {
+			return nil, fmt.Errorf("invalid PII_ENCRYPTION_KEYS: %w", err)
+		}
+	}
+	return pii.NewProtector(hashKey, keyring), nil
+}
+
+// protectAggregates prepares the PII policies of aggregates loaded without a tracker
+func protectAggregates(cfg piiConfig, aggregates []tracker.Aggregate) error {
+	protector, err := newProtector(cfg)
+	if err != nil {
+		return err
+	}
+	for i := range aggregates {
+		if len(aggregates[i].PII) == 0 {
+			continue
+		}
+		if err := aggregates[i].Protect(protector); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package app
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/salesworks/s-works/slx/internal/dispatcher"
+	"github.com/salesworks/s-works/slx/internal/messaging"
+)
+
+type publisherConfig struct {
+	names          []string
+	natsURL        string
+	natsCreds      string
+	webhookURL     string
+	webhookToken   string
+	webhookTimeout time.Duration
+}
+
+// newPublisher builds the publisher chain from the publisher names in the order they are given
+func newPublisher(
+	cfg publisherConfig, names []string, pg *sql.DB, logger *slog.Logger,
+) (*dispatcher.MultiPublisher, error) {
+	publishers := make([]dispatcher.NamedPublisher, 0, len(names))
+	closeAll := func() {
+		dispatcher.NewMultiPublisher(publishers...).Close()
+	}
+
+	for _, name := range names {
+		var publisher dispatcher.Publisher
+		switch name {
+		case "postgres":
+			if pg == nil {
+				closeAll()
+				return nil, fmt.Errorf("postgres publisher requires a postgres connection")
+			}
+			publisher = messaging.NewPostgresPublisher(pg, logger)
+		case "nats":
+			conn, err := connectNats(cfg)
+			if err != nil {
+				closeAll()
+				return nil, err
+			}
+			publisher = messaging.NewNatsPublisher(conn, logger)
+		case "webhook":
+			if cfg.webhookURL == "" {
+				closeAll()
+				return nil, fmt.Errorf("WEBHOOK_URL must be set to use the webhook publisher")
+			}
+			publisher = messaging.NewWebhookPublisher(cfg.webhookURL, cfg.webhookToken, cfg.webhookTimeout, logger)
+		default:
+			closeAll()
+			return nil, fmt.Errorf("unknown publisher '%s'", name)
+		}
+		publishers = append(publishers, dispatcher.NamedPublisher{Name: name, Publisher: publisher})
+	}
+
+	return dispatcher.NewMultiPublisher(publishers...), nil
+}
+
+func connectNats(cfg publisherConfig) (*nats.Conn, error) {
+	if cfg.natsURL == "" {
+		return nil, fmt.Errorf("NATS_URL must be set to use the nats publisher")
+	}
+
+	options := []nats.Option{nats.Name("SLX")}
+	if cfg.natsCreds != "" {
+		options = append(options, nats.UserCredentials(cfg.natsCreds))
+	}
+
+	conn, err := nats.Connect(cfg.natsURL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to nats: %w", err)
+	}
+	return conn, nil
+}
+
+// withoutPublisher returns a copy of the publisher names without the given name
+func withoutPublisher(names []string, name string) []string {
+	return slices.DeleteFunc(slices.Clone(names), func(n string) bool {
+		return n == name
+	})
+}
+
+// parseList splits a comma separated list and drops empty entries
+func parseList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
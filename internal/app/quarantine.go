@@ -0,0 +1,231 @@
+package app
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/salesworks/s-works/slx/internal/database"
+	"github.com/salesworks/s-works/slx/internal/tracker"
+)
+
+// quarantineUsage lists the quarantine subcommands
+const quarantineUsage = "usage: quarantine list|reprocess|discard [flags]"
+
+// Quarantine administers the rows quarantined by aggregates with on_error: quarantine, args are
+// the command line arguments following "quarantine"
+func Quarantine(env string, logPath string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(quarantineUsage)
+	}
+
+	switch args[0] {
+	case "list":
+		return listQuarantined(env, logPath, args[1:])
+	case "reprocess":
+		return reprocessQuarantined(env, logPath, args[1:])
+	case "discard":
+		return discardQuarantined(env, logPath, args[1:])
+	default:
+		return fmt.Errorf("unknown quarantine command '%s', %s", args[0], quarantineUsage)
+	}
+}
+
+// quarantineSession is a checkpoint session whose backend stores quarantined rows
+type quarantineSession struct {
+	*checkpointSession
+	quarantine tracker.Quarantine
+}
+
+// openQuarantine opens the quarantine of the checkpoint backend, an empty backend uses the
+// configured one
+func openQuarantine(env string, logPath string, backend string) (*quarantineSession, error) {
+	session, err := openCheckpoints(env, logPath, backend, false)
+	if err != nil {
+		return nil, err
+	}
+	quarantine, ok := session.repo.(tracker.Quarantine)
+	if !ok {
+		session.Close()
+		return nil, fmt.Errorf("checkpoint backend does not support quarantine")
+	}
+	return &quarantineSession{checkpointSession: session, quarantine: quarantine}, nil
+}
+
+// selectQuarantined returns the quarantined row with the ID or the rows of the aggregate
+func (s *quarantineSession) selectQuarantined(aggregate string, id int64) ([]tracker.QuarantinedRow, error) {
+	rows, err := s.quarantine.ListQuarantined(s.ctx, aggregate)
+	if err != nil {
+		return nil, err
+	}
+	if id == 0 {
+		return rows, nil
+	}
+	for _, row := range rows {
+		if row.ID == id {
+			return []tracker.QuarantinedRow{row}, nil
+		}
+	}
+	return nil, fmt.Errorf("quarantined row %d not found", id)
+}
+
+// listQuarantined prints the quarantined rows
+func listQuarantined(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("quarantine list", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	aggregate := fs.String("aggregate", "", "only list the rows of the aggregate")
+	asJSON := fs.Bool("json", false, "print the rows with their values as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	session, err := openQuarantine(env, logPath, *backend)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	rows, err := session.quarantine.ListQuarantined(session.ctx, *aggregate)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		if rows == nil {
+			rows = []tracker.QuarantinedRow{}
+		}
+		return writeJSON(os.Stdout, rows)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tAGGREGATE\tVERSION\tQUARANTINED\tERROR")
+	for _, row := range rows {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%s\t%s\n",
+			row.ID, row.Aggregate, row.ChangeVersion, formatTime(row.QuarantinedAt), row.Error,
+		)
+	}
+	return w.Flush()
+}
+
+// reprocessQuarantined maps quarantined rows again with the current aggregate configuration,
+// publishes them and removes them from the quarantine. Rows that are still invalid are kept.
+func reprocessQuarantined(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("quarantine reprocess", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	aggregate := fs.String("aggregate", "", "reprocess the rows of the aggregate")
+	id := fs.Int64("id", 0, "reprocess the row with the ID")
+	publishers := fs.String("publishers", "", "comma separated publishers, defaults to PUBLISHERS")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *aggregate == "" && *id == 0 {
+		return fmt.Errorf("quarantine reprocess requires -aggregate or -id")
+	}
+
+	cfg := loadConfig()
+	logger := newLogger(env, logPath)
+	aggregates, err := tracker.LoadAggregates(cfg.aggPath)
+	if err != nil {
+		return err
+	}
+	if err := protectAggregates(cfg.pii, aggregates); err != nil {
+		return err
+	}
+	if err := tracker.CheckEnvironment(aggregates, cfg.environment); err != nil {
+		return err
+	}
+
+	session, err := openQuarantine(env, logPath, *backend)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	rows, err := session.selectQuarantined(*aggregate, *id)
+	if err != nil {
+		return err
+	}
+
+	names := parseList(*publishers)
+	if len(names) == 0 {
+		names = cfg.pub.names
+	}
+	var pg *sql.DB
+	if slices.Contains(names, "postgres") {
+		postgres, err := database.NewPostgres(session.ctx, cfg.pg.uri, logger)
+		if err != nil {
+			return fmt.Errorf("failed to connect to postgres database: %w", err)
+		}
+		defer postgres.Close()
+		pg = postgres.Pool
+	}
+	publisher, err := newPublisher(cfg.pub, names, pg, logger)
+	if err != nil {
+		return fmt.Errorf("failed to initialize publishers: %w", err)
+	}
+	defer publisher.Close()
+
+	var published int
+	for _, row := range rows {
+		i := slices.IndexFunc(aggregates, func(a tracker.Aggregate) bool { return a.Name == row.Aggregate })
+		if i < 0 {
+			fmt.Printf("%d: aggregate '%s' is not configured, kept\n", row.ID, row.Aggregate)
+			continue
+		}
+		jobs, err := tracker.QuarantinedJobs(session.ctx, aggregates[i], row, cfg.environment)
+		if err != nil {
+			fmt.Printf("%d: still invalid, kept: %v\n", row.ID, err)
+			continue
+		}
+		for _, job := range jobs {
+			if err := publisher.Publish(session.ctx, job.EventChannel, job.EventEnvelope); err != nil {
+				return fmt.Errorf("failed to publish quarantined row %d: %w", row.ID, err)
+			}
+		}
+		if err := session.quarantine.DeleteQuarantined(session.ctx, row.ID); err != nil {
+			return err
+		}
+		published++
+		if len(jobs) == 0 {
+			fmt.Printf("%d: dropped by the aggregate filter, removed\n", row.ID)
+			continue
+		}
+		fmt.Printf("%d: %d events published to %s\n", row.ID, len(jobs), jobs[0].EventChannel)
+	}
+	fmt.Printf("%d of %d quarantined rows reprocessed\n", published, len(rows))
+	return nil
+}
+
+// discardQuarantined removes quarantined rows without publishing them
+func discardQuarantined(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("quarantine discard", flag.ContinueOnError)
+	backend := fs.String("backend", "", "checkpoint backend, defaults to CHECKPOINT_BACKEND")
+	aggregate := fs.String("aggregate", "", "discard the rows of the aggregate")
+	id := fs.Int64("id", 0, "discard the row with the ID")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *aggregate == "" && *id == 0 {
+		return fmt.Errorf("quarantine discard requires -aggregate or -id")
+	}
+
+	session, err := openQuarantine(env, logPath, *backend)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	rows, err := session.selectQuarantined(*aggregate, *id)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := session.quarantine.DeleteQuarantined(session.ctx, row.ID); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("%d quarantined rows discarded\n", len(rows))
+	return nil
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/salesworks/s-works/slx/internal/database"
+	"github.com/salesworks/s-works/slx/internal/dispatcher"
+	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/salesworks/s-works/slx/internal/replay"
+	"github.com/salesworks/s-works/slx/internal/tracker"
+)
+
+// Replay resends events stored in Postgres through the publisher chain without querying
+// SQL Server, args are the command line arguments following "replay"
+func Replay(env string, logPath string, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	aggregate := fs.String("aggregate", "", "name of the aggregate to replay (required)")
+	keys := fs.String("keys", "", "comma separated list of aggregate keys")
+	fromVersion := fs.Int64("from-version", 0, "replay events with change version greater or equal")
+	toVersion := fs.Int64("to-version", 0, "replay events with change version less or equal")
+	since := fs.String("since", "", "replay events stored at or after the time (RFC3339 or YYYY-MM-DD)")
+	until := fs.String("until", "", "replay events stored before the time (RFC3339 or YYYY-MM-DD)")
+	publishers := fs.String("publishers", "", "comma separated publishers, defaults to PUBLISHERS without postgres")
+	rate := fs.Float64("rate", 0, "maximum number of events published per second, 0 means unlimited")
+	dryRun := fs.Bool("dry-run", false, "log the matching events without publishing them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *aggregate == "" {
+		return fmt.Errorf("replay requires -aggregate")
+	}
+	filter := replay.Filter{
+		Aggregate:   *aggregate,
+		Keys:        parseList(*keys),
+		FromVersion: *fromVersion,
+		ToVersion:   *toVersion,
+	}
+	var err error
+	if filter.Since, err = parseTime(*since); err != nil {
+		return fmt.Errorf("invalid -since: %w", err)
+	}
+	if filter.Until, err = parseTime(*until); err != nil {
+		return fmt.Errorf("invalid -until: %w", err)
+	}
+
+	cfg := loadConfig()
+	logger := newLogger(env, logPath)
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	postgres, err := database.NewPostgres(ctx, cfg.pg.uri, logger)
+	if err != nil {
+		return fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer postgres.Close()
+	if err := messaging.MigrateEvents(ctx, postgres.Pool); err != nil {
+		return err
+	}
+
+	names := parseList(*publishers)
+	if len(names) == 0 {
+		names = withoutPublisher(cfg.pub.names, "postgres")
+	}
+	if slices.Contains(names, "postgres") {
+		return fmt.Errorf("postgres is the replay source and cannot be used as a replay publisher")
+	}
+
+	var publisher dispatcher.Publisher = dispatcher.NewMultiPublisher()
+	if !*dryRun {
+		if len(names) == 0 {
+			return fmt.Errorf("no publishers to replay to, set -publishers or PUBLISHERS")
+		}
+		chain, err := newPublisher(cfg.pub, names, nil, logger)
+		if err != nil {
+			return fmt.Errorf("failed to initialize publishers: %w", err)
+		}
+		defer chain.Close()
+		publisher = chain
+	}
+
+	options := replay.Options{Rate: *rate, DryRun: *dryRun}
+	aggregates, err := tracker.LoadAggregates(cfg.aggPath)
+	if err != nil {
+		return err
+	}
+	if err := tracker.CheckEnvironment(aggregates, cfg.environment); err != nil {
+		return err
+	}
+	// events of an aggregate that is still configured are published on its subject template
+	if i := slices.IndexFunc(aggregates, func(a tracker.Aggregate) bool { return a.Name == *aggregate }); i >= 0 {
+		options.Subject = func(envelope *messaging.EventEnvelope) (string, error) {
+			return aggregates[i].ReplaySubject(envelope, cfg.environment)
+		}
+	}
+
+	replayer := replay.NewReplayer(postgres.Pool, publisher, logger)
+	count, err := replayer.Run(ctx, filter, options)
+	if err != nil {
+		return fmt.Errorf("replay failed after %d events: %w", count, err)
+	}
+
+	if *dryRun {
+		fmt.Printf("%d events would be replayed\n", count)
+	} else {
+		fmt.Printf("%d events replayed to %v\n", count, names)
+	}
+	return nil
+}
+
+// parseTime parses an RFC3339 timestamp or a date, an empty value returns the zero time
+func parseTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation(time.DateOnly, s, time.Local)
+}
@@ -4,8 +4,13 @@ import (
 	"context"
 	"log/slog"
 	"sync"
+	"time"
 
 	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/salesworks/s-works/slx/internal/metrics"
+	"github.com/salesworks/s-works/slx/internal/telemetry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Publisher defines the interface for any service that can publish an event to an external system
@@ -19,8 +24,12 @@ type Publisher interface {
 type Job struct {
 	EventChannel  string
 	EventEnvelope *messaging.EventEnvelope
+	// enqueued is when the job entered the queue, the start of its queue wait span
+	enqueued time.Time
 }
 
+var tracer = telemetry.Tracer("dispatcher")
+
 // Dispatcher manages a pool of workers to process jobs from a queue.
 type Dispatcher struct {
 	numWorkers   int
@@ -33,6 +42,7 @@ type Dispatcher struct {
 
 // NewDispatcher creates and initializes a new Dispatcher.
 func NewDispatcher(numWorkers, queSize int, publisher Publisher, logger *slog.Logger) *Dispatcher {
+	metrics.QueueCapacity.Set(float64(queSize))
 	return &Dispatcher{
 		numWorkers: numWorkers,
 		jobQueue:   make(chan Job, queSize),
@@ -43,6 +53,7 @@ func NewDispatcher(numWorkers, queSize int, publisher Publisher, logger *slog.Lo
 
 // Start launches the worker pool.
 func (d *Dispatcher) Start() {
+	metrics.Workers.Add(float64(d.numWorkers))
 	d.workerWg.Add(d.numWorkers)
 	for i := 0; i < d.numWorkers; i++ {
 		go d.worker(i + 1)
@@ -53,29 +64,61 @@ func (d *Dispatcher) Start() {
 // It takes NewTextHandler payload directly from the job and sends it to the publisher.
 func (d *Dispatcher) worker(id int) {
 	defer d.workerWg.Done()
+	defer metrics.Workers.Dec()
 	d.logger.Debug("worker started", "worker_id", id)
 
-	ctx := context.Background()
-
 	for job := range d.jobQueue {
-		if err := d.publisher.Publish(ctx, job.EventChannel, job.EventEnvelope); err != nil {
+		metrics.QueueDepth.Dec()
+		metrics.BusyWorkers.Inc()
+		if err := d.publish(job); err != nil {
 			d.logger.Error(
 				"failed to publish event",
 				"error", err,
 				"channel", job.EventChannel,
 				"event", job.EventEnvelope,
 			)
-			continue
 		}
+		metrics.BusyWorkers.Dec()
 	}
 	d.logger.Debug("worker finished", "worker_id", id)
 }
 
+// publish sends the job to the publisher in the trace of the cycle that produced the event.
+func (d *Dispatcher) publish(job Job) error {
+	ctx := job.EventEnvelope.TraceContext(context.Background())
+	attributes := trace.WithAttributes(
+		attribute.String("messaging.destination.name", job.EventChannel),
+		attribute.String("event.type", job.EventEnvelope.EventType),
+		attribute.String("event.id", job.EventEnvelope.EventID),
+	)
+
+	_, wait := tracer.Start(ctx, "dispatcher.queue", trace.WithTimestamp(job.enqueued), attributes)
+	wait.End()
+
+	ctx, span := tracer.Start(ctx, "dispatcher.publish", attributes)
+	defer span.End()
+	err := d.publisher.Publish(ctx, job.EventChannel, job.EventEnvelope)
+	telemetry.RecordError(span, err)
+	return err
+}
+
 // Dispatch adds a new job to the processing queue.
 func (d *Dispatcher) Dispatch(job Job) {
+	job.enqueued = time.Now()
+	metrics.QueueDepth.Inc()
 	d.jobQueue <- job
 }
 
+// QueueDepth returns the number of jobs waiting for a worker.
+func (d *Dispatcher) QueueDepth() int {
+	return len(d.jobQueue)
+}
+
+// QueueCapacity returns the size of the job queue.
+func (d *Dispatcher) QueueCapacity() int {
+	return cap(d.jobQueue)
+}
+
 // Stop initiates a graceful shutdown of the dispatcher.
 func (d *Dispatcher) Stop() {
 	d.shutdownOnce.Do(func() {
@@ -9,6 +9,11 @@ import (
 	"time"
 
 	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // mockPublisher records Publish invocations and signals a WaitGroup.
@@ -80,3 +85,45 @@ func TestDispatcher_ProcessesJobs(t *testing.T) {
 		t.Fatalf("expected %d Publish calls, got %d", numJobs, mp.calls)
 	}
 }
+
+// TestDispatcher_ContinuesEnvelopeTrace verifies that the queue wait and publish spans join the
+// trace stored in the envelope.
+func TestDispatcher_ContinuesEnvelopeTrace(t *testing.T) {
+	// --- Arrange ---
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		// tracers taken before the provider was set keep delegating to it, they stop recording
+		_ = provider.Shutdown(context.Background())
+	})
+
+	ctx, cycle := provider.Tracer("test").Start(context.Background(), "erp.cycle")
+	event := messaging.NewEventEnvelope(
+		"test.created", "C4CA4238A0B923820DCC509A6F75849A", 1, "{}", messaging.WithTraceContext(ctx),
+	)
+	cycle.End()
+	require.NotEmpty(t, event.TraceParent, "the envelope should carry the trace context")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	d := NewDispatcher(1, 1, &mockPublisher{wg: &wg}, logger)
+	d.Start()
+
+	// --- Act ---
+	d.Dispatch(Job{EventChannel: "test", EventEnvelope: event})
+	wg.Wait()
+	d.Stop()
+
+	// --- Assert ---
+	spans := recorder.Ended()
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name())
+		assert.Equal(t, cycle.SpanContext().TraceID(), span.SpanContext().TraceID(), "span %s", span.Name())
+	}
+	assert.ElementsMatch(t, []string{"erp.cycle", "dispatcher.queue", "dispatcher.publish"}, names)
+}
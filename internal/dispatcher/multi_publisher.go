@@ -0,0 +1,72 @@
+package dispatcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/salesworks/s-works/slx/internal/telemetry"
+)
+
+// NamedPublisher pairs a publisher with the name it was configured under
+type NamedPublisher struct {
+	Name      string
+	Publisher Publisher
+}
+
+// MultiPublisher publishes every event to a chain of publishers in order.
+// A failing publisher does not stop the event from reaching the remaining ones.
+type MultiPublisher struct {
+	publishers []NamedPublisher
+}
+
+// NewMultiPublisher creates a publisher chain
+func NewMultiPublisher(publishers ...NamedPublisher) *MultiPublisher {
+	return &MultiPublisher{publishers: publishers}
+}
+
+// Publish sends the envelope to every publisher in the chain and joins their errors
+func (m *MultiPublisher) Publish(ctx context.Context, subject string, envelope *messaging.EventEnvelope) error {
+	var errs []error
+	for _, p := range m.publishers {
+		spanCtx, span := tracer.Start(ctx, "publish "+p.Name)
+		err := p.Publisher.Publish(spanCtx, subject, envelope)
+		telemetry.RecordError(span, err)
+		span.End()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("publisher '%s': %w", p.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes every publisher in the chain
+func (m *MultiPublisher) Close() error {
+	var errs []error
+	for _, p := range m.publishers {
+		if err := p.Publisher.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("publisher '%s': %w", p.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Names returns the names of the publishers in the chain
+func (m *MultiPublisher) Names() []string {
+	names := make([]string, len(m.publishers))
+	for i, p := range m.publishers {
+		names[i] = p.Name
+	}
+	return names
+}
+
+// Len returns the number of publishers in the chain
+func (m *MultiPublisher) Len() int {
+	return len(m.publishers)
+}
+
+// Publishers returns the publishers of the chain
+func (m *MultiPublisher) Publishers() []NamedPublisher {
+	return append([]NamedPublisher(nil), m.publishers...)
+}
@@ -0,0 +1,203 @@
+// Package filter evaluates the small boolean expressions aggregates use to select their events.
+//
+// An expression compares JSON paths with values, e.g.
+//
+//	payload.invoice_status in ['ZAMKNIĘTY', 'ANULOWANY'] and change_operation != 'deleted'
+//
+// Paths are dot separated and index arrays with numbers, a missing path is null. Values are
+// strings in single or double quotes, numbers, true, false and null. The operators are
+// ==, !=, <, <=, >, >=, in, not in, and, or and not with parentheses for grouping. Ordering
+// compares numbers with numbers and strings with strings, any other ordering is false. A path
+// on its own is true when its value is true. Expressions cannot call functions or loop.
+package filter
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// maxLength limits the length of an expression
+const maxLength = 4096
+
+// Filter is a compiled expression
+type Filter struct {
+	expression string
+	root       node
+}
+
+// Compile parses the expression
+func Compile(expression string) (*Filter, error) {
+	if len(expression) > maxLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxLength)
+	}
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	if p.peek().kind == tokenEOF {
+		return nil, fmt.Errorf("expression is empty")
+	}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected '%s' at %d", t.text, t.pos+1)
+	}
+	return &Filter{expression: expression, root: root}, nil
+}
+
+// String returns the expression of the filter
+func (f *Filter) String() string {
+	return f.expression
+}
+
+// Match evaluates the filter against the variables, nested values are JSON decoded maps and
+// slices
+func (f *Filter) Match(vars map[string]any) bool {
+	return f.root.eval(vars) == true
+}
+
+// node is an element of the expression tree
+type node interface {
+	eval(vars map[string]any) any
+}
+
+type literal struct {
+	value any
+}
+
+func (l literal) eval(map[string]any) any {
+	return l.value
+}
+
+type path struct {
+	segments []string
+}
+
+func (p path) eval(vars map[string]any) any {
+	var value any = vars
+	for _, segment := range p.segments {
+		switch v := value.(type) {
+		case map[string]any:
+			value = v[segment]
+		case []any:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil
+			}
+			value = v[i]
+		default:
+			return nil
+		}
+	}
+	return normalize(value)
+}
+
+type negation struct {
+	operand node
+}
+
+func (n negation) eval(vars map[string]any) any {
+	return n.operand.eval(vars) != true
+}
+
+type logical struct {
+	and         bool
+	left, right node
+}
+
+func (l logical) eval(vars map[string]any) any {
+	left := l.left.eval(vars) == true
+	if left != l.and {
+		// false and ..., true or ...
+		return left
+	}
+	return l.right.eval(vars) == true
+}
+
+type comparison struct {
+	op          string
+	left, right node
+}
+
+func (c comparison) eval(vars map[string]any) any {
+	left, right := c.left.eval(vars), c.right.eval(vars)
+	switch c.op {
+	case "==":
+		return equal(left, right)
+	case "!=":
+		return !equal(left, right)
+	}
+
+	var order int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return false
+		}
+		order = cmp.Compare(l, r)
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return false
+		}
+		order = cmp.Compare(l, r)
+	default:
+		return false
+	}
+	switch c.op {
+	case "<":
+		return order < 0
+	case "<=":
+		return order <= 0
+	case ">":
+		return order > 0
+	default:
+		return order >= 0
+	}
+}
+
+type membership struct {
+	operand node
+	values  []any
+}
+
+func (m membership) eval(vars map[string]any) any {
+	value := m.operand.eval(vars)
+	for _, candidate := range m.values {
+		if equal(value, candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize converts JSON numbers to float64, so they compare with number literals
+func normalize(value any) any {
+	switch v := value.(type) {
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	}
+	return value
+}
+
+// equal compares scalars, objects and arrays are never equal
+func equal(a, b any) bool {
+	switch a.(type) {
+	case nil, bool, float64, string:
+		return a == b
+	}
+	return false
+}
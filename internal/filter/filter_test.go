@@ -0,0 +1,96 @@
+package filter
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	// --- Arrange ---
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"invoice_status": "ZAMKNIĘTY",
+		"invoice_total": 1250.5,
+		"customer": {"sales_area": "OPOLE", "vip": true},
+		"invoice_lines": [{"sku": "A-1", "qty": 2}, {"sku": "B-2", "qty": 0}],
+		"note": null
+	}`), &payload))
+	vars := map[string]any{
+		"payload":          payload,
+		"change_operation": "updated",
+		"envelope":         map[string]any{"event_type": "erp.invoice.updated", "change_version": json.Number("42")},
+	}
+
+	tests := []struct {
+		expression string
+		want       bool
+	}{
+		{expression: `payload.invoice_status == 'ZAMKNIĘTY'`, want: true},
+		{expression: `payload.invoice_status != "ZAMKNIĘTY"`, want: false},
+		{expression: `payload.invoice_status in ['W BUFORZE', 'ZAMKNIĘTY']`, want: true},
+		{expression: `payload.invoice_status not in ['W BUFORZE', 'ZAMKNIĘTY']`, want: false},
+		{expression: `payload.customer.sales_area in ['OPOLE'] and change_operation != 'deleted'`, want: true},
+		{expression: `payload.invoice_total > 1000 and payload.invoice_total <= 1250.5`, want: true},
+		{expression: `payload.invoice_total < 1000 or payload.customer.vip`, want: true},
+		{expression: `payload.invoice_lines.1.sku == 'B-2' and payload.invoice_lines.1.qty == 0`, want: true},
+		{expression: `payload.invoice_lines.5.sku == null`, want: true},
+		{expression: `payload.missing == null and payload.note == null`, want: true},
+		{expression: `payload.missing > 0`, want: false},
+		{expression: `payload.invoice_status > 10`, want: false},
+		{expression: `payload.invoice_status >= 'A'`, want: true},
+		{expression: `not (payload.customer.vip and envelope.change_version >= 40)`, want: false},
+		{expression: `envelope.event_type == 'erp.invoice.updated'`, want: true},
+		{expression: `payload.customer == payload.customer`, want: false},
+		{expression: `payload.invoice_status`, want: false},
+		{expression: `payload.note == 'it\'s'`, want: false},
+		{expression: `-1 < 0`, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			filter, err := Compile(tt.expression)
+			require.NoError(t, err)
+
+			// --- Act ---
+			got := filter.Match(vars)
+
+			// --- Assert ---
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestCompile_Invalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		wantErr    string
+	}{
+		{name: "empty", expression: ` `, wantErr: "empty"},
+		{name: "single equals", expression: `a = 1`, wantErr: "use == or !="},
+		{name: "unterminated string", expression: `a == 'open`, wantErr: "unterminated string"},
+		{name: "missing operand", expression: `a ==`, wantErr: "end of the expression"},
+		{name: "in without list", expression: `a in 'x'`, wantErr: "expected '['"},
+		{name: "path in list", expression: `a in [b]`, wantErr: "expected a value"},
+		{name: "unbalanced parenthesis", expression: `(a == 1`, wantErr: "expected ')'"},
+		{name: "trailing tokens", expression: `a == 1 b`, wantErr: "unexpected 'b'"},
+		{name: "function call", expression: `len(a) > 1`, wantErr: "unexpected '('"},
+		{name: "unknown character", expression: `a == 1 && b`, wantErr: "unexpected '&'"},
+		{name: "empty path segment", expression: `payload..a == 1`, wantErr: "invalid path"},
+		{name: "too deep", expression: `not not not not not not not not not not not not not not not not not not not not not not not not not not not not not not not not not a`, wantErr: "nested deeper"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			filter, err := Compile(tt.expression)
+
+			// --- Assert ---
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.Nil(t, filter)
+		})
+	}
+}
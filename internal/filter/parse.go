@@ -0,0 +1,287 @@
+package filter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// maxDepth limits the nesting of an expression
+const maxDepth = 32
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPath
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenKeyword
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+// keywords are the words that cannot be used as the first segment of a path
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "in": true, "true": true, "false": true, "null": true,
+}
+
+// lex splits an expression into tokens
+func lex(expression string) ([]token, error) {
+	var tokens []token
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '\'' || r == '"':
+			start := i
+			var text strings.Builder
+			for i++; ; i++ {
+				if i >= len(runes) {
+					return nil, fmt.Errorf("unterminated string at %d", start+1)
+				}
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				} else if runes[i] == r {
+					break
+				}
+				text.WriteRune(runes[i])
+			}
+			i++
+			tokens = append(tokens, token{kind: tokenString, text: text.String(), pos: start})
+		case unicode.IsDigit(r) || r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1]):
+			start := i
+			for i++; i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.'); i++ {
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[start:i]), pos: start})
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for ; i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) ||
+				runes[i] == '_' || runes[i] == '.'); i++ {
+			}
+			text := string(runes[start:i])
+			kind := tokenPath
+			if keywords[text] {
+				kind = tokenKeyword
+			}
+			tokens = append(tokens, token{kind: kind, text: text, pos: start})
+		case strings.ContainsRune("=!<>", r):
+			start := i
+			i++
+			if i < len(runes) && runes[i] == '=' {
+				i++
+			}
+			op := string(runes[start:i])
+			if op == "=" || op == "!" {
+				return nil, fmt.Errorf("unknown operator '%s' at %d, use == or !=", op, start+1)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: start})
+		case strings.ContainsRune("()[],", r):
+			tokens = append(tokens, token{kind: tokenPunct, text: string(r), pos: i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected '%c' at %d", r, i+1)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(runes)}), nil
+}
+
+// parser builds the expression tree by recursive descent:
+//
+//	or         = and { "or" and }
+//	and        = not { "and" not }
+//	not        = "not" not | comparison
+//	comparison = operand [ op operand | [ "not" ] "in" list ]
+//	operand    = literal | path | "(" or ")"
+//	list       = "[" literal { "," literal } "]"
+type parser struct {
+	tokens []token
+	next   int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *parser) take() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *parser) accept(kind tokenKind, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(kind tokenKind, text string) error {
+	if !p.accept(kind, text) {
+		return unexpected(p.peek(), "'"+text+"'")
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", maxDepth)
+	}
+
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenKeyword, "or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: false, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tokenKeyword, "and") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = logical{and: true, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept(tokenKeyword, "not") {
+		p.depth++
+		defer func() { p.depth-- }()
+		if p.depth > maxDepth {
+			return nil, fmt.Errorf("expression is nested deeper than %d levels", maxDepth)
+		}
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return negation{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+
+	switch t := p.peek(); {
+	case t.kind == tokenOperator:
+		p.take()
+		right, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		return comparison{op: t.text, left: left, right: right}, nil
+	case t.kind == tokenKeyword && t.text == "in":
+		p.take()
+		values, err := p.parseList()
+		return membership{operand: left, values: values}, err
+	case t.kind == tokenKeyword && t.text == "not" && p.tokens[p.next+1].text == "in":
+		p.next += 2
+		values, err := p.parseList()
+		return negation{operand: membership{operand: left, values: values}}, err
+	}
+	return left, nil
+}
+
+func (p *parser) parseOperand() (node, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenPunct && t.text == "(":
+		p.take()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return inner, p.expect(tokenPunct, ")")
+	case t.kind == tokenPath:
+		p.take()
+		segments := strings.Split(t.text, ".")
+		for _, segment := range segments {
+			if segment == "" {
+				return nil, fmt.Errorf("invalid path '%s' at %d", t.text, t.pos+1)
+			}
+		}
+		return path{segments: segments}, nil
+	}
+	value, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	return literal{value: value}, nil
+}
+
+func (p *parser) parseLiteral() (any, error) {
+	t := p.take()
+	switch {
+	case t.kind == tokenString:
+		return t.text, nil
+	case t.kind == tokenNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s' at %d", t.text, t.pos+1)
+		}
+		return f, nil
+	case t.kind == tokenKeyword && (t.text == "true" || t.text == "false"):
+		return t.text == "true", nil
+	case t.kind == tokenKeyword && t.text == "null":
+		return nil, nil
+	}
+	return nil, unexpected(t, "a value")
+}
+
+func (p *parser) parseList() ([]any, error) {
+	if err := p.expect(tokenPunct, "["); err != nil {
+		return nil, err
+	}
+	var values []any
+	for {
+		value, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+		if !p.accept(tokenPunct, ",") {
+			break
+		}
+	}
+	return values, p.expect(tokenPunct, "]")
+}
+
+// unexpected describes a token found where something else was expected
+func unexpected(t token, expected string) error {
+	if t.kind == tokenEOF {
+		return fmt.Errorf("expected %s at the end of the expression", expected)
+	}
+	return fmt.Errorf("expected %s at %d, found '%s'", expected, t.pos+1, t.text)
+}
@@ -0,0 +1,321 @@
+package maintenance
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Config holds the settings of the events table maintenance job
+type Config struct {
+	// Partitioned enables creating upcoming and dropping expired monthly partitions
+	Partitioned bool
+	// PartitionsAhead is the number of monthly partitions created beyond the current month
+	PartitionsAhead int
+	// ArchivePath is the directory where expired events are archived
+	ArchivePath string
+}
+
+// Maintainer creates partitions for the events table and removes expired events
+type Maintainer struct {
+	db       *sql.DB
+	policies []Policy
+	cfg      Config
+	logger   *slog.Logger
+	now      func() time.Time
+}
+
+// NewMaintainer creates a new events table maintainer
+func NewMaintainer(db *sql.DB, policies []Policy, cfg Config, logger *slog.Logger) (*Maintainer, error) {
+	for _, p := range policies {
+		if p.Archive && cfg.ArchivePath == "" {
+			return nil, fmt.Errorf("aggregate '%s' archives expired events but no archive path is set", p.Aggregate)
+		}
+	}
+	if cfg.PartitionsAhead < 0 {
+		return nil, fmt.Errorf("partitions ahead must not be negative, got %d", cfg.PartitionsAhead)
+	}
+
+	return &Maintainer{
+		db:       db,
+		policies: policies,
+		cfg:      cfg,
+		logger:   logger.With("component", "maintenance"),
+		now:      time.Now,
+	}, nil
+}
+
+// Start runs the maintenance immediately and then on every interval until the context is done
+func (m *Maintainer) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		m.logger.Info("starting events maintenance", "interval", interval)
+		if err := m.Run(ctx); err != nil {
+			m.logger.Error("events maintenance failed", "error", err)
+		}
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				m.logger.Info("stopping events maintenance", "reason", ctx.Err())
+				return
+			case <-ticker.C:
+				if err := m.Run(ctx); err != nil {
+					m.logger.Error("events maintenance failed", "error", err)
+				}
+			}
+		}
+	}()
+}
+
+// Run performs a single maintenance pass
+func (m *Maintainer) Run(ctx context.Context) error {
+	if m.cfg.Partitioned {
+		if err := m.ensurePartitions(ctx); err != nil {
+			return fmt.Errorf("failed to create partitions: %w", err)
+		}
+	}
+
+	for _, policy := range m.policies {
+		if policy.Forever() {
+			continue
+		}
+		if err := m.expireEvents(ctx, policy); err != nil {
+			return fmt.Errorf("failed to expire events for aggregate '%s': %w", policy.Aggregate, err)
+		}
+	}
+
+	if m.cfg.Partitioned {
+		if err := m.dropExpiredPartitions(ctx); err != nil {
+			return fmt.Errorf("failed to drop expired partitions: %w", err)
+		}
+	}
+	return nil
+}
+
+// ensurePartitions creates the partition for the current month and the configured months ahead
+func (m *Maintainer) ensurePartitions(ctx context.Context) error {
+	current := monthStart(m.now())
+	for i := 0; i <= m.cfg.PartitionsAhead; i++ {
+		from := current.AddDate(0, i, 0)
+		to := from.AddDate(0, 1, 0)
+		name := partitionName(from)
+
+		query := fmt.Sprintf(
+			"CREATE TABLE IF NOT EXISTS %s PARTITION OF events FOR VALUES FROM ('%s') TO ('%s')",
+			name, from.Format(time.RFC3339), to.Format(time.RFC3339),
+		)
+		if _, err := m.db.ExecContext(ctx, query); err != nil {
+			return fmt.Errorf("failed to create partition '%s': %w", name, err)
+		}
+		m.logger.Debug("partition ensured", "partition", name)
+	}
+	return nil
+}
+
+// expireEvents removes the events of the aggregate older than its retention,
+// archiving them first when the policy asks for it
+func (m *Maintainer) expireEvents(ctx context.Context, policy Policy) error {
+	cutoff := m.now().Add(-policy.Retention)
+	pattern := eventTypePattern(policy.Aggregate)
+
+	if !policy.Archive {
+		res, err := m.db.ExecContext(
+			ctx, "DELETE FROM events WHERE event_type LIKE $1 AND timestamp < $2", pattern, cutoff,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to delete expired events: %w", err)
+		}
+		deleted, _ := res.RowsAffected()
+		m.logger.Info("expired events deleted", "aggregate", policy.Aggregate, "cutoff", cutoff, "deleted", deleted)
+		return nil
+	}
+
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(
+		ctx,
+		"SELECT row_to_json(e)::text FROM events e WHERE e.event_type LIKE $1 AND e.timestamp < $2 ORDER BY e.timestamp",
+		pattern, cutoff,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to select expired events: %w", err)
+	}
+
+	path := m.archiveFilePath(policy.Aggregate, cutoff)
+	archived, err := writeArchive(path, rows)
+	rows.Close()
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to archive expired events: %w", err)
+	}
+	if archived == 0 {
+		os.Remove(path)
+		return nil
+	}
+
+	res, err := tx.ExecContext(
+		ctx, "DELETE FROM events WHERE event_type LIKE $1 AND timestamp < $2", pattern, cutoff,
+	)
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to delete archived events: %w", err)
+	}
+	deleted, _ := res.RowsAffected()
+
+	if err := tx.Commit(); err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	m.logger.Info(
+		"expired events archived",
+		"aggregate", policy.Aggregate,
+		"cutoff", cutoff,
+		"archived", archived,
+		"deleted", deleted,
+		"file", path,
+	)
+	return nil
+}
+
+// dropExpiredPartitions drops partitions whose whole range is older than the longest retention.
+// Nothing is dropped while any aggregate keeps its events forever.
+func (m *Maintainer) dropExpiredPartitions(ctx context.Context) error {
+	if len(m.policies) == 0 {
+		return nil
+	}
+	var longest time.Duration
+	for _, policy := range m.policies {
+		if policy.Forever() {
+			return nil
+		}
+		longest = max(longest, policy.Retention)
+	}
+	cutoff := m.now().Add(-longest)
+
+	rows, err := m.db.QueryContext(ctx, `
+		SELECT c.relname
+		FROM pg_inherits i
+			JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'events'::regclass
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions: %w", err)
+	}
+	var partitions []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("partition scan failed: %w", err)
+		}
+		partitions = append(partitions, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("partition iteration error: %w", err)
+	}
+
+	for _, name := range partitions {
+		from, ok := parsePartitionName(name)
+		if !ok || from.AddDate(0, 1, 0).After(cutoff) {
+			continue
+		}
+
+		// events of aggregates without a policy are never dropped silently
+		var notEmpty bool
+		query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s)", name)
+		if err := m.db.QueryRowContext(ctx, query).Scan(&notEmpty); err != nil {
+			return fmt.Errorf("failed to check partition '%s': %w", name, err)
+		}
+		if notEmpty {
+			m.logger.Warn("expired partition still holds events, skipping", "partition", name)
+			continue
+		}
+
+		if _, err := m.db.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", name)); err != nil {
+			return fmt.Errorf("failed to drop partition '%s': %w", name, err)
+		}
+		m.logger.Info("expired partition dropped", "partition", name)
+	}
+	return nil
+}
+
+func (m *Maintainer) archiveFilePath(aggregate string, cutoff time.Time) string {
+	name := fmt.Sprintf("events-%s-%s.jsonl.gz", aggregate, cutoff.UTC().Format("20060102T150405Z"))
+	return filepath.Join(m.cfg.ArchivePath, aggregate, name)
+}
+
+// writeArchive writes every row as a JSON line into a gzip compressed file
+func writeArchive(path string, rows *sql.Rows) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create archive file: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	w := bufio.NewWriter(gz)
+
+	var count int
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return count, fmt.Errorf("row scan failed: %w", err)
+		}
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return count, fmt.Errorf("failed to write archive: %w", err)
+		}
+		count++
+	}
+	if err := rows.Err(); err != nil {
+		return count, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return count, fmt.Errorf("failed to flush archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return count, fmt.Errorf("failed to close archive: %w", err)
+	}
+	return count, file.Sync()
+}
+
+func monthStart(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// partitionName returns the name of the monthly partition starting at the given month
+func partitionName(month time.Time) string {
+	return fmt.Sprintf("events_%04d_%02d", month.Year(), int(month.Month()))
+}
+
+// parsePartitionName returns the first day of the month covered by a partition created by
+// partitionName, partitions with other names are reported as not managed
+func parsePartitionName(name string) (time.Time, bool) {
+	var year, month int
+	if n, err := fmt.Sscanf(name, "events_%04d_%02d", &year, &month); err != nil || n != 2 {
+		return time.Time{}, false
+	}
+	if month < 1 || month > 12 || partitionName(time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)) != name {
+		return time.Time{}, false
+	}
+	return time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC), true
+}
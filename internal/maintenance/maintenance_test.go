@@ -0,0 +1,195 @@
+package maintenance
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetention(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{input: "", expected: 0},
+		{input: "forever", expected: 0},
+		{input: "FOREVER", expected: 0},
+		{input: "7d", expected: 7 * 24 * time.Hour},
+		{input: "36h", expected: 36 * time.Hour},
+		{input: "0d", wantErr: true},
+		{input: "-1h", wantErr: true},
+		{input: "week", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseRetention(tt.input)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, got)
+		})
+	}
+}
+
+func TestPartitionName(t *testing.T) {
+	month := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, "events_2026_03", partitionName(month))
+
+	parsed, ok := parsePartitionName("events_2026_03")
+	require.True(t, ok)
+	assert.Equal(t, month, parsed)
+
+	_, ok = parsePartitionName("events_default")
+	assert.False(t, ok, "default partition should not be managed")
+	_, ok = parsePartitionName("events_2026_13")
+	assert.False(t, ok, "invalid month should not be managed")
+}
+
+func TestEventTypePattern(t *testing.T) {
+	assert.Equal(t, "erp.stock.%", eventTypePattern("stock"))
+	assert.Equal(t, `erp.price\_term.%`, eventTypePattern("price_term"))
+}
+
+func TestMaintainer_Run_PartitionsAndDelete(t *testing.T) {
+	// --- Arrange ---
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	policies := []Policy{
+		{Aggregate: "stock", Retention: 7 * 24 * time.Hour},
+		{Aggregate: "customer", Retention: 60 * 24 * time.Hour},
+	}
+	m, err := NewMaintainer(db, policies, Config{Partitioned: true, PartitionsAhead: 1}, logger)
+	require.NoError(t, err)
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS events_2026_10 PARTITION OF events")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS events_2026_11 PARTITION OF events")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM events")).
+		WithArgs("erp.stock.%", now.Add(-7*24*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM events")).
+		WithArgs("erp.customer.%", now.Add(-60*24*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT c.relname")).WillReturnRows(
+		sqlmock.NewRows([]string{"relname"}).
+			AddRow("events_default").
+			AddRow("events_2026_06").
+			AddRow("events_2026_07").
+			AddRow("events_2026_08").
+			AddRow("events_2026_09"),
+	)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM events_2026_06)")).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("DROP TABLE events_2026_06")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS (SELECT 1 FROM events_2026_07)")).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+
+	// --- Act ---
+	err = m.Run(context.Background())
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMaintainer_Run_KeepsPartitionsWhenAnyAggregateIsForever(t *testing.T) {
+	// --- Arrange ---
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	policies := []Policy{
+		{Aggregate: "stock", Retention: 7 * 24 * time.Hour},
+		{Aggregate: "invoice"},
+	}
+	m, err := NewMaintainer(db, policies, Config{Partitioned: true}, logger)
+	require.NoError(t, err)
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS")).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM events")).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// --- Act ---
+	err = m.Run(context.Background())
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMaintainer_Run_ArchivesExpiredEvents(t *testing.T) {
+	// --- Arrange ---
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	archivePath := t.TempDir()
+
+	policies := []Policy{{Aggregate: "stock", Retention: 24 * time.Hour, Archive: true}}
+	m, err := NewMaintainer(db, policies, Config{ArchivePath: archivePath}, logger)
+	require.NoError(t, err)
+	now := time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)
+	m.now = func() time.Time { return now }
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT row_to_json(e)::text FROM events e")).
+		WithArgs("erp.stock.%", now.Add(-24*time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"row"}).
+			AddRow(`{"event_id":"1"}`).
+			AddRow(`{"event_id":"2"}`),
+		)
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM events")).
+		WithArgs("erp.stock.%", now.Add(-24*time.Hour)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	// --- Act ---
+	err = m.Run(context.Background())
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	file, err := os.Open(filepath.Join(archivePath, "stock", "events-stock-20261017T120000Z.jsonl.gz"))
+	require.NoError(t, err, "archive file should exist")
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.NoError(t, err)
+
+	var lines []string
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.Equal(t, []string{`{"event_id":"1"}`, `{"event_id":"2"}`}, lines)
+}
+
+func TestNewMaintainer_ArchiveRequiresPath(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	policies := []Policy{{Aggregate: "stock", Retention: time.Hour, Archive: true}}
+
+	_, err := NewMaintainer(nil, policies, Config{}, logger)
+	assert.Error(t, err)
+}
@@ -0,0 +1,66 @@
+package maintenance
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy describes how long events of a single aggregate are kept in the events table
+type Policy struct {
+	Aggregate string
+	// Retention is the maximum age of an event, zero means the events are kept forever
+	Retention time.Duration
+	// Archive writes expired events to a compressed JSONL file before they are deleted
+	Archive bool
+}
+
+// NewPolicy creates a retention policy for the aggregate from its config values
+func NewPolicy(aggregate, retention string, archive bool) (Policy, error) {
+	duration, err := ParseRetention(retention)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid retention for aggregate '%s': %w", aggregate, err)
+	}
+	return Policy{Aggregate: aggregate, Retention: duration, Archive: archive}, nil
+}
+
+// Forever reports whether the events of the aggregate are never removed
+func (p Policy) Forever() bool {
+	return p.Retention == 0
+}
+
+// ParseRetention parses a retention value such as "7d", "12h" or "forever".
+// An empty value is treated as "forever" and returns zero.
+func ParseRetention(s string) (time.Duration, error) {
+	s = strings.TrimSpace(strings.ToLower(s))
+	if s == "" || s == "forever" {
+		return 0, nil
+	}
+
+	var duration time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse days in '%s': %w", s, err)
+		}
+		duration = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse duration '%s': %w", s, err)
+		}
+		duration = d
+	}
+
+	if duration <= 0 {
+		return 0, fmt.Errorf("retention must be positive, got '%s'", s)
+	}
+	return duration, nil
+}
+
+// eventTypePattern returns the LIKE pattern matching event types of the aggregate
+func eventTypePattern(aggregate string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(aggregate)
+	return "erp." + escaped + ".%"
+}
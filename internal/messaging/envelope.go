@@ -1,24 +1,33 @@
 package messaging
 
 import (
+	"context"
 	"errors"
 	"time"
 
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // EventEnvelope wraps domain events with metadata
 type EventEnvelope struct {
-	EventID       string      `json:"event_id"`
-	EventType     string      `json:"event_type"`
-	EventVersion  int         `json:"event_version"`
-	AggregateKey  string      `json:"aggregate_key"`
-	ChangeVersion int64       `json:"change_version"`
-	Timestamp     time.Time   `json:"timestamp"`
-	CorrelationID string      `json:"correlation_id,omitempty"`
-	CausationID   string      `json:"causation_id,omitempty"`
-	UserID        string      `json:"user_id,omitempty"`
-	Payload       interface{} `json:"payload"`
+	EventID       string    `json:"event_id"`
+	EventType     string    `json:"event_type"`
+	EventVersion  int       `json:"event_version"`
+	AggregateKey  string    `json:"aggregate_key"`
+	ChangeVersion int64     `json:"change_version"`
+	Timestamp     time.Time `json:"timestamp"`
+	// SourceTimestamp is when the source system committed the change, Timestamp is when the
+	// envelope was created
+	SourceTimestamp *time.Time `json:"source_timestamp,omitempty"`
+	CorrelationID   string     `json:"correlation_id,omitempty"`
+	CausationID     string     `json:"causation_id,omitempty"`
+	UserID          string     `json:"user_id,omitempty"`
+	TraceParent     string     `json:"traceparent,omitempty"`
+	TraceState      string     `json:"tracestate,omitempty"`
+	// Metadata holds additional source values that are not part of the payload
+	Metadata map[string]any `json:"metadata,omitempty"`
+	Payload  interface{}    `json:"payload"`
 }
 
 // EnvelopeOption is a functional option for configuring EventEnvelope
@@ -45,6 +54,43 @@ func WithUserID(userID string) EnvelopeOption {
 	}
 }
 
+// WithSourceTimestamp sets the time the source system committed the change
+func WithSourceTimestamp(t time.Time) EnvelopeOption {
+	return func(e *EventEnvelope) {
+		e.SourceTimestamp = &t
+	}
+}
+
+// WithMetadata sets the additional source values of the event
+func WithMetadata(metadata map[string]any) EnvelopeOption {
+	return func(e *EventEnvelope) {
+		e.Metadata = metadata
+	}
+}
+
+// WithTraceContext stores the W3C trace context of the span in ctx, so consumers can continue
+// the trace of the cycle that produced the event
+func WithTraceContext(ctx context.Context) EnvelopeOption {
+	return func(e *EventEnvelope) {
+		carrier := propagation.MapCarrier{}
+		propagation.TraceContext{}.Inject(ctx, carrier)
+		e.TraceParent = carrier.Get("traceparent")
+		e.TraceState = carrier.Get("tracestate")
+	}
+}
+
+// TraceContext returns ctx carrying the trace context stored in the envelope
+func (e *EventEnvelope) TraceContext(ctx context.Context) context.Context {
+	if e.TraceParent == "" {
+		return ctx
+	}
+	carrier := propagation.MapCarrier{"traceparent": e.TraceParent}
+	if e.TraceState != "" {
+		carrier["tracestate"] = e.TraceState
+	}
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
+
 func NewEventEnvelope(
 	eventType, aggregateKey string, changeVersion int64,
 	payload interface{}, options ...EnvelopeOption,
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/salesworks/s-works/slx/internal/metrics"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 // NatsPublisher is an implementation of the Publisher interface that sends events to a single
@@ -25,7 +28,11 @@ func NewNatsPublisher(conn *nats.Conn, logger *slog.Logger) *NatsPublisher {
 }
 
 // Publish publishes an event envelope to the topic
-func (p *NatsPublisher) Publish(ctx context.Context, subject string, envelope *EventEnvelope) error {
+func (p *NatsPublisher) Publish(ctx context.Context, subject string, envelope *EventEnvelope) (err error) {
+	defer func(start time.Time) {
+		metrics.ObservePublish("nats", subject, start, err)
+	}(time.Now())
+
 	// Validate the envelope
 	if err := envelope.Validate(); err != nil {
 		return fmt.Errorf("invalid event envelope: %w", err)
@@ -37,7 +44,11 @@ func (p *NatsPublisher) Publish(ctx context.Context, subject string, envelope *E
 		return fmt.Errorf("failed to marshal event envelope: %w", err)
 	}
 
-	if err := p.conn.Publish(subject, event); err != nil {
+	// the trace context of the publish travels in the message headers
+	msg := nats.NewMsg(subject)
+	msg.Data = event
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(msg.Header))
+	if err := p.conn.PublishMsg(msg); err != nil {
 		return fmt.Errorf("failed to publish message to subject '%s': %w", subject, err)
 	}
 
@@ -51,6 +62,14 @@ func (p *NatsPublisher) Publish(ctx context.Context, subject string, envelope *E
 	return nil
 }
 
+// Check reports whether the NATS connection is established
+func (p *NatsPublisher) Check(ctx context.Context) error {
+	if status := p.conn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection is %s", status)
+	}
+	return nil
+}
+
 func (p *NatsPublisher) Close() error {
 	if p.conn != nil && !p.conn.IsClosed() {
 		p.logger.Info("draining and closing NATS connection.")
@@ -6,8 +6,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/salesworks/s-works/slx/internal/metrics"
 )
 
 // PostgresPublisher is an implementation of the Publisher interface that stores events
@@ -26,7 +28,52 @@ func NewPostgresPublisher(db *sql.DB, logger *slog.Logger) *PostgresPublisher {
 }
 
 // Publish stores an event envelope in the PostgreSQL events table
-func (p *PostgresPublisher) Publish(ctx context.Context, subject string, envelope *EventEnvelope) error {
+func (p *PostgresPublisher) Publish(ctx context.Context, subject string, envelope *EventEnvelope) (err error) {
+    defer func(start time.Time) {
+        metrics.ObservePublish("postgres", subject, start, err)
+    }(time.Now())
+
+    if err := insertEvent(ctx, p.db, envelope); err != nil {
+        return err
+    }
+
+    p.logger.Debug("event stored in PostgreSQL",
+        "subject", subject,
+        "event_type", envelope.EventType,
+        "aggregate_key", envelope.AggregateKey,
+    )
+    return nil
+}
+
+// InsertEvent stores an event envelope in the PostgreSQL events table as part of the
+// transaction, so the caller can commit it together with other changes
+func InsertEvent(ctx context.Context, tx *sql.Tx, envelope *EventEnvelope) error {
+    return insertEvent(ctx, tx, envelope)
+}
+
+// MigrateEvents adds the columns of the events table added after the first release to an
+// existing table, see migrations/002_event_metadata.sql. Partitions get them from the parent.
+func MigrateEvents(ctx context.Context, db *sql.DB) error {
+    _, err := db.ExecContext(ctx, `
+        ALTER TABLE events
+            ADD COLUMN IF NOT EXISTS source_timestamp TIMESTAMPTZ,
+            ADD COLUMN IF NOT EXISTS metadata         JSONB
+    `)
+    if err != nil {
+        return fmt.Errorf(
+            "failed to add the source_timestamp and metadata columns to the events table, "+
+                "apply migrations/002_event_metadata.sql: %w", err,
+        )
+    }
+    return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+    ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func insertEvent(ctx context.Context, db execer, envelope *EventEnvelope) error {
     if err := envelope.Validate(); err != nil {
         return fmt.Errorf("invalid event envelope: %w", err)
     }
@@ -41,15 +88,22 @@ func (p *PostgresPublisher) Publish(ctx context.Context, subject string, envelop
         return fmt.Errorf("invalid event ID format: %w", err)
     }
 
+    var metadataJSON []byte
+    if len(envelope.Metadata) > 0 {
+        if metadataJSON, err = json.Marshal(envelope.Metadata); err != nil {
+            return fmt.Errorf("failed to encode metadata: %w", err)
+        }
+    }
+
     query := `
         INSERT INTO events (
             event_id, event_type, event_version, aggregate_key,
             change_version, timestamp, correlation_id, causation_id,
-            user_id, payload
-        ) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10::jsonb)
+            user_id, payload, source_timestamp, metadata
+        ) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10::jsonb,$11,$12::jsonb)
     `
 
-    _, err = p.db.ExecContext(
+    _, err = db.ExecContext(
         ctx,
         query,
         eventUUID,
@@ -62,16 +116,12 @@ func (p *PostgresPublisher) Publish(ctx context.Context, subject string, envelop
         nullStringFromPtr(envelope.CausationID),
         nullStringFromPtr(envelope.UserID),
         payloadJSON,
+        envelope.SourceTimestamp,
+        metadataJSON,
     )
     if err != nil {
         return fmt.Errorf("failed to insert event: %w", err)
     }
-
-    p.logger.Debug("event stored in PostgreSQL",
-        "subject", subject,
-        "event_type", envelope.EventType,
-        "aggregate_key", envelope.AggregateKey,
-    )
     return nil
 }
 
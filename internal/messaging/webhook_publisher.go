@@ -0,0 +1,88 @@
+package messaging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/salesworks/s-works/slx/internal/metrics"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// WebhookPublisher is an implementation of the Publisher interface that posts events as JSON
+// to an HTTP endpoint.
+type WebhookPublisher struct {
+	url    string
+	token  string
+	client *http.Client
+	logger *slog.Logger
+}
+
+// NewWebhookPublisher creates a new webhook event publisher, the token is sent as a bearer
+// token when it is not empty
+func NewWebhookPublisher(url, token string, timeout time.Duration, logger *slog.Logger) *WebhookPublisher {
+	return &WebhookPublisher{
+		url:    url,
+		token:  token,
+		client: &http.Client{Timeout: timeout},
+		logger: logger.With("component", "WebhookPublisher"),
+	}
+}
+
+// Publish posts an event envelope to the webhook endpoint
+func (p *WebhookPublisher) Publish(ctx context.Context, subject string, envelope *EventEnvelope) (err error) {
+	defer func(start time.Time) {
+		metrics.ObservePublish("webhook", subject, start, err)
+	}(time.Now())
+
+	if err := envelope.Validate(); err != nil {
+		return fmt.Errorf("invalid event envelope: %w", err)
+	}
+
+	event, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event envelope: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(event))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Subject", subject)
+	req.Header.Set("X-Event-Type", envelope.EventType)
+	req.Header.Set("X-Event-ID", envelope.EventID)
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+	propagation.TraceContext{}.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	p.logger.Debug(
+		"event posted to webhook",
+		"subject", subject,
+		"event_type", envelope.EventType,
+		"aggregate_key", envelope.AggregateKey,
+	)
+	return nil
+}
+
+func (p *WebhookPublisher) Close() error {
+	p.client.CloseIdleConnections()
+	return nil
+}
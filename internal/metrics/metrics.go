@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Registry holds the SLX metrics together with the Go runtime and process collectors
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+var (
+	// CycleDuration observes the duration of ERP cycles per aggregate and result
+	CycleDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slx_tracker_cycle_duration_seconds",
+		Help:    "Duration of ERP change tracking cycles.",
+		Buckets: []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"aggregate", "result"})
+
+	// RowsFetched counts the change rows fetched per aggregate
+	RowsFetched = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "slx_tracker_rows_fetched_total",
+		Help: "Change rows fetched from the ERP.",
+	}, []string{"aggregate"})
+
+	// RowsSkipped counts the fetched change rows that were not dispatched per aggregate
+	RowsSkipped = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "slx_tracker_rows_skipped_total",
+		Help: "Fetched change rows that were filtered, coalesced or rejected instead of dispatched.",
+	}, []string{"aggregate"})
+
+	// CheckpointVersion is the change version each aggregate resumes from
+	CheckpointVersion = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slx_tracker_checkpoint_version",
+		Help: "Change version stored in the checkpoint of the aggregate.",
+	}, []string{"aggregate"})
+
+	// CurrentVersion is CHANGE_TRACKING_CURRENT_VERSION() of the ERP database
+	CurrentVersion = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "slx_tracker_current_version",
+		Help: "Current change tracking version of the ERP database.",
+	})
+
+	// LagVersions is how many versions the checkpoint of each aggregate is behind
+	LagVersions = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slx_tracker_lag_versions",
+		Help: "Change versions between the checkpoint and the current database version.",
+	}, []string{"aggregate"})
+
+	// RetentionHeadroom is how many versions the checkpoint is ahead of the retention cleanup
+	RetentionHeadroom = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "slx_tracker_retention_headroom_versions",
+		Help: "Change versions between the minimum valid version and the checkpoint, negative once changes were lost.",
+	}, []string{"aggregate"})
+
+	// QueueDepth is the number of jobs waiting in the dispatcher queue
+	QueueDepth = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "slx_dispatcher_queue_depth",
+		Help: "Jobs waiting in the dispatcher queue.",
+	})
+
+	// QueueCapacity is the size of the dispatcher queue
+	QueueCapacity = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "slx_dispatcher_queue_capacity",
+		Help: "Size of the dispatcher queue.",
+	})
+
+	// Workers is the number of dispatcher workers
+	Workers = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "slx_dispatcher_workers",
+		Help: "Dispatcher workers started.",
+	})
+
+	// BusyWorkers is the number of dispatcher workers publishing a job
+	BusyWorkers = factory.NewGauge(prometheus.GaugeOpts{
+		Name: "slx_dispatcher_workers_busy",
+		Help: "Dispatcher workers currently publishing a job.",
+	})
+
+	// PublishDuration observes the publish latency per publisher and subject
+	PublishDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slx_publish_duration_seconds",
+		Help:    "Latency of publishing an event.",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 10},
+	}, []string{"publisher", "subject"})
+
+	// PublishErrors counts failed publishes per publisher and subject
+	PublishErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Name: "slx_publish_errors_total",
+		Help: "Events that failed to publish.",
+	}, []string{"publisher", "subject"})
+)
+
+// ObservePublish records the latency and the outcome of a publish started at start
+func ObservePublish(publisher string, subject string, start time.Time, err error) {
+	PublishDuration.WithLabelValues(publisher, subject).Observe(time.Since(start).Seconds())
+	if err != nil {
+		PublishErrors.WithLabelValues(publisher, subject).Inc()
+	}
+}
+
+// ObserveCycle records the duration, rows and resulting change version of an ERP cycle
+func ObserveCycle(aggregate string, duration time.Duration, rows int, version int64, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	CycleDuration.WithLabelValues(aggregate, result).Observe(duration.Seconds())
+	RowsFetched.WithLabelValues(aggregate).Add(float64(rows))
+	if err == nil {
+		CheckpointVersion.WithLabelValues(aggregate).Set(float64(version))
+	}
+}
+
+// RegisterDB exposes the connection pool statistics of the database under the given name
+func RegisterDB(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// Handler serves the registry in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObservePublish(t *testing.T) {
+	// --- Act ---
+	ObservePublish("webhook", "erp.test_publish", time.Now(), nil)
+	ObservePublish("webhook", "erp.test_publish", time.Now(), errors.New("timeout"))
+
+	// --- Assert ---
+	assert.Equal(t, 1, testutil.CollectAndCount(PublishDuration), "both publishes should land in one series")
+	assert.Equal(t, 1.0, testutil.ToFloat64(PublishErrors.WithLabelValues("webhook", "erp.test_publish")))
+}
+
+func TestObserveCycle(t *testing.T) {
+	// --- Act ---
+	ObserveCycle("test_cycle", time.Second, 5, 42, nil)
+	ObserveCycle("test_cycle", time.Second, 0, 0, errors.New("deadlock"))
+
+	// --- Assert ---
+	assert.Equal(t, 5.0, testutil.ToFloat64(RowsFetched.WithLabelValues("test_cycle")))
+	assert.Equal(t, 42.0, testutil.ToFloat64(CheckpointVersion.WithLabelValues("test_cycle")),
+		"a failed cycle must not move the checkpoint gauge")
+}
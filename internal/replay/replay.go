@@ -0,0 +1,242 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/salesworks/s-works/slx/internal/dispatcher"
+	"github.com/salesworks/s-works/slx/internal/messaging"
+)
+
+// Filter selects the events to replay, zero values are not applied
+type Filter struct {
+	Aggregate   string
+	Keys        []string
+	FromVersion int64
+	ToVersion   int64
+	Since       time.Time
+	Until       time.Time
+}
+
+// Options controls the pace of a replay
+type Options struct {
+	// Rate is the maximum number of events published per second, zero means unlimited
+	Rate float64
+	// DryRun only logs the events that would be published
+	DryRun bool
+	// BatchSize is the number of events read from Postgres at once
+	BatchSize int
+	// Subject returns the subject an envelope is published on, by default the event type
+	// without its last token
+	Subject func(envelope *messaging.EventEnvelope) (string, error)
+}
+
+// Replayer reads stored events from Postgres and publishes them again
+type Replayer struct {
+	db        *sql.DB
+	publisher dispatcher.Publisher
+	logger    *slog.Logger
+}
+
+// NewReplayer creates a new event replayer
+func NewReplayer(db *sql.DB, publisher dispatcher.Publisher, logger *slog.Logger) *Replayer {
+	return &Replayer{
+		db:        db,
+		publisher: publisher,
+		logger:    logger.With("component", "replay"),
+	}
+}
+
+// cursor is the position of the last event read, events are read ordered by timestamp and id
+type cursor struct {
+	timestamp time.Time
+	eventID   uuid.UUID
+}
+
+// Run replays every event matching the filter and returns the number of events replayed
+func (r *Replayer) Run(ctx context.Context, filter Filter, opts Options) (int, error) {
+	if filter.Aggregate == "" {
+		return 0, fmt.Errorf("aggregate is required")
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+
+	var throttle <-chan time.Time
+	if opts.Rate > 0 {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / opts.Rate))
+		defer ticker.Stop()
+		throttle = ticker.C
+	}
+
+	r.logger.Info("replay started", "aggregate", filter.Aggregate, "keys", len(filter.Keys), "dry_run", opts.DryRun)
+
+	var total int
+	var after *cursor
+	for {
+		envelopes, last, err := r.readBatch(ctx, filter, after, opts.BatchSize)
+		if err != nil {
+			return total, err
+		}
+
+		for _, envelope := range envelopes {
+			if throttle != nil {
+				select {
+				case <-ctx.Done():
+					return total, ctx.Err()
+				case <-throttle:
+				}
+			}
+
+			subject := subjectFor(envelope.EventType)
+			if opts.Subject != nil {
+				if subject, err = opts.Subject(envelope); err != nil {
+					return total, fmt.Errorf("failed to render the subject of event '%s': %w", envelope.EventID, err)
+				}
+			}
+			if opts.DryRun {
+				r.logger.Info(
+					"dry run, event not published",
+					"subject", subject,
+					"event_id", envelope.EventID,
+					"event_type", envelope.EventType,
+					"aggregate_key", envelope.AggregateKey,
+					"change_version", envelope.ChangeVersion,
+				)
+			} else if err := r.publisher.Publish(ctx, subject, envelope); err != nil {
+				return total, fmt.Errorf("failed to publish event '%s': %w", envelope.EventID, err)
+			}
+			total++
+		}
+
+		if len(envelopes) < opts.BatchSize {
+			break
+		}
+		after = last
+	}
+
+	r.logger.Info("replay completed", "aggregate", filter.Aggregate, "events", total, "dry_run", opts.DryRun)
+	return total, nil
+}
+
+func (r *Replayer) readBatch(
+	ctx context.Context, filter Filter, after *cursor, limit int,
+) ([]*messaging.EventEnvelope, *cursor, error) {
+	query, args := buildQuery(filter, after, limit)
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query events: %w", err)
+	}
+	defer rows.Close()
+
+	var envelopes []*messaging.EventEnvelope
+	var last cursor
+	for rows.Next() {
+		var (
+			eventID                            uuid.UUID
+			envelope                           messaging.EventEnvelope
+			correlationID, causationID, userID sql.NullString
+			sourceTimestamp                    sql.NullTime
+			payload, metadata                  []byte
+		)
+		if err := rows.Scan(
+			&eventID,
+			&envelope.EventType,
+			&envelope.EventVersion,
+			&envelope.AggregateKey,
+			&envelope.ChangeVersion,
+			&envelope.Timestamp,
+			&correlationID,
+			&causationID,
+			&userID,
+			&payload,
+			&sourceTimestamp,
+			&metadata,
+		); err != nil {
+			return nil, nil, fmt.Errorf("row scan failed: %w", err)
+		}
+		envelope.EventID = eventID.String()
+		envelope.CorrelationID = correlationID.String
+		envelope.CausationID = causationID.String
+		envelope.UserID = userID.String
+		envelope.Payload = json.RawMessage(payload)
+		if sourceTimestamp.Valid {
+			envelope.SourceTimestamp = &sourceTimestamp.Time
+		}
+		if metadata != nil {
+			decoder := json.NewDecoder(bytes.NewReader(metadata))
+			decoder.UseNumber()
+			if err := decoder.Decode(&envelope.Metadata); err != nil {
+				return nil, nil, fmt.Errorf("invalid metadata of event '%s': %w", envelope.EventID, err)
+			}
+		}
+
+		envelopes = append(envelopes, &envelope)
+		last = cursor{timestamp: envelope.Timestamp, eventID: eventID}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return envelopes, &last, nil
+}
+
+// buildQuery returns the events query with its positional arguments
+func buildQuery(filter Filter, after *cursor, limit int) (string, []any) {
+	var conditions []string
+	var args []any
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	pattern := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(filter.Aggregate)
+	conditions = append(conditions, "event_type LIKE "+arg("erp."+pattern+".%"))
+
+	if len(filter.Keys) > 0 {
+		conditions = append(conditions, "aggregate_key = ANY("+arg(filter.Keys)+")")
+	}
+	if filter.FromVersion > 0 {
+		conditions = append(conditions, "change_version >= "+arg(filter.FromVersion))
+	}
+	if filter.ToVersion > 0 {
+		conditions = append(conditions, "change_version <= "+arg(filter.ToVersion))
+	}
+	if !filter.Since.IsZero() {
+		conditions = append(conditions, "timestamp >= "+arg(filter.Since))
+	}
+	if !filter.Until.IsZero() {
+		conditions = append(conditions, "timestamp < "+arg(filter.Until))
+	}
+	if after != nil {
+		conditions = append(conditions, fmt.Sprintf("(timestamp, event_id) > (%s, %s)", arg(after.timestamp), arg(after.eventID)))
+	}
+
+	query := `
+		SELECT
+			event_id, event_type, event_version, aggregate_key,
+			change_version, timestamp, correlation_id, causation_id,
+			user_id, payload, source_timestamp, metadata
+		FROM events
+		WHERE ` + strings.Join(conditions, " AND ") + `
+		ORDER BY timestamp, event_id
+		LIMIT ` + arg(limit)
+
+	return query, args
+}
+
+// subjectFor derives the publishing subject from the event type, e.g. erp.customer.updated
+// is published on erp.customer
+func subjectFor(eventType string) string {
+	if i := strings.LastIndex(eventType, "."); i > 0 {
+		return eventType[:i]
+	}
+	return eventType
+}
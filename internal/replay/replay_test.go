@@ -0,0 +1,198 @@
+package replay
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockPublisher struct {
+	subjects  []string
+	envelopes []*messaging.EventEnvelope
+}
+
+func (m *mockPublisher) Publish(_ context.Context, subject string, envelope *messaging.EventEnvelope) error {
+	m.subjects = append(m.subjects, subject)
+	m.envelopes = append(m.envelopes, envelope)
+	return nil
+}
+
+func (m *mockPublisher) Close() error { return nil }
+
+var eventColumns = []string{
+	"event_id", "event_type", "event_version", "aggregate_key",
+	"change_version", "timestamp", "correlation_id", "causation_id",
+	"user_id", "payload", "source_timestamp", "metadata",
+}
+
+func TestBuildQuery(t *testing.T) {
+	since := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	filter := Filter{
+		Aggregate:   "customer",
+		Keys:        []string{"A", "B"},
+		FromVersion: 10,
+		ToVersion:   20,
+		Since:       since,
+	}
+
+	query, args := buildQuery(filter, nil, 100)
+
+	assert.Contains(t, query, "event_type LIKE $1")
+	assert.Contains(t, query, "aggregate_key = ANY($2)")
+	assert.Contains(t, query, "change_version >= $3")
+	assert.Contains(t, query, "change_version <= $4")
+	assert.Contains(t, query, "timestamp >= $5")
+	assert.Contains(t, query, "LIMIT $6")
+	assert.NotContains(t, query, "timestamp <")
+	assert.Equal(t, []any{"erp.customer.%", []string{"A", "B"}, int64(10), int64(20), since, 100}, args)
+}
+
+func TestReplayer_Run(t *testing.T) {
+	// --- Arrange ---
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	publisher := &mockPublisher{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	stored := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM events")).WillReturnRows(
+		sqlmock.NewRows(eventColumns).
+			AddRow("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "erp.customer.updated", 1, "A", 5, stored, nil, nil, nil, []byte(`{"id":1}`), nil, nil).
+			AddRow("6ba7b811-9dad-11d1-80b4-00c04fd430c8", "erp.customer.deleted", 1, "B", 6, stored, "corr", nil, nil, []byte(`{"id":2}`), nil, nil),
+	)
+	mock.ExpectQuery(regexp.QuoteMeta("(timestamp, event_id) >")).WillReturnRows(sqlmock.NewRows(eventColumns))
+
+	replayer := NewReplayer(db, publisher, logger)
+
+	// --- Act ---
+	count, err := replayer.Run(context.Background(), Filter{Aggregate: "customer"}, Options{BatchSize: 2})
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.Equal(t, []string{"erp.customer", "erp.customer"}, publisher.subjects)
+	assert.Equal(t, "6ba7b810-9dad-11d1-80b4-00c04fd430c8", publisher.envelopes[0].EventID)
+	assert.Equal(t, "corr", publisher.envelopes[1].CorrelationID)
+	assert.JSONEq(t, `{"id":2}`, string(publisher.envelopes[1].Payload.(json.RawMessage)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReplayer_Run_Subject(t *testing.T) {
+	// --- Arrange ---
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	publisher := &mockPublisher{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM events")).WillReturnRows(
+		sqlmock.NewRows(eventColumns).
+			AddRow("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "erp.customer.updated", 1, "A", 5, time.Now(), nil, nil, nil, []byte(`{}`), nil, nil),
+	)
+	subject := func(envelope *messaging.EventEnvelope) (string, error) {
+		return "erp.production.customer." + envelope.AggregateKey, nil
+	}
+
+	replayer := NewReplayer(db, publisher, logger)
+
+	// --- Act ---
+	count, err := replayer.Run(context.Background(), Filter{Aggregate: "customer"}, Options{Subject: subject})
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, []string{"erp.production.customer.A"}, publisher.subjects)
+}
+
+func TestReplayer_Run_DryRun(t *testing.T) {
+	// --- Arrange ---
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	publisher := &mockPublisher{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM events")).WillReturnRows(
+		sqlmock.NewRows(eventColumns).
+			AddRow("6ba7b810-9dad-11d1-80b4-00c04fd430c8", "erp.stock.updated", 1, "A", 5, time.Now(), nil, nil, nil, []byte(`{}`), nil, nil),
+	)
+
+	replayer := NewReplayer(db, publisher, logger)
+
+	// --- Act ---
+	count, err := replayer.Run(context.Background(), Filter{Aggregate: "stock"}, Options{DryRun: true, Rate: 1000})
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Empty(t, publisher.envelopes, "dry run should not publish")
+}
+
+// capture is a sqlmock argument that records the value it is matched with
+type capture struct {
+	value driver.Value
+}
+
+func (c *capture) Match(v driver.Value) bool {
+	c.value = v
+	return true
+}
+
+func TestReplayer_Run_RestoresStoredEnvelope(t *testing.T) {
+	// --- Arrange ---
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	publisher := &mockPublisher{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	committed := time.Date(2026, time.October, 1, 12, 30, 0, 0, time.UTC)
+	original := messaging.NewEventEnvelope(
+		"erp.invoice.updated", "K", 7, json.RawMessage(`{"invoice_number":"FS-1/26"}`),
+		messaging.WithSourceTimestamp(committed),
+		messaging.WithMetadata(map[string]any{"natural_key": "2033:12345", "trn_stan": json.Number("5")}),
+	)
+
+	args := make([]*capture, 12)
+	matchers := make([]driver.Value, len(args))
+	for i := range args {
+		args[i] = &capture{}
+		matchers[i] = args[i]
+	}
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events")).WithArgs(matchers...).WillReturnResult(sqlmock.NewResult(0, 1))
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	require.NoError(t, messaging.InsertEvent(context.Background(), tx, original))
+
+	row := make([]driver.Value, len(args))
+	for i, arg := range args {
+		row[i] = arg.value
+	}
+	row[0] = original.EventID
+	mock.ExpectQuery(regexp.QuoteMeta("FROM events")).WillReturnRows(sqlmock.NewRows(eventColumns).AddRow(row...))
+
+	replayer := NewReplayer(db, publisher, logger)
+
+	// --- Act ---
+	count, err := replayer.Run(context.Background(), Filter{Aggregate: "invoice"}, Options{})
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Equal(t, 1, count)
+	replayed := publisher.envelopes[0]
+	require.NotNil(t, replayed.SourceTimestamp)
+	assert.True(t, committed.Equal(*replayed.SourceTimestamp))
+	assert.Equal(t, map[string]any{"natural_key": "2033:12345", "trn_stan": json.Number("5")}, replayed.Metadata)
+	assert.JSONEq(t, `{"invoice_number":"FS-1/26"}`, string(replayed.Payload.(json.RawMessage)))
+}
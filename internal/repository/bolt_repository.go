@@ -1,14 +1,27 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"strconv"
+	"time"
 
+	"github.com/salesworks/s-works/slx/internal/tracker"
 	"go.etcd.io/bbolt"
 )
 
+const (
+	// maxCycleHistory is the number of recent cycles kept per aggregate
+	maxCycleHistory = 100
+	// bboltLockTimeout is how long opening the database waits for the file lock
+	bboltLockTimeout = 3 * time.Second
+)
+
 // BBoltRepository implements TrackerRepository using BBolt
 type BBoltRepository struct {
 	db     *bbolt.DB
@@ -17,8 +30,12 @@ type BBoltRepository struct {
 
 // NewSimpleBBoltRepository creates a new BBolt repository
 func NewBBoltRepository(dbPath string, logger *slog.Logger) (*BBoltRepository, error) {
-	// Open database (creates if doesn't exist)
-	db, err := bbolt.Open(dbPath, 0600, nil)
+	// Open database (creates if doesn't exist), the file lock is held while the repository is
+	// open so a second process fails instead of waiting forever
+	db, err := bbolt.Open(dbPath, 0600, &bbolt.Options{Timeout: bboltLockTimeout})
+	if errors.Is(err, bbolt.ErrTimeout) {
+		return nil, fmt.Errorf("database '%s' is locked by another process, is the service running?: %w", dbPath, err)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -94,17 +111,386 @@ func (r *BBoltRepository) UpdateChangeVersion(ctx context.Context, aggregateName
 			return fmt.Errorf("aggregate '%s' not found", aggregateName)
 		}
 
+		previousVersion, err := strconv.ParseInt(string(existing), 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse version for aggregate '%s': %w", aggregateName, err)
+		}
+
 		// Convert version to string and store
 		versionStr := strconv.FormatInt(newVersion, 10)
-		err := b.Put([]byte(aggregateName), []byte(versionStr))
+		err = b.Put([]byte(aggregateName), []byte(versionStr))
 		if err != nil {
 			return fmt.Errorf("failed to update version for aggregate '%s': %w", aggregateName, err)
 		}
 
+		// Keep the previous version in the checkpoint record as a point to roll back to
+		record, err := getCheckpointRecord(tx, aggregateName)
+		if err != nil {
+			return err
+		}
+		if previousVersion != newVersion {
+			record.PreviousVersion = previousVersion
+			record.UpdatedAt = time.Now()
+		}
+		return putCheckpointRecord(tx, aggregateName, record)
+	})
+}
+
+// RecordCycle stores the outcome of a cycle in the checkpoint record, cycles that advanced the
+// version or failed are also appended to the bounded cycle history
+func (r *BBoltRepository) RecordCycle(ctx context.Context, aggregateName string, cycle tracker.CycleRecord) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		record, err := getCheckpointRecord(tx, aggregateName)
+		if err != nil {
+			return err
+		}
+		record.LastRunStart = cycle.Start
+		record.LastRunEnd = cycle.End
+		record.RowsFetched = cycle.RowsFetched
+		record.LastError = cycle.Error
+		if err := putCheckpointRecord(tx, aggregateName, record); err != nil {
+			return err
+		}
+
+		if !cycle.Noteworthy() {
+			return nil
+		}
+
+		history, err := tx.CreateBucketIfNotExists([]byte("history"))
+		if err != nil {
+			return err
+		}
+		b, err := history.CreateBucketIfNotExists([]byte(aggregateName))
+		if err != nil {
+			return err
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		value, err := json.Marshal(cycle)
+		if err != nil {
+			return fmt.Errorf("failed to marshal cycle for aggregate '%s': %w", aggregateName, err)
+		}
+		if err := b.Put(sequenceKey(seq), value); err != nil {
+			return fmt.Errorf("failed to store cycle for aggregate '%s': %w", aggregateName, err)
+		}
+
+		// Drop the oldest cycles beyond the history limit
+		if seq <= maxCycleHistory {
+			return nil
+		}
+		oldest := sequenceKey(seq - maxCycleHistory + 1)
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, oldest) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 }
 
+// GetCheckpoint returns the checkpoint of the given aggregate name
+func (r *BBoltRepository) GetCheckpoint(ctx context.Context, aggregateName string) (tracker.Checkpoint, error) {
+	var checkpoint tracker.Checkpoint
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("aggregates"))
+		if b == nil {
+			return fmt.Errorf("aggregates bucket not found")
+		}
+
+		v := b.Get([]byte(aggregateName))
+		if v == nil {
+			return fmt.Errorf("aggregate '%s' not found", aggregateName)
+		}
+		version, err := strconv.ParseInt(string(v), 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse version for aggregate '%s': %w", aggregateName, err)
+		}
+
+		record, err := getCheckpointRecord(tx, aggregateName)
+		if err != nil {
+			return err
+		}
+		checkpoint = record
+		checkpoint.Aggregate = aggregateName
+		checkpoint.ChangeVersion = version
+		return nil
+	})
+
+	return checkpoint, err
+}
+
+// CycleHistory returns up to limit of the most recent recorded cycles, newest first
+func (r *BBoltRepository) CycleHistory(ctx context.Context, aggregateName string, limit int) ([]tracker.CycleRecord, error) {
+	var cycles []tracker.CycleRecord
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		history := tx.Bucket([]byte("history"))
+		if history == nil {
+			return nil
+		}
+		b := history.Bucket([]byte(aggregateName))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil && len(cycles) < limit; k, v = c.Prev() {
+			var cycle tracker.CycleRecord
+			if err := json.Unmarshal(v, &cycle); err != nil {
+				return fmt.Errorf("failed to parse cycle for aggregate '%s': %w", aggregateName, err)
+			}
+			cycles = append(cycles, cycle)
+		}
+		return nil
+	})
+
+	return cycles, err
+}
+
+// ListChangeVersions returns the change version of every registered aggregate
+func (r *BBoltRepository) ListChangeVersions(ctx context.Context) (map[string]int64, error) {
+	versions := make(map[string]int64)
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("aggregates"))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			version, err := strconv.ParseInt(string(v), 10, 64)
+			if err != nil {
+				return fmt.Errorf("failed to parse version for aggregate '%s': %w", string(k), err)
+			}
+			versions[string(k)] = version
+			return nil
+		})
+	})
+
+	return versions, err
+}
+
+// RenameAggregate moves the version, checkpoint record and history of an aggregate to a new name
+func (r *BBoltRepository) RenameAggregate(ctx context.Context, oldName string, newName string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("aggregates"))
+		if err != nil {
+			return err
+		}
+
+		version := b.Get([]byte(oldName))
+		if version == nil {
+			return nil
+		}
+		if b.Get([]byte(newName)) != nil {
+			return fmt.Errorf("cannot rename aggregate '%s', '%s' is already registered", oldName, newName)
+		}
+
+		versionStr := string(version)
+		if err := b.Put([]byte(newName), []byte(versionStr)); err != nil {
+			return fmt.Errorf("failed to rename aggregate '%s': %w", oldName, err)
+		}
+		if err := b.Delete([]byte(oldName)); err != nil {
+			return fmt.Errorf("failed to rename aggregate '%s': %w", oldName, err)
+		}
+
+		record, err := getCheckpointRecord(tx, oldName)
+		if err != nil {
+			return err
+		}
+		if err := putCheckpointRecord(tx, newName, record); err != nil {
+			return err
+		}
+		if checkpoints := tx.Bucket([]byte("checkpoints")); checkpoints != nil {
+			if err := checkpoints.Delete([]byte(oldName)); err != nil {
+				return err
+			}
+		}
+
+		if err := moveHistory(tx, oldName, newName); err != nil {
+			return fmt.Errorf("failed to move history of aggregate '%s': %w", oldName, err)
+		}
+
+		r.logger.Info("aggregate renamed", "from", oldName, "to", newName, "version", versionStr)
+		return nil
+	})
+}
+
+// DeleteAggregate removes the version, checkpoint record and history of an aggregate
+func (r *BBoltRepository) DeleteAggregate(ctx context.Context, aggregateName string) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("aggregates"))
+		if b == nil || b.Get([]byte(aggregateName)) == nil {
+			return fmt.Errorf("aggregate '%s' not found", aggregateName)
+		}
+		if err := b.Delete([]byte(aggregateName)); err != nil {
+			return fmt.Errorf("failed to delete aggregate '%s': %w", aggregateName, err)
+		}
+
+		if checkpoints := tx.Bucket([]byte("checkpoints")); checkpoints != nil {
+			if err := checkpoints.Delete([]byte(aggregateName)); err != nil {
+				return err
+			}
+		}
+		if history := tx.Bucket([]byte("history")); history != nil && history.Bucket([]byte(aggregateName)) != nil {
+			if err := history.DeleteBucket([]byte(aggregateName)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// moveHistory copies the history bucket of an aggregate to a new name and removes the old one
+func moveHistory(tx *bbolt.Tx, oldName string, newName string) error {
+	history := tx.Bucket([]byte("history"))
+	if history == nil {
+		return nil
+	}
+	old := history.Bucket([]byte(oldName))
+	if old == nil {
+		return nil
+	}
+
+	moved, err := history.CreateBucket([]byte(newName))
+	if err != nil {
+		return err
+	}
+	err = old.ForEach(func(k, v []byte) error {
+		return moved.Put(k, v)
+	})
+	if err != nil {
+		return err
+	}
+	if err := moved.SetSequence(old.Sequence()); err != nil {
+		return err
+	}
+	return history.DeleteBucket([]byte(oldName))
+}
+
+// QuarantineRow stores the row in the quarantine bucket, its ID is the bucket sequence
+func (r *BBoltRepository) QuarantineRow(ctx context.Context, row tracker.QuarantinedRow) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte("quarantine"))
+		if err != nil {
+			return err
+		}
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		row.ID = int64(seq)
+		value, err := json.Marshal(row)
+		if err != nil {
+			return fmt.Errorf("failed to marshal quarantined row of aggregate '%s': %w", row.Aggregate, err)
+		}
+		return b.Put(sequenceKey(seq), value)
+	})
+}
+
+// ListQuarantined returns the quarantined rows of the aggregate ordered by ID, all rows when the
+// aggregate name is empty
+func (r *BBoltRepository) ListQuarantined(ctx context.Context, aggregateName string) ([]tracker.QuarantinedRow, error) {
+	var rows []tracker.QuarantinedRow
+
+	err := r.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("quarantine"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			row, err := decodeQuarantinedRow(v)
+			if err != nil {
+				return err
+			}
+			if aggregateName == "" || row.Aggregate == aggregateName {
+				rows = append(rows, row)
+			}
+			return nil
+		})
+	})
+	return rows, err
+}
+
+// DeleteQuarantined removes a quarantined row
+func (r *BBoltRepository) DeleteQuarantined(ctx context.Context, id int64) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte("quarantine"))
+		if b == nil || id <= 0 || b.Get(sequenceKey(uint64(id))) == nil {
+			return fmt.Errorf("quarantined row %d not found", id)
+		}
+		return b.Delete(sequenceKey(uint64(id)))
+	})
+}
+
+// Check reports whether the database can be read
+func (r *BBoltRepository) Check(ctx context.Context) error {
+	return r.db.View(func(tx *bbolt.Tx) error {
+		return nil
+	})
+}
+
+// getCheckpointRecord returns the stored checkpoint record, a missing record is returned empty
+func getCheckpointRecord(tx *bbolt.Tx, aggregateName string) (tracker.Checkpoint, error) {
+	var record tracker.Checkpoint
+
+	b := tx.Bucket([]byte("checkpoints"))
+	if b == nil {
+		return record, nil
+	}
+	v := b.Get([]byte(aggregateName))
+	if v == nil {
+		return record, nil
+	}
+	if err := json.Unmarshal(v, &record); err != nil {
+		return record, fmt.Errorf("failed to parse checkpoint for aggregate '%s': %w", aggregateName, err)
+	}
+	return record, nil
+}
+
+func putCheckpointRecord(tx *bbolt.Tx, aggregateName string, record tracker.Checkpoint) error {
+	b, err := tx.CreateBucketIfNotExists([]byte("checkpoints"))
+	if err != nil {
+		return err
+	}
+
+	// the change version itself lives in the aggregates bucket
+	record.Aggregate = ""
+	record.ChangeVersion = 0
+	value, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkpoint for aggregate '%s': %w", aggregateName, err)
+	}
+	if err := b.Put([]byte(aggregateName), value); err != nil {
+		return fmt.Errorf("failed to store checkpoint for aggregate '%s': %w", aggregateName, err)
+	}
+	return nil
+}
+
+// decodeQuarantinedRow parses a stored quarantined row, numbers keep their exact value so
+// change versions survive the round trip
+func decodeQuarantinedRow(data []byte) (tracker.QuarantinedRow, error) {
+	var row tracker.QuarantinedRow
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&row); err != nil {
+		return row, fmt.Errorf("failed to parse quarantined row: %w", err)
+	}
+	return row, nil
+}
+
+// sequenceKey encodes a bucket sequence so keys sort in insertion order
+func sequenceKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
 // Close closes the database
 func (r *BBoltRepository) Close() error {
 	return r.db.Close()
@@ -2,11 +2,14 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"io"
 	"log/slog"
 	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/salesworks/s-works/slx/internal/tracker"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"go.etcd.io/bbolt"
@@ -137,3 +140,187 @@ func TestBBoltRepository_GetChangeVersion(t *testing.T) {
 	require.NoError(t, err, "GetChangeVersion should not return an error")
 	assert.Equal(t, int64(5), version, "should return the updated version")
 }
+
+func TestBBoltRepository_ListChangeVersions(t *testing.T) {
+	// --- Arrange ---
+	tempDir := t.TempDir()
+	dbPath := filepath.Join(tempDir, "test.db")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	repo, err := NewBBoltRepository(dbPath, logger)
+	require.NoError(t, err, "NewBBoltRepository should not return an error")
+	defer repo.Close()
+
+	err = repo.RegisterAggregates(ctx, []string{"users", "orders"})
+	require.NoError(t, err, "RegisterAggregates should not return an error")
+	err = repo.UpdateChangeVersion(ctx, "orders", int64(7))
+	require.NoError(t, err, "UpdateChangeVersion should not return an error")
+
+	// --- Act ---
+	versions, err := repo.ListChangeVersions(ctx)
+
+	// --- Assert ---
+	require.NoError(t, err, "ListChangeVersions should not return an error")
+	assert.Equal(t, map[string]int64{"users": 0, "orders": 7}, versions)
+}
+
+func TestBBoltRepository_RecordCycle(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "test.db"), logger)
+	require.NoError(t, err, "NewBBoltRepository should not return an error")
+	defer repo.Close()
+	require.NoError(t, repo.RegisterAggregates(ctx, []string{"users"}))
+
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	advanced := tracker.CycleRecord{Start: start, End: start.Add(time.Second), FromVersion: 0, ToVersion: 7, RowsFetched: 3}
+	empty := tracker.CycleRecord{Start: start.Add(time.Minute), End: start.Add(time.Minute + time.Second), FromVersion: 7, ToVersion: 7}
+
+	// --- Act ---
+	require.NoError(t, repo.UpdateChangeVersion(ctx, "users", 7))
+	require.NoError(t, repo.RecordCycle(ctx, "users", advanced))
+	require.NoError(t, repo.RecordCycle(ctx, "users", empty))
+
+	// --- Assert ---
+	checkpoint, err := repo.GetCheckpoint(ctx, "users")
+	require.NoError(t, err, "GetCheckpoint should not return an error")
+	assert.Equal(t, "users", checkpoint.Aggregate)
+	assert.Equal(t, int64(7), checkpoint.ChangeVersion)
+	assert.Equal(t, int64(0), checkpoint.PreviousVersion)
+	assert.False(t, checkpoint.UpdatedAt.IsZero(), "updated_at should be set")
+	assert.True(t, empty.Start.Equal(checkpoint.LastRunStart), "last run should reflect the latest cycle")
+	assert.Equal(t, 0, checkpoint.RowsFetched)
+
+	history, err := repo.CycleHistory(ctx, "users", 10)
+	require.NoError(t, err, "CycleHistory should not return an error")
+	require.Len(t, history, 1, "only the cycle that advanced belongs in the history")
+	assert.Equal(t, int64(7), history[0].ToVersion)
+}
+
+func TestBBoltRepository_CycleHistory_IsBounded(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "test.db"), logger)
+	require.NoError(t, err, "NewBBoltRepository should not return an error")
+	defer repo.Close()
+	require.NoError(t, repo.RegisterAggregates(ctx, []string{"users"}))
+
+	// --- Act ---
+	for i := range maxCycleHistory + 5 {
+		cycle := tracker.CycleRecord{FromVersion: int64(i), ToVersion: int64(i + 1)}
+		require.NoError(t, repo.RecordCycle(ctx, "users", cycle))
+	}
+
+	// --- Assert ---
+	history, err := repo.CycleHistory(ctx, "users", maxCycleHistory*2)
+	require.NoError(t, err, "CycleHistory should not return an error")
+	require.Len(t, history, maxCycleHistory)
+	assert.Equal(t, int64(maxCycleHistory+5), history[0].ToVersion, "newest cycle should come first")
+	assert.Equal(t, int64(6), history[len(history)-1].ToVersion, "oldest cycles should be dropped")
+}
+
+func TestBBoltRepository_RenameAggregate(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "test.db"), logger)
+	require.NoError(t, err, "NewBBoltRepository should not return an error")
+	defer repo.Close()
+	require.NoError(t, repo.RegisterAggregates(ctx, []string{"contractor", "orders"}))
+	require.NoError(t, repo.UpdateChangeVersion(ctx, "contractor", 12))
+	require.NoError(t, repo.RecordCycle(ctx, "contractor", tracker.CycleRecord{FromVersion: 0, ToVersion: 12}))
+
+	// --- Act ---
+	err = repo.RenameAggregate(ctx, "contractor", "customer")
+	require.NoError(t, err, "RenameAggregate should not return an error")
+	err = repo.RenameAggregate(ctx, "contractor", "customer")
+	require.NoError(t, err, "repeating a finished rename should do nothing")
+
+	// --- Assert ---
+	version, err := repo.GetChangeVersion(ctx, "customer")
+	require.NoError(t, err)
+	assert.Equal(t, int64(12), version, "the version should move with the aggregate")
+
+	checkpoint, err := repo.GetCheckpoint(ctx, "customer")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), checkpoint.PreviousVersion)
+
+	history, err := repo.CycleHistory(ctx, "customer", 10)
+	require.NoError(t, err)
+	assert.Len(t, history, 1, "the history should move with the aggregate")
+
+	_, err = repo.GetChangeVersion(ctx, "contractor")
+	assert.Error(t, err, "the old name should be removed")
+
+	err = repo.RenameAggregate(ctx, "orders", "customer")
+	assert.Error(t, err, "renaming onto a registered aggregate should fail")
+}
+
+func TestBBoltRepository_DeleteAggregate(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	repo, err := NewBBoltRepository(filepath.Join(t.TempDir(), "test.db"), logger)
+	require.NoError(t, err, "NewBBoltRepository should not return an error")
+	defer repo.Close()
+	require.NoError(t, repo.RegisterAggregates(ctx, []string{"users", "orders"}))
+	require.NoError(t, repo.RecordCycle(ctx, "users", tracker.CycleRecord{FromVersion: 0, ToVersion: 3}))
+
+	// --- Act ---
+	err = repo.DeleteAggregate(ctx, "users")
+
+	// --- Assert ---
+	require.NoError(t, err, "DeleteAggregate should not return an error")
+	versions, err := repo.ListChangeVersions(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int64{"orders": 0}, versions)
+
+	history, err := repo.CycleHistory(ctx, "users", 10)
+	require.NoError(t, err)
+	assert.Empty(t, history)
+
+	assert.Error(t, repo.DeleteAggregate(ctx, "users"), "deleting a missing aggregate should fail")
+}
+
+func TestBBoltRepository_Quarantine(t *testing.T) {
+	// --- Arrange ---
+	dbPath := filepath.Join(t.TempDir(), "test.db")
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := context.Background()
+
+	repo, err := NewBBoltRepository(dbPath, logger)
+	require.NoError(t, err, "NewBBoltRepository should not return an error")
+	defer repo.Close()
+
+	// --- Act ---
+	for _, aggregate := range []string{"customer", "fabric", "customer"} {
+		err := repo.QuarantineRow(ctx, tracker.QuarantinedRow{
+			Aggregate:     aggregate,
+			ChangeVersion: 9007199254740993,
+			Values:        map[string]any{"change_version": int64(9007199254740993), "aggregate_key": ""},
+			Error:         "aggregate Key is required",
+			QuarantinedAt: time.Now(),
+		})
+		require.NoError(t, err)
+	}
+	customers, err := repo.ListQuarantined(ctx, "customer")
+	require.NoError(t, err)
+	require.NoError(t, repo.DeleteQuarantined(ctx, customers[0].ID))
+	all, err := repo.ListQuarantined(ctx, "")
+	require.NoError(t, err)
+
+	// --- Assert ---
+	require.Len(t, customers, 2)
+	assert.Equal(t, []int64{1, 3}, []int64{customers[0].ID, customers[1].ID})
+	assert.Equal(t, "9007199254740993", fmt.Sprint(customers[0].Values["change_version"]),
+		"numbers should keep their exact value")
+	assert.Len(t, all, 2, "the deleted row should be gone")
+	assert.Error(t, repo.DeleteQuarantined(ctx, customers[0].ID), "deleting a missing row should fail")
+}
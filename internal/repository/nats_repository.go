@@ -0,0 +1,326 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+	"github.com/salesworks/s-works/slx/internal/tracker"
+)
+
+// NatsKVRepository implements TrackerRepository using a NATS JetStream key-value bucket, so
+// several hosts can share the checkpoints
+type NatsKVRepository struct {
+	conn   *nats.Conn
+	kv     jetstream.KeyValue
+	logger *slog.Logger
+}
+
+// NewNatsKVRepository creates a new NATS key-value repository, the bucket is created when it
+// does not exist. The repository takes ownership of the connection.
+func NewNatsKVRepository(
+	ctx context.Context, conn *nats.Conn, bucket string, logger *slog.Logger,
+) (*NatsKVRepository, error) {
+	js, err := jetstream.New(conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create jetstream context: %w", err)
+	}
+
+	kv, err := js.KeyValue(ctx, bucket)
+	if errors.Is(err, jetstream.ErrBucketNotFound) {
+		kv, err = js.CreateKeyValue(ctx, jetstream.KeyValueConfig{
+			Bucket:      bucket,
+			Description: "SLX aggregate checkpoints",
+		})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open key-value bucket '%s': %w", bucket, err)
+	}
+
+	return &NatsKVRepository{
+		conn:   conn,
+		kv:     kv,
+		logger: logger,
+	}, nil
+}
+
+// RegisterAggregates inserts aggregate names with counter = 0
+func (r *NatsKVRepository) RegisterAggregates(ctx context.Context, aggregates []string) error {
+	for _, name := range aggregates {
+		_, err := r.kv.Create(ctx, name, []byte("0"))
+		if err == nil {
+			r.logger.Info("aggregate registered", "name", name, "version", "0")
+			continue
+		}
+		if !errors.Is(err, jetstream.ErrKeyExists) {
+			return fmt.Errorf("failed to register aggregate '%s': %w", name, err)
+		}
+
+		version, err := r.GetChangeVersion(ctx, name)
+		if err != nil {
+			return err
+		}
+		r.logger.Info("aggregate registered", "name", name, "version", version)
+	}
+	return nil
+}
+
+// GetChangeVersion returns the last change version for the given aggregate name
+func (r *NatsKVRepository) GetChangeVersion(ctx context.Context, aggregateName string) (int64, error) {
+	entry, err := r.kv.Get(ctx, aggregateName)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return 0, fmt.Errorf("aggregate '%s' not found", aggregateName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get version for aggregate '%s': %w", aggregateName, err)
+	}
+
+	version, err := strconv.ParseInt(string(entry.Value()), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse version for aggregate '%s': %w", aggregateName, err)
+	}
+	return version, nil
+}
+
+// UpdateChangeVersion updates the change version for the given aggregate name
+func (r *NatsKVRepository) UpdateChangeVersion(ctx context.Context, aggregateName string, newVersion int64) error {
+	entry, err := r.kv.Get(ctx, aggregateName)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return fmt.Errorf("aggregate '%s' not found", aggregateName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get version for aggregate '%s': %w", aggregateName, err)
+	}
+
+	// the revision check rejects the update when another writer changed the key in between
+	versionStr := strconv.FormatInt(newVersion, 10)
+	if _, err := r.kv.Update(ctx, aggregateName, []byte(versionStr), entry.Revision()); err != nil {
+		return fmt.Errorf("failed to update version for aggregate '%s': %w", aggregateName, err)
+	}
+
+	// Keep the previous version in the checkpoint record as a point to roll back to
+	previousVersion, err := strconv.ParseInt(string(entry.Value()), 10, 64)
+	if err != nil {
+		return fmt.Errorf("failed to parse version for aggregate '%s': %w", aggregateName, err)
+	}
+	if previousVersion == newVersion {
+		return nil
+	}
+	record, err := r.getRecord(ctx, aggregateName)
+	if err != nil {
+		return err
+	}
+	record.PreviousVersion = previousVersion
+	record.UpdatedAt = time.Now()
+	return r.putJSON(ctx, recordKey(aggregateName), record)
+}
+
+// RecordCycle stores the outcome of a cycle in the checkpoint record, cycles that advanced the
+// version or failed are also appended to the bounded cycle history
+func (r *NatsKVRepository) RecordCycle(ctx context.Context, aggregateName string, cycle tracker.CycleRecord) error {
+	record, err := r.getRecord(ctx, aggregateName)
+	if err != nil {
+		return err
+	}
+	record.LastRunStart = cycle.Start
+	record.LastRunEnd = cycle.End
+	record.RowsFetched = cycle.RowsFetched
+	record.LastError = cycle.Error
+	if err := r.putJSON(ctx, recordKey(aggregateName), record); err != nil {
+		return err
+	}
+
+	if !cycle.Noteworthy() {
+		return nil
+	}
+
+	// the history is kept oldest first in a single bounded value
+	var history []tracker.CycleRecord
+	if err := r.getJSON(ctx, historyKey(aggregateName), &history); err != nil {
+		return err
+	}
+	history = append(history, cycle)
+	if len(history) > maxCycleHistory {
+		history = history[len(history)-maxCycleHistory:]
+	}
+	return r.putJSON(ctx, historyKey(aggregateName), history)
+}
+
+// GetCheckpoint returns the checkpoint of the given aggregate name
+func (r *NatsKVRepository) GetCheckpoint(ctx context.Context, aggregateName string) (tracker.Checkpoint, error) {
+	version, err := r.GetChangeVersion(ctx, aggregateName)
+	if err != nil {
+		return tracker.Checkpoint{}, err
+	}
+
+	checkpoint, err := r.getRecord(ctx, aggregateName)
+	if err != nil {
+		return tracker.Checkpoint{}, err
+	}
+	checkpoint.Aggregate = aggregateName
+	checkpoint.ChangeVersion = version
+	return checkpoint, nil
+}
+
+// CycleHistory returns up to limit of the most recent recorded cycles, newest first
+func (r *NatsKVRepository) CycleHistory(ctx context.Context, aggregateName string, limit int) ([]tracker.CycleRecord, error) {
+	var history []tracker.CycleRecord
+	if err := r.getJSON(ctx, historyKey(aggregateName), &history); err != nil {
+		return nil, err
+	}
+
+	var cycles []tracker.CycleRecord
+	for i := len(history) - 1; i >= 0 && len(cycles) < limit; i-- {
+		cycles = append(cycles, history[i])
+	}
+	return cycles, nil
+}
+
+// RenameAggregate moves the version, checkpoint record and history of an aggregate to a new
+// name. Key-value buckets have no transactions, the new keys are written before the old ones
+// are deleted so an interrupted rename leaves both names behind rather than losing the version.
+func (r *NatsKVRepository) RenameAggregate(ctx context.Context, oldName string, newName string) error {
+	entry, err := r.kv.Get(ctx, oldName)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get version for aggregate '%s': %w", oldName, err)
+	}
+
+	if _, err := r.kv.Create(ctx, newName, entry.Value()); err != nil {
+		if errors.Is(err, jetstream.ErrKeyExists) {
+			return fmt.Errorf("cannot rename aggregate '%s', '%s' is already registered", oldName, newName)
+		}
+		return fmt.Errorf("failed to rename aggregate '%s': %w", oldName, err)
+	}
+
+	for _, key := range []func(string) string{recordKey, historyKey} {
+		moved, err := r.kv.Get(ctx, key(oldName))
+		if errors.Is(err, jetstream.ErrKeyNotFound) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get key '%s': %w", key(oldName), err)
+		}
+		if _, err := r.kv.Put(ctx, key(newName), moved.Value()); err != nil {
+			return fmt.Errorf("failed to store key '%s': %w", key(newName), err)
+		}
+	}
+
+	if err := r.deleteKeys(ctx, oldName); err != nil {
+		return err
+	}
+	r.logger.Info("aggregate renamed", "from", oldName, "to", newName, "version", string(entry.Value()))
+	return nil
+}
+
+// DeleteAggregate removes the version, checkpoint record and history of an aggregate
+func (r *NatsKVRepository) DeleteAggregate(ctx context.Context, aggregateName string) error {
+	if _, err := r.GetChangeVersion(ctx, aggregateName); err != nil {
+		return err
+	}
+	return r.deleteKeys(ctx, aggregateName)
+}
+
+func (r *NatsKVRepository) deleteKeys(ctx context.Context, aggregateName string) error {
+	for _, key := range []string{recordKey(aggregateName), historyKey(aggregateName), aggregateName} {
+		if err := r.kv.Delete(ctx, key); err != nil && !errors.Is(err, jetstream.ErrKeyNotFound) {
+			return fmt.Errorf("failed to delete key '%s': %w", key, err)
+		}
+	}
+	return nil
+}
+
+func (r *NatsKVRepository) getRecord(ctx context.Context, aggregateName string) (tracker.Checkpoint, error) {
+	var record tracker.Checkpoint
+	err := r.getJSON(ctx, recordKey(aggregateName), &record)
+	return record, err
+}
+
+// getJSON decodes the value of the key into v, a missing key leaves v untouched
+func (r *NatsKVRepository) getJSON(ctx context.Context, key string, v any) error {
+	entry, err := r.kv.Get(ctx, key)
+	if errors.Is(err, jetstream.ErrKeyNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get key '%s': %w", key, err)
+	}
+	if err := json.Unmarshal(entry.Value(), v); err != nil {
+		return fmt.Errorf("failed to parse key '%s': %w", key, err)
+	}
+	return nil
+}
+
+func (r *NatsKVRepository) putJSON(ctx context.Context, key string, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key '%s': %w", key, err)
+	}
+	if _, err := r.kv.Put(ctx, key, value); err != nil {
+		return fmt.Errorf("failed to store key '%s': %w", key, err)
+	}
+	return nil
+}
+
+// recordKey and historyKey live next to the plain version keys, the dot keeps them apart
+// from aggregate names
+func recordKey(aggregateName string) string {
+	return "record." + aggregateName
+}
+
+func historyKey(aggregateName string) string {
+	return "history." + aggregateName
+}
+
+// ListChangeVersions returns the change version of every registered aggregate
+func (r *NatsKVRepository) ListChangeVersions(ctx context.Context) (map[string]int64, error) {
+	versions := make(map[string]int64)
+
+	lister, err := r.kv.ListKeys(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list aggregates: %w", err)
+	}
+	defer lister.Stop()
+
+	var names []string
+	for name := range lister.Keys() {
+		if strings.Contains(name, ".") {
+			continue
+		}
+		names = append(names, name)
+	}
+
+	for _, name := range names {
+		version, err := r.GetChangeVersion(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		versions[name] = version
+	}
+	return versions, nil
+}
+
+// Check reports whether the NATS connection is established
+func (r *NatsKVRepository) Check(ctx context.Context) error {
+	if status := r.conn.Status(); status != nats.CONNECTED {
+		return fmt.Errorf("nats connection is %s", status)
+	}
+	return nil
+}
+
+// Close drains the NATS connection
+func (r *NatsKVRepository) Close() error {
+	if r.conn != nil && !r.conn.IsClosed() {
+		return r.conn.Drain()
+	}
+	return nil
+}
@@ -0,0 +1,508 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/salesworks/s-works/slx/internal/tracker"
+)
+
+// PostgresRepository implements TrackerRepository using a PostgreSQL table, it shares the
+// database with the events table so a cycle can be committed in a single transaction
+type PostgresRepository struct {
+	db     *sql.DB
+	logger *slog.Logger
+}
+
+// NewPostgresRepository creates a new Postgres repository and its checkpoints table
+func NewPostgresRepository(ctx context.Context, db *sql.DB, logger *slog.Logger) (*PostgresRepository, error) {
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS slx_checkpoints (
+			aggregate_name TEXT PRIMARY KEY,
+			change_version BIGINT NOT NULL DEFAULT 0,
+			updated_at     TIMESTAMPTZ NOT NULL DEFAULT now()
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoints table: %w", err)
+	}
+
+	// columns added after the first release are created on existing tables as well
+	_, err = db.ExecContext(ctx, `
+		ALTER TABLE slx_checkpoints
+			ADD COLUMN IF NOT EXISTS previous_version BIGINT NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS last_run_start   TIMESTAMPTZ,
+			ADD COLUMN IF NOT EXISTS last_run_end     TIMESTAMPTZ,
+			ADD COLUMN IF NOT EXISTS rows_fetched     INTEGER NOT NULL DEFAULT 0,
+			ADD COLUMN IF NOT EXISTS last_error       TEXT NOT NULL DEFAULT ''
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate checkpoints table: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS slx_checkpoint_history (
+			id             BIGSERIAL PRIMARY KEY,
+			aggregate_name TEXT NOT NULL,
+			run_start      TIMESTAMPTZ NOT NULL,
+			run_end        TIMESTAMPTZ NOT NULL,
+			from_version   BIGINT NOT NULL,
+			to_version     BIGINT NOT NULL,
+			rows_fetched   INTEGER NOT NULL,
+			error          TEXT NOT NULL DEFAULT ''
+		);
+		CREATE INDEX IF NOT EXISTS slx_checkpoint_history_aggregate_idx
+			ON slx_checkpoint_history (aggregate_name, id);
+		ALTER TABLE slx_checkpoint_history
+			ADD COLUMN IF NOT EXISTS rows_skipped INTEGER NOT NULL DEFAULT 0
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkpoint history table: %w", err)
+	}
+
+	_, err = db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS slx_quarantine (
+			id             BIGSERIAL PRIMARY KEY,
+			aggregate_name TEXT NOT NULL,
+			change_version BIGINT NOT NULL,
+			row_values     JSONB NOT NULL,
+			error          TEXT NOT NULL,
+			quarantined_at TIMESTAMPTZ NOT NULL
+		)
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create quarantine table: %w", err)
+	}
+
+	return &PostgresRepository{
+		db:     db,
+		logger: logger,
+	}, nil
+}
+
+// RegisterAggregates inserts aggregate names with change version = 0
+func (r *PostgresRepository) RegisterAggregates(ctx context.Context, aggregates []string) error {
+	for _, name := range aggregates {
+		_, err := r.db.ExecContext(
+			ctx,
+			"INSERT INTO slx_checkpoints (aggregate_name) VALUES ($1) ON CONFLICT (aggregate_name) DO NOTHING",
+			name,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to register aggregate '%s': %w", name, err)
+		}
+
+		version, err := r.GetChangeVersion(ctx, name)
+		if err != nil {
+			return err
+		}
+		r.logger.Info("aggregate registered", "name", name, "version", version)
+	}
+	return nil
+}
+
+// GetChangeVersion returns the last change version for the given aggregate name
+func (r *PostgresRepository) GetChangeVersion(ctx context.Context, aggregateName string) (int64, error) {
+	var version int64
+	err := r.db.QueryRowContext(
+		ctx, "SELECT change_version FROM slx_checkpoints WHERE aggregate_name = $1", aggregateName,
+	).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, fmt.Errorf("aggregate '%s' not found", aggregateName)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get version for aggregate '%s': %w", aggregateName, err)
+	}
+	return version, nil
+}
+
+// UpdateChangeVersion updates the change version for the given aggregate name
+func (r *PostgresRepository) UpdateChangeVersion(ctx context.Context, aggregateName string, newVersion int64) error {
+	return updateCheckpoint(ctx, r.db, aggregateName, newVersion)
+}
+
+// ListChangeVersions returns the change version of every registered aggregate
+func (r *PostgresRepository) ListChangeVersions(ctx context.Context) (map[string]int64, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT aggregate_name, change_version FROM slx_checkpoints")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list aggregates: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var name string
+		var version int64
+		if err := rows.Scan(&name, &version); err != nil {
+			return nil, fmt.Errorf("row scan failed: %w", err)
+		}
+		versions[name] = version
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return versions, nil
+}
+
+// RecordCycle stores the outcome of a cycle in the checkpoint row, cycles that advanced the
+// version or failed are also appended to the bounded cycle history
+func (r *PostgresRepository) RecordCycle(ctx context.Context, aggregateName string, cycle tracker.CycleRecord) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `
+		UPDATE slx_checkpoints
+		SET last_run_start = $2, last_run_end = $3, rows_fetched = $4, last_error = $5
+		WHERE aggregate_name = $1
+	`, aggregateName, cycle.Start, cycle.End, cycle.RowsFetched, cycle.Error)
+	if err != nil {
+		return fmt.Errorf("failed to record cycle for aggregate '%s': %w", aggregateName, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to record cycle for aggregate '%s': %w", aggregateName, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("aggregate '%s' not found", aggregateName)
+	}
+
+	if cycle.Noteworthy() {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO slx_checkpoint_history
+				(aggregate_name, run_start, run_end, from_version, to_version, rows_fetched, rows_skipped, error)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		`, aggregateName, cycle.Start, cycle.End, cycle.FromVersion, cycle.ToVersion, cycle.RowsFetched,
+			cycle.RowsSkipped, cycle.Error)
+		if err != nil {
+			return fmt.Errorf("failed to store cycle for aggregate '%s': %w", aggregateName, err)
+		}
+
+		// Drop the oldest cycles beyond the history limit
+		_, err = tx.ExecContext(ctx, `
+			DELETE FROM slx_checkpoint_history
+			WHERE aggregate_name = $1 AND id <= (
+				SELECT id FROM slx_checkpoint_history
+				WHERE aggregate_name = $1
+				ORDER BY id DESC
+				OFFSET $2 LIMIT 1
+			)
+		`, aggregateName, maxCycleHistory)
+		if err != nil {
+			return fmt.Errorf("failed to trim cycle history for aggregate '%s': %w", aggregateName, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit cycle record for aggregate '%s': %w", aggregateName, err)
+	}
+	return nil
+}
+
+// GetCheckpoint returns the checkpoint of the given aggregate name
+func (r *PostgresRepository) GetCheckpoint(ctx context.Context, aggregateName string) (tracker.Checkpoint, error) {
+	checkpoint := tracker.Checkpoint{Aggregate: aggregateName}
+	var lastRunStart, lastRunEnd sql.NullTime
+
+	err := r.db.QueryRowContext(ctx, `
+		SELECT change_version, previous_version, updated_at, last_run_start, last_run_end, rows_fetched, last_error
+		FROM slx_checkpoints
+		WHERE aggregate_name = $1
+	`, aggregateName).Scan(
+		&checkpoint.ChangeVersion, &checkpoint.PreviousVersion, &checkpoint.UpdatedAt,
+		&lastRunStart, &lastRunEnd, &checkpoint.RowsFetched, &checkpoint.LastError,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return checkpoint, fmt.Errorf("aggregate '%s' not found", aggregateName)
+	}
+	if err != nil {
+		return checkpoint, fmt.Errorf("failed to get checkpoint for aggregate '%s': %w", aggregateName, err)
+	}
+
+	checkpoint.LastRunStart = lastRunStart.Time
+	checkpoint.LastRunEnd = lastRunEnd.Time
+	return checkpoint, nil
+}
+
+// CycleHistory returns up to limit of the most recent recorded cycles, newest first
+func (r *PostgresRepository) CycleHistory(ctx context.Context, aggregateName string, limit int) ([]tracker.CycleRecord, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT run_start, run_end, from_version, to_version, rows_fetched, rows_skipped, error
+		FROM slx_checkpoint_history
+		WHERE aggregate_name = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, aggregateName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cycle history for aggregate '%s': %w", aggregateName, err)
+	}
+	defer rows.Close()
+
+	var cycles []tracker.CycleRecord
+	for rows.Next() {
+		var cycle tracker.CycleRecord
+		err := rows.Scan(
+			&cycle.Start, &cycle.End, &cycle.FromVersion, &cycle.ToVersion, &cycle.RowsFetched, &cycle.RowsSkipped,
+			&cycle.Error,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("row scan failed: %w", err)
+		}
+		cycles = append(cycles, cycle)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return cycles, nil
+}
+
+// RenameAggregate moves the checkpoint and history of an aggregate to a new name
+func (r *PostgresRepository) RenameAggregate(ctx context.Context, oldName string, newName string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var version int64
+	err = tx.QueryRowContext(
+		ctx, "SELECT change_version FROM slx_checkpoints WHERE aggregate_name = $1 FOR UPDATE", oldName,
+	).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get version for aggregate '%s': %w", oldName, err)
+	}
+
+	var exists bool
+	err = tx.QueryRowContext(
+		ctx, "SELECT EXISTS (SELECT 1 FROM slx_checkpoints WHERE aggregate_name = $1)", newName,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check aggregate '%s': %w", newName, err)
+	}
+	if exists {
+		return fmt.Errorf("cannot rename aggregate '%s', '%s' is already registered", oldName, newName)
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE slx_checkpoints SET aggregate_name = $2 WHERE aggregate_name = $1", oldName, newName)
+	if err != nil {
+		return fmt.Errorf("failed to rename aggregate '%s': %w", oldName, err)
+	}
+	_, err = tx.ExecContext(
+		ctx, "UPDATE slx_checkpoint_history SET aggregate_name = $2 WHERE aggregate_name = $1", oldName, newName,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to move history of aggregate '%s': %w", oldName, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit rename of aggregate '%s': %w", oldName, err)
+	}
+	r.logger.Info("aggregate renamed", "from", oldName, "to", newName, "version", version)
+	return nil
+}
+
+// DeleteAggregate removes the checkpoint and history of an aggregate
+func (r *PostgresRepository) DeleteAggregate(ctx context.Context, aggregateName string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, "DELETE FROM slx_checkpoints WHERE aggregate_name = $1", aggregateName)
+	if err != nil {
+		return fmt.Errorf("failed to delete aggregate '%s': %w", aggregateName, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete aggregate '%s': %w", aggregateName, err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("aggregate '%s' not found", aggregateName)
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM slx_checkpoint_history WHERE aggregate_name = $1", aggregateName)
+	if err != nil {
+		return fmt.Errorf("failed to delete history of aggregate '%s': %w", aggregateName, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit delete of aggregate '%s': %w", aggregateName, err)
+	}
+	return nil
+}
+
+// CommitCycle stores the events and the quarantined rows of a cycle and the new change version
+// of the aggregate in one transaction, either all are committed or none is. The change version
+// is only advanced from the version the cycle read from, so a checkpoint set by the admin server
+// or another instance during the cycle is not overwritten and the events are not stored twice.
+func (r *PostgresRepository) CommitCycle(
+	ctx context.Context, aggregateName string, envelopes []*messaging.EventEnvelope,
+	quarantined []tracker.QuarantinedRow, fromVersion int64, newVersion int64,
+) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, envelope := range envelopes {
+		if err := messaging.InsertEvent(ctx, tx, envelope); err != nil {
+			return fmt.Errorf("failed to store event '%s': %w", envelope.EventID, err)
+		}
+	}
+	for _, row := range quarantined {
+		if err := quarantineRow(ctx, tx, row); err != nil {
+			return err
+		}
+	}
+
+	res, err := tx.ExecContext(
+		ctx,
+		`UPDATE slx_checkpoints
+		SET previous_version = CASE WHEN change_version <> $3 THEN change_version ELSE previous_version END,
+			change_version = $3,
+			updated_at = now()
+		WHERE aggregate_name = $1 AND change_version = $2`,
+		aggregateName, fromVersion, newVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update version for aggregate '%s': %w", aggregateName, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update version for aggregate '%s': %w", aggregateName, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf(
+			"checkpoint of aggregate '%s' is no longer at change version %d, it was changed during the cycle",
+			aggregateName, fromVersion,
+		)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit cycle for aggregate '%s': %w", aggregateName, err)
+	}
+	return nil
+}
+
+// QuarantineRow stores the row in the quarantine table
+func (r *PostgresRepository) QuarantineRow(ctx context.Context, row tracker.QuarantinedRow) error {
+	return quarantineRow(ctx, r.db, row)
+}
+
+func quarantineRow(ctx context.Context, db execer, row tracker.QuarantinedRow) error {
+	values, err := json.Marshal(row.Values)
+	if err != nil {
+		return fmt.Errorf("failed to marshal quarantined row of aggregate '%s': %w", row.Aggregate, err)
+	}
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO slx_quarantine (aggregate_name, change_version, row_values, error, quarantined_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, row.Aggregate, row.ChangeVersion, values, row.Error, row.QuarantinedAt)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine row of aggregate '%s': %w", row.Aggregate, err)
+	}
+	return nil
+}
+
+// ListQuarantined returns the quarantined rows of the aggregate ordered by ID, all rows when the
+// aggregate name is empty
+func (r *PostgresRepository) ListQuarantined(ctx context.Context, aggregateName string) ([]tracker.QuarantinedRow, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT id, aggregate_name, change_version, row_values, error, quarantined_at
+		FROM slx_quarantine
+		WHERE $1 = '' OR aggregate_name = $1
+		ORDER BY id
+	`, aggregateName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list quarantined rows: %w", err)
+	}
+	defer rows.Close()
+
+	var quarantined []tracker.QuarantinedRow
+	for rows.Next() {
+		var row tracker.QuarantinedRow
+		var values []byte
+		err := rows.Scan(&row.ID, &row.Aggregate, &row.ChangeVersion, &values, &row.Error, &row.QuarantinedAt)
+		if err != nil {
+			return nil, fmt.Errorf("row scan failed: %w", err)
+		}
+		decoder := json.NewDecoder(bytes.NewReader(values))
+		decoder.UseNumber()
+		if err := decoder.Decode(&row.Values); err != nil {
+			return nil, fmt.Errorf("failed to parse quarantined row %d: %w", row.ID, err)
+		}
+		quarantined = append(quarantined, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return quarantined, nil
+}
+
+// DeleteQuarantined removes a quarantined row
+func (r *PostgresRepository) DeleteQuarantined(ctx context.Context, id int64) error {
+	res, err := r.db.ExecContext(ctx, `DELETE FROM slx_quarantine WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete quarantined row %d: %w", id, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to delete quarantined row %d: %w", id, err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("quarantined row %d not found", id)
+	}
+	return nil
+}
+
+// Check reports whether the database can be reached
+func (r *PostgresRepository) Check(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// Close is a no-op, the connection pool is owned by the caller
+func (r *PostgresRepository) Close() error {
+	return nil
+}
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func updateCheckpoint(ctx context.Context, db execer, aggregateName string, newVersion int64) error {
+	res, err := db.ExecContext(
+		ctx,
+		`UPDATE slx_checkpoints
+		SET previous_version = CASE WHEN change_version <> $2 THEN change_version ELSE previous_version END,
+			change_version = $2,
+			updated_at = now()
+		WHERE aggregate_name = $1`,
+		aggregateName, newVersion,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update version for aggregate '%s': %w", aggregateName, err)
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to update version for aggregate '%s': %w", aggregateName, err)
+	}
+	if updated == 0 {
+		return fmt.Errorf("aggregate '%s' not found", aggregateName)
+	}
+	return nil
+}
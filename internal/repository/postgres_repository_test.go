@@ -0,0 +1,236 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/salesworks/s-works/slx/internal/tracker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPostgresRepository(t *testing.T) (*PostgresRepository, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS slx_checkpoints")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("ALTER TABLE slx_checkpoints")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS slx_checkpoint_history")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("CREATE TABLE IF NOT EXISTS slx_quarantine")).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	repo, err := NewPostgresRepository(context.Background(), db, logger)
+	require.NoError(t, err, "NewPostgresRepository should not return an error")
+	return repo, mock
+}
+
+func TestPostgresRepository_RegisterAggregates(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+	ctx := context.Background()
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO slx_checkpoints")).WithArgs("users").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT change_version FROM slx_checkpoints")).WithArgs("users").
+		WillReturnRows(sqlmock.NewRows([]string{"change_version"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO slx_checkpoints")).WithArgs("orders").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT change_version FROM slx_checkpoints")).WithArgs("orders").
+		WillReturnRows(sqlmock.NewRows([]string{"change_version"}).AddRow(10))
+
+	// --- Act ---
+	err := repo.RegisterAggregates(ctx, []string{"users", "orders"})
+
+	// --- Assert ---
+	require.NoError(t, err, "RegisterAggregates should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRepository_UpdateChangeVersion_NotFound(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE slx_checkpoints")).WithArgs("missing", int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// --- Act ---
+	err := repo.UpdateChangeVersion(context.Background(), "missing", 5)
+
+	// --- Assert ---
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "not found")
+}
+
+func TestPostgresRepository_CommitCycle(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+	envelopes := []*messaging.EventEnvelope{
+		messaging.NewEventEnvelope("erp.users.updated", "A", 7, `{}`),
+		messaging.NewEventEnvelope("erp.users.deleted", "B", 8, `{}`),
+	}
+
+	quarantined := []tracker.QuarantinedRow{{
+		Aggregate: "users", ChangeVersion: 8, Values: map[string]any{"id": 3}, Error: "invalid payload",
+		QuarantinedAt: time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC),
+	}}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO slx_quarantine")).
+		WithArgs("users", int64(8), []byte(`{"id":3}`), "invalid payload", quarantined[0].QuarantinedAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE slx_checkpoints")).WithArgs("users", int64(6), int64(8)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// --- Act ---
+	err := repo.CommitCycle(context.Background(), "users", envelopes, quarantined, 6, 8)
+
+	// --- Assert ---
+	require.NoError(t, err, "CommitCycle should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRepository_CommitCycle_RollsBackOnFailure(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+	envelopes := []*messaging.EventEnvelope{
+		messaging.NewEventEnvelope("erp.users.updated", "A", 7, `{}`),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events")).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	// --- Act ---
+	err := repo.CommitCycle(context.Background(), "users", envelopes, nil, 6, 7)
+
+	// --- Assert ---
+	require.Error(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet(), "the checkpoint must not be updated")
+}
+
+func TestPostgresRepository_CommitCycle_CheckpointChanged(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+	envelopes := []*messaging.EventEnvelope{
+		messaging.NewEventEnvelope("erp.users.updated", "A", 7, `{}`),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO events")).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("WHERE aggregate_name = $1 AND change_version = $2")).
+		WithArgs("users", int64(6), int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	// --- Act ---
+	err := repo.CommitCycle(context.Background(), "users", envelopes, nil, 6, 7)
+
+	// --- Assert ---
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no longer at change version 6")
+	assert.NoError(t, mock.ExpectationsWereMet(), "the events of the cycle must not be stored")
+}
+
+func TestPostgresRepository_RecordCycle(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cycle := tracker.CycleRecord{Start: start, End: start.Add(time.Second), FromVersion: 5, ToVersion: 9, RowsFetched: 4}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE slx_checkpoints")).
+		WithArgs("users", cycle.Start, cycle.End, 4, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO slx_checkpoint_history")).
+		WithArgs("users", cycle.Start, cycle.End, int64(5), int64(9), 4, 0, "").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM slx_checkpoint_history")).
+		WithArgs("users", maxCycleHistory).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	// --- Act ---
+	err := repo.RecordCycle(context.Background(), "users", cycle)
+
+	// --- Assert ---
+	require.NoError(t, err, "RecordCycle should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRepository_RecordCycle_EmptyCycleSkipsHistory(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+	start := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cycle := tracker.CycleRecord{Start: start, End: start.Add(time.Second), FromVersion: 9, ToVersion: 9}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE slx_checkpoints")).
+		WithArgs("users", cycle.Start, cycle.End, 0, "").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	// --- Act ---
+	err := repo.RecordCycle(context.Background(), "users", cycle)
+
+	// --- Assert ---
+	require.NoError(t, err, "RecordCycle should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet(), "a cycle without changes must not be added to the history")
+}
+
+func TestPostgresRepository_RenameAggregate(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT change_version FROM slx_checkpoints")).WithArgs("contractor").
+		WillReturnRows(sqlmock.NewRows([]string{"change_version"}).AddRow(12))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).WithArgs("customer").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE slx_checkpoints SET aggregate_name")).
+		WithArgs("contractor", "customer").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE slx_checkpoint_history SET aggregate_name")).
+		WithArgs("contractor", "customer").WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	// --- Act ---
+	err := repo.RenameAggregate(context.Background(), "contractor", "customer")
+
+	// --- Assert ---
+	require.NoError(t, err, "RenameAggregate should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRepository_RenameAggregate_TargetExists(t *testing.T) {
+	// --- Arrange ---
+	repo, mock := newTestPostgresRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT change_version FROM slx_checkpoints")).WithArgs("contractor").
+		WillReturnRows(sqlmock.NewRows([]string{"change_version"}).AddRow(12))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT EXISTS")).WithArgs("customer").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	// --- Act ---
+	err := repo.RenameAggregate(context.Background(), "contractor", "customer")
+
+	// --- Assert ---
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "already registered")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,37 @@
+package tracker
+
+import "time"
+
+// Checkpoint is the stored state of an aggregate together with the outcome of its last cycle
+type Checkpoint struct {
+	Aggregate       string    `json:"aggregate"`
+	ChangeVersion   int64     `json:"change_version"`
+	PreviousVersion int64     `json:"previous_version"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	LastRunStart    time.Time `json:"last_run_start"`
+	LastRunEnd      time.Time `json:"last_run_end"`
+	RowsFetched     int       `json:"rows_fetched"`
+	LastError       string    `json:"last_error,omitempty"`
+}
+
+// CycleRecord describes a single ERP cycle of an aggregate
+type CycleRecord struct {
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	FromVersion int64     `json:"from_version"`
+	ToVersion   int64     `json:"to_version"`
+	RowsFetched int       `json:"rows_fetched"`
+	RowsSkipped int       `json:"rows_skipped,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Advanced reports whether the cycle moved the change version forward
+func (c CycleRecord) Advanced() bool {
+	return c.ToVersion != c.FromVersion
+}
+
+// Noteworthy reports whether the cycle belongs in the history, cycles that found nothing are
+// only reflected in the checkpoint's last run
+func (c CycleRecord) Noteworthy() bool {
+	return c.Advanced() || c.Error != ""
+}
@@ -0,0 +1,111 @@
+package tracker
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// coalescedChange is the newest change of an aggregate key together with its row values
+type coalescedChange struct {
+	event  ChangeEvent
+	values []any
+}
+
+// coalescer merges the changes of a cycle per aggregate key, the keys keep the order of their
+// first change. The rows of a get query have no defined order, so the changes of a key are
+// merged in change version order once the cycle has read all of them.
+type coalescer struct {
+	keys  []string
+	byKey map[string][]coalescedChange
+}
+
+func newCoalescer() *coalescer {
+	return &coalescer{byKey: make(map[string][]coalescedChange)}
+}
+
+// add queues the change for the merge of its key
+func (c *coalescer) add(event ChangeEvent, values []any) {
+	key := event.AggregateKey
+	if _, ok := c.byKey[key]; !ok {
+		c.keys = append(c.keys, key)
+	}
+	c.byKey[key] = append(c.byKey[key], coalescedChange{event: event, values: values})
+}
+
+// changes returns the merged changes and the number of changes that will not be dispatched
+// because of the merge, a nil coalescer has none
+func (c *coalescer) changes() ([]*coalescedChange, int) {
+	if c == nil {
+		return nil, 0
+	}
+	merged := make([]*coalescedChange, 0, len(c.keys))
+	var coalesced int
+	for _, key := range c.keys {
+		changes := c.byKey[key]
+		// rows of the same version keep the order they were read in
+		slices.SortStableFunc(changes, func(a, b coalescedChange) int {
+			return cmp.Compare(a.event.ChangeVersion, b.event.ChangeVersion)
+		})
+		change := merge(changes)
+		if change == nil {
+			coalesced += len(changes)
+			continue
+		}
+		merged = append(merged, change)
+		coalesced += len(changes) - 1
+	}
+	return merged, coalesced
+}
+
+// merge folds the changes of a key, ordered by change version, into the newest one. It returns
+// nil when the aggregate was created and removed again.
+func merge(changes []coalescedChange) *coalescedChange {
+	var merged *coalescedChange
+	for _, change := range changes {
+		if merged == nil {
+			merged = &change
+			continue
+		}
+		switch operation := merged.event.ChangeOperation; {
+		case isInsert(operation) && isDelete(change.event.ChangeOperation):
+			// the aggregate was created and removed within the cycle, consumers never saw it
+			merged = nil
+			continue
+		case isInsert(operation):
+			change.event.ChangeOperation = operation
+		case isDelete(operation) && !isDelete(change.event.ChangeOperation):
+			// the aggregate existed before the cycle, so it was recreated rather than inserted
+			change.event.ChangeOperation = updateOperation(change.event.ChangeOperation)
+		}
+		merged = &change
+	}
+	return merged
+}
+
+// isInsert reports whether the change operation creates the aggregate
+func isInsert(operation string) bool {
+	switch strings.ToLower(operation) {
+	case "i", "insert", "inserted":
+		return true
+	}
+	return false
+}
+
+// updateOperation returns the update operation in the wording of the given operation, e.g.
+// updated for inserted
+func updateOperation(operation string) string {
+	var update string
+	switch strings.ToLower(operation) {
+	case "i", "d", "u":
+		return "U"
+	case "insert", "delete", "update":
+		update = "update"
+	default:
+		update = "updated"
+	}
+	if operation == strings.ToUpper(operation) {
+		return strings.ToUpper(update)
+	}
+	return update
+}
@@ -0,0 +1,124 @@
+package tracker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCoalescer(t *testing.T) {
+	tests := []struct {
+		name        string
+		operations  []string
+		want        []string
+		wantDropped int
+	}{
+		{name: "single change", operations: []string{"updated"}, want: []string{"updated"}},
+		{name: "updates keep the newest", operations: []string{"U", "U", "U"}, want: []string{"U"}, wantDropped: 2},
+		{name: "insert then update", operations: []string{"inserted", "updated"}, want: []string{"inserted"}, wantDropped: 1},
+		{name: "insert then delete", operations: []string{"I", "U", "D"}, want: []string{}, wantDropped: 3},
+		{name: "insert after insert and delete", operations: []string{"I", "D", "I"}, want: []string{"I"}, wantDropped: 2},
+		{name: "update then delete", operations: []string{"updated", "deleted"}, want: []string{"deleted"}, wantDropped: 1},
+		{name: "delete then insert", operations: []string{"DELETED", "INSERTED"}, want: []string{"UPDATED"}, wantDropped: 1},
+		{name: "delete then insert short", operations: []string{"D", "I"}, want: []string{"U"}, wantDropped: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			c := newCoalescer()
+			for i, operation := range tt.operations {
+				event := ChangeEvent{ChangeOperation: operation, ChangeVersion: int64(i + 1), AggregateKey: "A"}
+				c.add(event, nil)
+			}
+
+			// --- Act ---
+			changes, dropped := c.changes()
+
+			// --- Assert ---
+			operations := []string{}
+			for _, change := range changes {
+				operations = append(operations, change.event.ChangeOperation)
+				assert.Equal(t, int64(len(tt.operations)), change.event.ChangeVersion, "the newest change should be kept")
+			}
+			assert.Equal(t, tt.want, operations)
+			assert.Equal(t, tt.wantDropped, dropped)
+		})
+	}
+}
+
+func TestCoalescer_KeepsKeyOrder(t *testing.T) {
+	// --- Arrange ---
+	c := newCoalescer()
+	for _, key := range []string{"A", "B", "A", "C", "B"} {
+		c.add(ChangeEvent{ChangeOperation: "U", AggregateKey: key}, nil)
+	}
+
+	// --- Act ---
+	changes, _ := c.changes()
+	none, dropped := (*coalescer)(nil).changes()
+
+	// --- Assert ---
+	var keys []string
+	for _, change := range changes {
+		keys = append(keys, change.event.AggregateKey)
+	}
+	assert.Equal(t, []string{"A", "B", "C"}, keys)
+	assert.Nil(t, none, "a nil coalescer should have no changes")
+	assert.Zero(t, dropped)
+}
+
+func TestCoalescer_MergesByVersion(t *testing.T) {
+	tests := []struct {
+		name        string
+		changes     []ChangeEvent
+		want        []ChangeEvent
+		wantDropped int
+	}{
+		{
+			name:    "newest update read first",
+			changes: []ChangeEvent{{ChangeOperation: "U", ChangeVersion: 9}, {ChangeOperation: "U", ChangeVersion: 4}},
+			want:    []ChangeEvent{{ChangeOperation: "U", ChangeVersion: 9}}, wantDropped: 1,
+		},
+		{
+			name:    "delete read before insert",
+			changes: []ChangeEvent{{ChangeOperation: "D", ChangeVersion: 7}, {ChangeOperation: "I", ChangeVersion: 5}},
+			want:    []ChangeEvent{}, wantDropped: 2,
+		},
+		{
+			name:    "update read before insert",
+			changes: []ChangeEvent{{ChangeOperation: "U", ChangeVersion: 8}, {ChangeOperation: "I", ChangeVersion: 3}},
+			want:    []ChangeEvent{{ChangeOperation: "I", ChangeVersion: 8}}, wantDropped: 1,
+		},
+		{
+			name: "recreate read before delete",
+			changes: []ChangeEvent{
+				{ChangeOperation: "inserted", ChangeVersion: 6}, {ChangeOperation: "deleted", ChangeVersion: 2},
+			},
+			want: []ChangeEvent{{ChangeOperation: "updated", ChangeVersion: 6}}, wantDropped: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			c := newCoalescer()
+			for _, event := range tt.changes {
+				event.AggregateKey = "A"
+				c.add(event, []any{event.ChangeVersion})
+			}
+
+			// --- Act ---
+			changes, dropped := c.changes()
+
+			// --- Assert ---
+			got := []ChangeEvent{}
+			for _, change := range changes {
+				assert.Equal(t, []any{change.event.ChangeVersion}, change.values, "the values of the newest row should be kept")
+				got = append(got, ChangeEvent{ChangeOperation: change.event.ChangeOperation, ChangeVersion: change.event.ChangeVersion})
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, tt.wantDropped, dropped)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package tracker
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// relevantColumn is the flag the column filter adds to the rows of the get query
+const relevantColumn = "slx_relevant"
+
+var (
+	columnNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	tableNamePattern  = regexp.MustCompile(`^[A-Za-z0-9_.\[\]]+$`)
+)
+
+// validateColumns checks the relevant columns of the aggregate, they are compared against the
+// change mask of its only tracked table
+func (a Aggregate) validateColumns() error {
+	if len(a.Columns) == 0 {
+		return nil
+	}
+	tables := a.TrackedTables()
+	if len(tables) != 1 {
+		return fmt.Errorf("aggregate '%s' filters columns of %d tables, set exactly one in tables", a.Name, len(tables))
+	}
+	if !tableNamePattern.MatchString(tables[0]) {
+		return fmt.Errorf("aggregate '%s' has invalid table name '%s'", a.Name, tables[0])
+	}
+	for _, column := range a.Columns {
+		if !columnNamePattern.MatchString(column) {
+			return fmt.Errorf("aggregate '%s' has invalid column name '%s'", a.Name, column)
+		}
+	}
+	return nil
+}
+
+// filterColumns wraps the query so every row is flagged whether its change_columns mask holds
+// one of the relevant columns. Rows without a mask, i.e. inserts, deletes and updates of a
+// table without column tracking, are always relevant.
+func (a Aggregate) filterColumns(query string) string {
+	if len(a.Columns) == 0 {
+		return query
+	}
+
+	table := a.TrackedTables()[0]
+	conditions := make([]string, len(a.Columns))
+	for i, column := range a.Columns {
+		conditions[i] = fmt.Sprintf(
+			"CHANGE_TRACKING_IS_COLUMN_IN_MASK(COLUMNPROPERTY(OBJECT_ID('%s'), '%s', 'ColumnId'), q.change_columns) = 1",
+			table, column,
+		)
+	}
+	return fmt.Sprintf(`SELECT q.*,
+	CASE WHEN q.change_columns IS NULL OR %s THEN 1 ELSE 0 END AS %s
+FROM (
+%s
+) AS q`, strings.Join(conditions, "\n\t\tOR "), relevantColumn, strings.TrimRight(strings.TrimSpace(query), ";"))
+}
@@ -0,0 +1,62 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/salesworks/s-works/slx/internal/filter"
+	"github.com/salesworks/s-works/slx/internal/messaging"
+)
+
+// compileFilter compiles the filter expression of the aggregate
+func (a *Aggregate) compileFilter() error {
+	if a.Filter == "" {
+		return nil
+	}
+	compiled, err := filter.Compile(a.Filter)
+	if err != nil {
+		return fmt.Errorf("aggregate '%s' has an invalid filter: %w", a.Name, err)
+	}
+	a.filter = compiled
+	return nil
+}
+
+// matchFilter reports whether the event passes the filter of the aggregate. The expression sees
+// the payload, the change_operation and the envelope. The payload, key and metadata of the
+// envelope are replaced with those of the event, so the filter sees values before protection.
+func (a Aggregate) matchFilter(event ChangeEvent, envelope *messaging.EventEnvelope) (bool, error) {
+	if a.filter == nil {
+		return true, nil
+	}
+
+	var payload any
+	if !event.NullPayload {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(event.Payload)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&payload); err != nil {
+			return false, fmt.Errorf("failed to decode payload for the filter: %w", err)
+		}
+	}
+
+	plain := *envelope
+	plain.AggregateKey = event.AggregateKey
+	plain.Metadata = envelopeMetadata(event)
+	encoded, err := json.Marshal(plain)
+	if err != nil {
+		return false, fmt.Errorf("failed to encode envelope for the filter: %w", err)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var fields map[string]any
+	if err := decoder.Decode(&fields); err != nil {
+		return false, fmt.Errorf("failed to decode envelope for the filter: %w", err)
+	}
+	fields["payload"] = payload
+
+	return a.filter.Match(map[string]any{
+		"payload":          payload,
+		"change_operation": event.ChangeOperation,
+		"envelope":         fields,
+	}), nil
+}
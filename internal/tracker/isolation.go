@@ -0,0 +1,92 @@
+package tracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+const (
+	// IsolationSnapshot reads every cycle from one snapshot, it is the default isolation
+	IsolationSnapshot = "snapshot"
+	// IsolationNone runs the queries of a cycle without a transaction
+	IsolationNone = "none"
+)
+
+// isolationLevels are the isolation levels an aggregate can configure
+var isolationLevels = map[string]sql.IsolationLevel{
+	"read_uncommitted": sql.LevelReadUncommitted,
+	"read_committed":   sql.LevelReadCommitted,
+	"repeatable_read":  sql.LevelRepeatableRead,
+	IsolationSnapshot:  sql.LevelSnapshot,
+	"serializable":     sql.LevelSerializable,
+}
+
+// queryer is implemented by *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// validateIsolation checks the isolation of the aggregate, an empty isolation is set to snapshot
+func (a *Aggregate) validateIsolation() error {
+	if a.Isolation == "" {
+		a.Isolation = IsolationSnapshot
+	}
+	if _, ok := isolationLevels[a.Isolation]; !ok && a.Isolation != IsolationNone {
+		return fmt.Errorf("aggregate '%s' has unknown isolation '%s'", a.Name, a.Isolation)
+	}
+	return nil
+}
+
+// beginRead starts the transaction the queries of a cycle run in. Without an isolation the
+// queries run on the pool. The returned function ends the transaction, it is rolled back as
+// the cycle only reads.
+func (t *Tracker) beginRead(ctx context.Context, agg Aggregate) (queryer, func(), error) {
+	level, ok := isolationLevels[agg.Isolation]
+	if !ok {
+		return t.db, func() {}, nil
+	}
+	tx, err := t.db.BeginTx(ctx, &sql.TxOptions{Isolation: level})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin %s transaction: %w", agg.Isolation, err)
+	}
+	return tx, func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			t.logger.Warn("failed to end read transaction", "aggregate", agg.Name, "error", err)
+		}
+	}, nil
+}
+
+// CheckSnapshotIsolation returns an error when an aggregate reads with snapshot isolation and
+// ALLOW_SNAPSHOT_ISOLATION is off for the database
+func (t *Tracker) CheckSnapshotIsolation(ctx context.Context) error {
+	var names []string
+	for _, agg := range t.aggregates {
+		if agg.Isolation == IsolationSnapshot {
+			names = append(names, agg.Name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	var database string
+	var state int
+	err := t.db.QueryRowContext(
+		ctx, "SELECT name, snapshot_isolation_state FROM sys.databases WHERE database_id = DB_ID()",
+	).Scan(&database, &state)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot isolation state: %w", err)
+	}
+	// 1 is ON, 3 is IN_TRANSITION_TO_ON which still rejects snapshot transactions
+	if state != 1 {
+		return fmt.Errorf(
+			"snapshot isolation is not allowed in database '%s', run ALTER DATABASE [%s] SET ALLOW_SNAPSHOT_ISOLATION ON "+
+				"or configure another isolation for aggregates %s",
+			database, database, strings.Join(names, ", "),
+		)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package tracker
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// KeyRaw uses the natural key as aggregate key, it is the default
+	KeyRaw = "raw"
+	// KeyMD5 uses the uppercase hex MD5 of the natural key, for a single column it equals the
+	// CONVERT(VARCHAR(32), HASHBYTES('MD5', CAST(x AS VARCHAR(40))), 2) of the queries
+	KeyMD5 = "md5"
+	// KeySHA256 uses the uppercase hex SHA-256 of the natural key
+	KeySHA256 = "sha256"
+	// KeyUUIDv5 uses the name based UUID of the natural key in the key namespace
+	KeyUUIDv5 = "uuidv5"
+)
+
+// naturalKeySeparator joins the values of a composite natural key
+const naturalKeySeparator = ":"
+
+// naturalKeyMetadata is the envelope metadata field holding the natural key columns
+const naturalKeyMetadata = "natural_key"
+
+// Key builds the aggregate key from key columns of the get query instead of its aggregate_key
+// column
+type Key struct {
+	// Columns are the natural key columns, e.g. [trn_gidtyp, trn_gidnumer]. Their values are
+	// joined with ':' and kept in the natural_key metadata of the envelope.
+	Columns []string `yaml:"columns"`
+	// Strategy derives the aggregate key from the natural key: raw, md5, sha256 or uuidv5
+	Strategy string `yaml:"strategy"`
+	// Namespace is the UUID namespace of the uuidv5 strategy, the OID namespace when not set
+	Namespace string `yaml:"namespace"`
+	// namespace is the parsed Namespace
+	namespace uuid.UUID
+}
+
+// validateKey checks the key of the aggregate and parses its namespace
+func (a *Aggregate) validateKey() error {
+	if a.Key == nil {
+		return nil
+	}
+	if len(a.Key.Columns) == 0 {
+		return fmt.Errorf("aggregate '%s' has a key without columns", a.Name)
+	}
+	for i, column := range a.Key.Columns {
+		if column == "" || slices.Contains(a.Key.Columns[i+1:], column) {
+			return fmt.Errorf("aggregate '%s' has an empty or repeated key column '%s'", a.Name, column)
+		}
+		if slices.Contains(requiredColumns, column) || slices.Contains(defaultColumns, column) {
+			return fmt.Errorf("aggregate '%s' cannot use '%s' as key column", a.Name, column)
+		}
+	}
+
+	switch a.Key.Strategy {
+	case "", KeyRaw, KeyMD5, KeySHA256:
+		if a.Key.Namespace != "" {
+			return fmt.Errorf("aggregate '%s' has a key namespace, it is only used by uuidv5", a.Name)
+		}
+	case KeyUUIDv5:
+		a.Key.namespace = uuid.NameSpaceOID
+		if a.Key.Namespace != "" {
+			namespace, err := uuid.Parse(a.Key.Namespace)
+			if err != nil {
+				return fmt.Errorf("aggregate '%s' has an invalid key namespace: %w", a.Name, err)
+			}
+			a.Key.namespace = namespace
+		}
+	default:
+		return fmt.Errorf(
+			"aggregate '%s' has unknown key strategy '%s', use raw, md5, sha256 or uuidv5",
+			a.Name, a.Key.Strategy,
+		)
+	}
+	return nil
+}
+
+// derive builds the aggregate key from the natural key
+func (k *Key) derive(natural string) string {
+	switch k.Strategy {
+	case KeyMD5:
+		sum := md5.Sum([]byte(natural))
+		return strings.ToUpper(hex.EncodeToString(sum[:]))
+	case KeySHA256:
+		sum := sha256.Sum256([]byte(natural))
+		return strings.ToUpper(hex.EncodeToString(sum[:]))
+	case KeyUUIDv5:
+		return uuid.NewSHA1(k.namespace, []byte(natural)).String()
+	default:
+		return natural
+	}
+}
+
+// keyValue formats the value of a key column. Text and integers are formatted like
+// CAST(x AS VARCHAR) does, decimals the driver returns as text keep their scale. Other values
+// have a fixed format that does not change when a row is quarantined and its values are JSON
+// encoded: times are RFC 3339 with nanoseconds as json.Marshal writes them and floats are
+// decimals without exponent.
+func keyValue(value any) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", fmt.Errorf("key column is NULL")
+	case string:
+		return v, nil
+	case []byte:
+		return textValue(v).(string), nil
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int32:
+		return strconv.FormatInt(int64(v), 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case float32:
+		return strconv.FormatFloat(float64(v), 'f', -1, 32), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return strconv.FormatInt(i, 10), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return "", fmt.Errorf("invalid number '%s'", v)
+		}
+		return strconv.FormatFloat(f, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("key column has unsupported type %T", value)
+}
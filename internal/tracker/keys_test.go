@@ -0,0 +1,217 @@
+package tracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKey_Derive(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     Key
+		natural string
+		want    string
+	}{
+		{name: "raw", key: Key{Strategy: KeyRaw}, natural: "2033:12345", want: "2033:12345"},
+		{name: "default is raw", key: Key{}, natural: "12345", want: "12345"},
+		{name: "md5 equals the query key", key: Key{Strategy: KeyMD5}, natural: "12345", want: "827CCB0EEA8A706C4C34A16891F84E7B"},
+		{name: "sha256", key: Key{Strategy: KeySHA256}, natural: "2033:12345", want: "88417E2F41C72C47191259279EDC4793A79BA3FCF88519743AF7FB1FA5097A0E"},
+		{name: "uuidv5 in the OID namespace", key: Key{Strategy: KeyUUIDv5}, natural: "2033:12345", want: "7e0c968d-bb44-5dd5-8295-580c782c23e7"},
+		{name: "uuidv5 in a namespace", key: Key{Strategy: KeyUUIDv5, Namespace: "6ba7b811-9dad-11d1-80b4-00c04fd430c8"}, natural: "12345", want: "a82b9066-2032-5bba-8bf9-8e865b09025c"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			key := tt.key
+			key.Columns = []string{"gidnumer"}
+			agg := Aggregate{Name: "invoice", Key: &key}
+			require.NoError(t, agg.validateKey())
+
+			// --- Act ---
+			got := agg.Key.derive(tt.natural)
+
+			// --- Assert ---
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAggregate_ValidateKey(t *testing.T) {
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{name: "no columns", key: Key{Strategy: KeyMD5}},
+		{name: "repeated column", key: Key{Columns: []string{"gidnumer", "gidnumer"}}},
+		{name: "reserved column", key: Key{Columns: []string{"aggregate_key"}}},
+		{name: "unknown strategy", key: Key{Columns: []string{"gidnumer"}, Strategy: "crc32"}},
+		{name: "invalid namespace", key: Key{Columns: []string{"gidnumer"}, Strategy: KeyUUIDv5, Namespace: "invoices"}},
+		{name: "namespace without uuidv5", key: Key{Columns: []string{"gidnumer"}, Strategy: KeyMD5, Namespace: "6ba7b811-9dad-11d1-80b4-00c04fd430c8"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			err := (&Aggregate{Name: "invoice", Key: &tt.key}).validateKey()
+
+			// --- Assert ---
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadAggregates_Key(t *testing.T) {
+	// --- Arrange ---
+	// the stock aggregate of config.yaml with its key derived in Go instead of the query
+	testFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(testFile, []byte(`aggregates:
+  - name: "stock"
+    key:
+      columns: [twr_gidnumer]
+      strategy: md5
+    get_query: |
+      SELECT 'updated' AS change_operation, @version + 1 AS change_version,
+        t.Twr_GidNumer AS twr_gidnumer, '{}' AS payload
+      FROM CDN.TwrKarty t
+`), 0644))
+
+	aggregates, err := LoadAggregates(testFile)
+	require.NoError(t, err)
+	mapper, err := newRowMapper(
+		[]string{"change_operation", "change_version", "twr_gidnumer", "payload"}, nil, aggregates[0].Key,
+	)
+	require.NoError(t, err)
+
+	// --- Act ---
+	event, _, err := mapper.event([]any{"updated", int64(1), int64(12345), `{}`})
+
+	// --- Assert ---
+	require.NoError(t, err)
+	// CONVERT(VARCHAR(32), HASHBYTES('MD5', CAST(12345 AS VARCHAR(40))), 2) of the query it replaces
+	assert.Equal(t, "827CCB0EEA8A706C4C34A16891F84E7B", event.AggregateKey)
+}
+
+func TestRowMapper_DerivedKey(t *testing.T) {
+	// --- Arrange ---
+	key := &Key{Columns: []string{"trn_gidtyp", "trn_gidnumer"}, Strategy: KeySHA256}
+	columns := []string{"change_operation", "change_version", "trn_gidtyp", "trn_gidnumer", "payload"}
+	mapper, err := newRowMapper(columns, nil, key)
+	require.NoError(t, err)
+	_, withAggregateKey := newRowMapper(append(columns, "aggregate_key"), nil, key)
+	_, withoutKeyColumn := newRowMapper([]string{"change_version", "trn_gidnumer"}, nil, key)
+
+	// --- Act ---
+	event, _, eventErr := mapper.event([]any{"updated", int64(7), int64(2033), []byte("12345"), `{}`})
+	_, _, nullErr := mapper.event([]any{"updated", int64(7), int64(2033), nil, `{}`})
+
+	// --- Assert ---
+	require.NoError(t, eventErr)
+	assert.Equal(t, "88417E2F41C72C47191259279EDC4793A79BA3FCF88519743AF7FB1FA5097A0E", event.AggregateKey)
+	assert.Equal(t, map[string]any{"trn_gidtyp": int64(2033), "trn_gidnumer": "12345"}, event.Metadata["natural_key"])
+	assert.ErrorContains(t, withAggregateKey, "although the key is derived")
+	assert.ErrorContains(t, withoutKeyColumn, "'trn_gidtyp'")
+	assert.ErrorContains(t, nullErr, "'trn_gidnumer'")
+}
+
+func TestQuarantinedJobs_DerivedKey(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{Name: "invoice", Key: &Key{Columns: []string{"trn_gidnumer"}, Strategy: KeyMD5}}
+	require.NoError(t, agg.validateKey())
+	row := QuarantinedRow{
+		ID:        1,
+		Aggregate: "invoice",
+		Values: map[string]any{
+			"change_operation": "updated",
+			"change_version":   json.Number("7"),
+			"trn_gidnumer":     json.Number("12345"),
+			"payload":          `{}`,
+		},
+	}
+
+	// --- Act ---
+	jobs, err := QuarantinedJobs(context.Background(), agg, row, "")
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "827CCB0EEA8A706C4C34A16891F84E7B", jobs[0].EventEnvelope.AggregateKey)
+	assert.Equal(t, map[string]any{"trn_gidnumer": json.Number("12345")}, jobs[0].EventEnvelope.Metadata["natural_key"])
+}
+
+func TestQuarantinedJobs_DerivedKeyRoundTrip(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{
+		Name: "price",
+		Key:  &Key{Columns: []string{"tpr_od", "tpr_cena", "tpr_twrnumer", "tpr_kod"}, Strategy: KeySHA256},
+	}
+	require.NoError(t, agg.validateKey())
+	columns := []string{"change_operation", "change_version", "payload", "tpr_cena", "tpr_kod", "tpr_od", "tpr_twrnumer"}
+	values := []any{
+		"updated", int64(7), `{}`, 1e21, []byte("12.50"),
+		time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC), int64(12345),
+	}
+	mapper, err := newRowMapper(columns, nil, agg.Key)
+	require.NoError(t, err)
+	live, _, err := mapper.event(values)
+	require.NoError(t, err)
+
+	// quarantined values are stored as JSON and read with json.Number
+	stored, err := json.Marshal(mapper.values(values))
+	require.NoError(t, err)
+	row := QuarantinedRow{ID: 1, Aggregate: "price"}
+	decoder := json.NewDecoder(bytes.NewReader(stored))
+	decoder.UseNumber()
+	require.NoError(t, decoder.Decode(&row.Values))
+
+	// --- Act ---
+	jobs, err := QuarantinedJobs(context.Background(), agg, row, "")
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, live.AggregateKey, jobs[0].EventEnvelope.AggregateKey)
+	assert.Equal(t, agg.Key.derive("2024-03-01T12:30:00.0000005Z:1000000000000000000000:12345:12.50"), live.AggregateKey)
+}
+
+func TestKeyValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   any
+		want    string
+		wantErr string
+	}{
+		{name: "text", value: "FS-1/24", want: "FS-1/24"},
+		{name: "integer", value: int64(-16), want: "-16"},
+		{name: "json integer", value: json.Number("9007199254740993"), want: "9007199254740993"},
+		{name: "float", value: 0.1, want: "0.1"},
+		{name: "json float with exponent", value: json.Number("1e+21"), want: "1000000000000000000000"},
+		{name: "binary", value: []byte{0xff, 0x01}, want: "ff01"},
+		{name: "time", value: time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC), want: "2024-03-01T12:30:00Z"},
+		{name: "bool", value: true, want: "true"},
+		{name: "null", value: nil, wantErr: "NULL"},
+		{name: "unsupported", value: []int{1}, wantErr: "unsupported type"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			got, err := keyValue(tt.value)
+
+			// --- Assert ---
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package tracker
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/salesworks/s-works/slx/internal/metrics"
+)
+
+// changeTablePattern finds the tables read through CHANGETABLE(CHANGES <table>, ...)
+var changeTablePattern = regexp.MustCompile(`(?i)CHANGETABLE\s*\(\s*CHANGES\s+([^\s,()]+)\s*,`)
+
+// Lag describes how far the checkpoint of an aggregate is behind the database
+type Lag struct {
+	CurrentVersion  int64     `json:"current_version"`
+	MinValidVersion int64     `json:"min_valid_version"`
+	Versions        int64     `json:"versions"`
+	AtRisk          bool      `json:"at_risk"`
+	Expired         bool      `json:"expired"`
+	CheckedAt       time.Time `json:"checked_at"`
+	Error           string    `json:"error,omitempty"`
+}
+
+// WithLagMonitor checks the change tracking lag of every aggregate on the interval. An
+// aggregate is at risk when its checkpoint lies in the oldest warnRatio of the retained
+// versions, i.e. close to being cleaned up by the change tracking retention.
+func WithLagMonitor(interval time.Duration, warnRatio float64) Option {
+	return func(t *Tracker) {
+		t.lagInterval = interval
+		t.lagWarnRatio = warnRatio
+	}
+}
+
+// TrackedTables returns the configured tracked tables or the tables read through CHANGETABLE
+// in the get query
+func (a Aggregate) TrackedTables() []string {
+	if len(a.Tables) > 0 {
+		return a.Tables
+	}
+
+	var tables []string
+	for _, match := range changeTablePattern.FindAllStringSubmatch(a.GetQuery, -1) {
+		if table := match[1]; !containsFold(tables, table) {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// startLagMonitor runs the lag check on the configured interval until the context is done
+func (t *Tracker) startLagMonitor(ctx context.Context) {
+	if t.lagInterval <= 0 {
+		return
+	}
+
+	aggregates := t.lagAggregates()
+	go func() {
+		t.logger.Info("starting change tracking lag monitor", "interval", t.lagInterval)
+		ticker := time.NewTicker(t.lagInterval)
+		defer ticker.Stop()
+
+		t.checkLag(ctx, aggregates)
+		for {
+			select {
+			case <-ctx.Done():
+				t.logger.Info("stopping change tracking lag monitor", "reason", ctx.Err())
+				return
+			case <-ticker.C:
+				t.checkLag(ctx, aggregates)
+			}
+		}
+	}()
+}
+
+// lagAggregates returns the aggregates with tracked tables, the others are logged once and
+// left out of the lag checks
+func (t *Tracker) lagAggregates() []Aggregate {
+	aggregates := make([]Aggregate, 0, len(t.aggregates))
+	for _, agg := range t.aggregates {
+		if len(agg.TrackedTables()) == 0 {
+			t.logger.Warn("no tracked tables found, set tables in the aggregate configuration to check its lag",
+				"aggregate", agg.Name)
+			continue
+		}
+		aggregates = append(aggregates, agg)
+	}
+	return aggregates
+}
+
+// checkLag computes the lag of the aggregates against the current database version
+func (t *Tracker) checkLag(ctx context.Context, aggregates []Aggregate) {
+	current, err := currentVersion(ctx, t.db)
+	if err != nil {
+		t.logger.Error("failed to read change tracking version", "error", err)
+		return
+	}
+	metrics.CurrentVersion.Set(float64(current))
+
+	minValid := make(map[string]int64)
+	for _, agg := range aggregates {
+		lag, version := t.aggregateLag(ctx, agg, current, minValid)
+		t.lags.Store(agg.Name, lag)
+		metrics.LagVersions.WithLabelValues(agg.Name).Set(float64(lag.Versions))
+
+		switch {
+		case lag.Error != "":
+			t.logger.Warn("failed to compute change tracking lag", "aggregate", agg.Name, "error", lag.Error)
+		case lag.Expired:
+			t.logger.Error(
+				"checkpoint is older than the change tracking retention, changes were lost",
+				"aggregate", agg.Name, "change_version", version, "min_valid_version", lag.MinValidVersion,
+			)
+		case lag.AtRisk:
+			t.logger.Warn(
+				"checkpoint is close to the change tracking retention",
+				"aggregate", agg.Name, "change_version", version, "min_valid_version", lag.MinValidVersion,
+				"current_version", current,
+			)
+		}
+	}
+}
+
+// aggregateLag computes the lag of one aggregate and returns it with the checkpoint version,
+// minValid caches the minimum valid version of tables shared between aggregates
+func (t *Tracker) aggregateLag(
+	ctx context.Context, agg Aggregate, current int64, minValid map[string]int64,
+) (Lag, int64) {
+	lag := Lag{CurrentVersion: current, CheckedAt: time.Now()}
+
+	version, err := t.repository.GetChangeVersion(ctx, agg.Name)
+	if err != nil {
+		lag.Error = err.Error()
+		return lag, 0
+	}
+	lag.Versions = max(current-version, 0)
+
+	// the retention window ends at the newest minimum valid version of the tables read
+	for _, table := range agg.TrackedTables() {
+		key := strings.ToLower(table)
+		tableMin, ok := minValid[key]
+		if !ok {
+			tableMin, err = minValidVersion(ctx, t.db, table)
+			if err != nil {
+				lag.Error = err.Error()
+				return lag, version
+			}
+			minValid[key] = tableMin
+		}
+		lag.MinValidVersion = max(lag.MinValidVersion, tableMin)
+	}
+
+	lag.Expired = version < lag.MinValidVersion
+	window := current - lag.MinValidVersion
+	lag.AtRisk = lag.Expired || float64(version-lag.MinValidVersion) < float64(window)*t.lagWarnRatio
+	metrics.RetentionHeadroom.WithLabelValues(agg.Name).Set(float64(version - lag.MinValidVersion))
+	return lag, version
+}
+
+// currentVersion returns CHANGE_TRACKING_CURRENT_VERSION() of the database
+func currentVersion(ctx context.Context, db queryer) (int64, error) {
+	var version sql.NullInt64
+	if err := db.QueryRowContext(ctx, "SELECT CHANGE_TRACKING_CURRENT_VERSION()").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read current version: %w", err)
+	}
+	if !version.Valid {
+		return 0, fmt.Errorf("change tracking is not enabled for the database")
+	}
+	return version.Int64, nil
+}
+
+// minValidVersion returns CHANGE_TRACKING_MIN_VALID_VERSION of the table
+func minValidVersion(ctx context.Context, db *sql.DB, table string) (int64, error) {
+	var version sql.NullInt64
+	err := db.QueryRowContext(
+		ctx, "SELECT CHANGE_TRACKING_MIN_VALID_VERSION(OBJECT_ID(@table))", sql.Named("table", table),
+	).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read min valid version of '%s': %w", table, err)
+	}
+	if !version.Valid {
+		return 0, fmt.Errorf("change tracking is not enabled for table '%s'", table)
+	}
+	return version.Int64, nil
+}
+
+// lag returns the last computed lag of the aggregate
+func (t *Tracker) lag(aggregateName string) *Lag {
+	value, ok := t.lags.Load(aggregateName)
+	if !ok {
+		return nil
+	}
+	lag := value.(Lag)
+	return &lag
+}
+
+func containsFold(items []string, item string) bool {
+	for _, i := range items {
+		if strings.EqualFold(i, item) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,225 @@
+package tracker
+
+import (
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultColumns are the optional columns an aggregate can set defaults for
+var defaultColumns = []string{"change_operation", "change_context", "user_id", "correlation_id"}
+
+// changeContextMetadata is the envelope metadata field holding the change context
+const changeContextMetadata = "change_context"
+
+// requiredColumns are the columns every get query has to return
+var requiredColumns = []string{"change_version", "aggregate_key"}
+
+// validateDefaults checks that the aggregate only sets defaults for optional columns
+func (a Aggregate) validateDefaults() error {
+	for column := range a.Defaults {
+		if !slices.Contains(defaultColumns, column) {
+			return fmt.Errorf(
+				"aggregate '%s' has a default for '%s', defaults can be set for %s",
+				a.Name, column, strings.Join(defaultColumns, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+// rowMapper maps the result columns of a get query to ChangeEvent fields by name, columns
+// without a field are passed on as metadata. With a key the aggregate key is derived from the
+// key columns.
+type rowMapper struct {
+	columns  []string
+	defaults map[string]string
+	key      *Key
+}
+
+func newRowMapper(columns []string, defaults map[string]string, key *Key) (*rowMapper, error) {
+	required := requiredColumns
+	if key != nil {
+		if slices.Contains(columns, "aggregate_key") {
+			return nil, fmt.Errorf("query returns the 'aggregate_key' column although the key is derived")
+		}
+		required = append([]string{"change_version"}, key.Columns...)
+	}
+	for _, column := range required {
+		if !slices.Contains(columns, column) {
+			return nil, fmt.Errorf("query does not return the '%s' column", column)
+		}
+	}
+	for i, column := range columns {
+		if slices.Contains(columns[i+1:], column) {
+			return nil, fmt.Errorf("query returns the '%s' column more than once", column)
+		}
+	}
+	return &rowMapper{columns: columns, defaults: defaults, key: key}, nil
+}
+
+// read scans the current row into one value per column
+func (m *rowMapper) read(rows *sql.Rows) ([]any, error) {
+	values := make([]any, len(m.columns))
+	dest := make([]any, len(values))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("row scan failed: %w", err)
+	}
+	return values, nil
+}
+
+// values returns the values by column name, binary values are converted to text and hex
+// encoded when they are not valid text
+func (m *rowMapper) values(values []any) map[string]any {
+	named := make(map[string]any, len(values))
+	for i, column := range m.columns {
+		named[column] = textValue(values[i])
+	}
+	return named
+}
+
+// textValue converts bytes to text, bytes that are not UTF-8 are hex encoded
+func textValue(value any) any {
+	b, ok := value.([]byte)
+	if !ok {
+		return value
+	}
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+// event maps the values of a row to a change event, relevant is false when the column filter
+// found none of the relevant columns in the change mask
+func (m *rowMapper) event(values []any) (event ChangeEvent, relevant bool, err error) {
+	event.ChangeOperation = m.defaults["change_operation"]
+	event.ChangeContext = m.defaults["change_context"]
+	event.UserID = m.defaults["user_id"]
+	event.CorrelationID = m.defaults["correlation_id"]
+	event.NullPayload = true
+	relevant = true
+	var natural map[string]any
+
+	for i, column := range m.columns {
+		value := values[i]
+		if m.key != nil && slices.Contains(m.key.Columns, column) {
+			// key values are read like values of quarantined rows, so both derive the same key
+			value = textValue(value)
+			if natural == nil {
+				natural = make(map[string]any, len(m.key.Columns))
+			}
+			natural[column] = value
+			continue
+		}
+		switch column {
+		case "change_operation":
+			err = scanString(&event.ChangeOperation, value)
+		case "change_version":
+			var version sql.NullInt64
+			if err = version.Scan(value); err == nil && !version.Valid {
+				err = fmt.Errorf("change version is NULL")
+			}
+			event.ChangeVersion = version.Int64
+		case "aggregate_key":
+			err = scanString(&event.AggregateKey, value)
+		case "payload":
+			var payload sql.NullString
+			err = payload.Scan(value)
+			event.Payload, event.NullPayload = payload.String, !payload.Valid
+		case "change_context":
+			// SYS_CHANGE_CONTEXT is varbinary, a context that is not text is hex encoded
+			if context, ok := value.([]byte); ok && !utf8.Valid(context) {
+				event.ChangeContext = hex.EncodeToString(context)
+			} else {
+				err = scanString(&event.ChangeContext, value)
+			}
+		case "commit_time":
+			var commitTime sql.NullTime
+			if s, ok := value.(string); ok {
+				// values of a quarantined row are stored as JSON
+				commitTime.Time, err = time.Parse(time.RFC3339Nano, s)
+				commitTime.Valid = err == nil
+			} else {
+				err = commitTime.Scan(value)
+			}
+			if commitTime.Valid {
+				event.CommitTime = &commitTime.Time
+			}
+		case "user_id":
+			err = scanString(&event.UserID, value)
+		case "correlation_id":
+			err = scanString(&event.CorrelationID, value)
+		case "change_columns":
+			// only read by the column filter
+		case relevantColumn:
+			var flag sql.NullBool
+			err = flag.Scan(value)
+			relevant = !flag.Valid || flag.Bool
+		default:
+			if event.Metadata == nil {
+				event.Metadata = make(map[string]any)
+			}
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			event.Metadata[column] = value
+		}
+		if err != nil {
+			return event, false, fmt.Errorf("invalid value of column '%s': %w", column, err)
+		}
+	}
+	if m.key != nil {
+		if err := m.deriveKey(&event, natural); err != nil {
+			return event, false, err
+		}
+	}
+	return event, relevant, nil
+}
+
+// deriveKey sets the aggregate key derived from the natural key and keeps the natural key
+// columns in the metadata
+func (m *rowMapper) deriveKey(event *ChangeEvent, natural map[string]any) error {
+	parts := make([]string, len(m.key.Columns))
+	for i, column := range m.key.Columns {
+		part, err := keyValue(natural[column])
+		if err != nil {
+			return fmt.Errorf("invalid value of column '%s': %w", column, err)
+		}
+		parts[i] = part
+	}
+	event.AggregateKey = m.key.derive(strings.Join(parts, naturalKeySeparator))
+	if event.Metadata == nil {
+		event.Metadata = make(map[string]any)
+	}
+	event.Metadata[naturalKeyMetadata] = natural
+	return nil
+}
+
+// scanString sets target to the value unless it is NULL, so a NULL keeps the default
+func scanString(target *string, value any) error {
+	var s sql.NullString
+	if err := s.Scan(value); err != nil {
+		return err
+	}
+	if s.Valid {
+		*target = s.String
+	}
+	return nil
+}
+
+// isDelete reports whether the change operation deletes the aggregate
+func isDelete(operation string) bool {
+	switch strings.ToLower(operation) {
+	case "d", "delete", "deleted":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,319 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/salesworks/s-works/slx/internal/messaging"
+)
+
+// defaultEventType and defaultSubject are the names of the events of aggregates without templates
+const (
+	defaultEventType = "erp.{{aggregate}}.{{operation}}"
+	defaultSubject   = "erp.{{aggregate}}"
+)
+
+// missingValue replaces a payload field that is missing or null in a name, tombstones render
+// every payload field as missingValue
+const missingValue = "_"
+
+// nameTemplate is a parsed event type or subject template. Placeholders are written as
+// {{aggregate}}, {{operation}}, {{key}}, {{env}} or {{payload.<path>}}.
+type nameTemplate struct {
+	parts []templatePart
+	// usesPayload is set when a placeholder reads the payload
+	usesPayload bool
+}
+
+// templatePart is either literal text or a placeholder
+type templatePart struct {
+	literal     string
+	placeholder string
+	path        []string
+}
+
+// templateValues are the values of the placeholders
+type templateValues struct {
+	aggregate string
+	operation string
+	key       string
+	env       string
+	payload   map[string]any
+}
+
+func parseTemplate(text string) (*nameTemplate, error) {
+	t := &nameTemplate{}
+	for rest := text; rest != ""; {
+		start := strings.Index(rest, "{{")
+		if start < 0 {
+			t.parts = append(t.parts, templatePart{literal: rest})
+			break
+		}
+		if start > 0 {
+			t.parts = append(t.parts, templatePart{literal: rest[:start]})
+		}
+		end := strings.Index(rest[start:], "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("template '%s' has an unterminated placeholder", text)
+		}
+		name := strings.TrimSpace(rest[start+2 : start+end])
+		rest = rest[start+end+2:]
+
+		part := templatePart{placeholder: name}
+		switch {
+		case name == "aggregate" || name == "operation" || name == "key" || name == "env":
+		case strings.HasPrefix(name, "payload."):
+			part.path = strings.Split(strings.TrimPrefix(name, "payload."), ".")
+			for _, segment := range part.path {
+				if segment == "" {
+					return nil, fmt.Errorf("template '%s' has an invalid payload path '%s'", text, name)
+				}
+			}
+			t.usesPayload = true
+		default:
+			return nil, fmt.Errorf(
+				"template '%s' has unknown placeholder '%s', use aggregate, operation, key, env or payload.<path>",
+				text, name,
+			)
+		}
+		t.parts = append(t.parts, part)
+	}
+	if len(t.parts) == 0 {
+		return nil, fmt.Errorf("template is empty")
+	}
+	return t, nil
+}
+
+// uses reports whether the template has the placeholder
+func (t *nameTemplate) uses(placeholder string) bool {
+	for _, part := range t.parts {
+		if part.placeholder == placeholder {
+			return true
+		}
+	}
+	return false
+}
+
+// render fills in the placeholders. Key and payload values are sanitized, so they are a single
+// token of a NATS subject.
+func (t *nameTemplate) render(values templateValues) (string, error) {
+	var name strings.Builder
+	for _, part := range t.parts {
+		switch part.placeholder {
+		case "":
+			name.WriteString(part.literal)
+		case "aggregate":
+			name.WriteString(values.aggregate)
+		case "operation":
+			name.WriteString(values.operation)
+		case "env":
+			name.WriteString(values.env)
+		case "key":
+			name.WriteString(sanitizeToken(values.key))
+		default:
+			value, err := payloadValue(values.payload, part.path)
+			if err != nil {
+				return "", fmt.Errorf("invalid value of placeholder '%s': %w", part.placeholder, err)
+			}
+			name.WriteString(sanitizeToken(value))
+		}
+	}
+	return name.String(), nil
+}
+
+// payloadValue returns the scalar at the path as text, a missing or null value is missingValue
+func payloadValue(payload map[string]any, path []string) (string, error) {
+	var value any = payload
+	for _, segment := range path {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return missingValue, nil
+		}
+		value = object[segment]
+	}
+	switch v := value.(type) {
+	case nil:
+		return missingValue, nil
+	case map[string]any, []any:
+		return "", fmt.Errorf("value is not a scalar")
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// sanitizeToken replaces the characters that separate or match NATS subject tokens
+func sanitizeToken(value string) string {
+	if value == "" {
+		return missingValue
+	}
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '.', '*', '>', ' ', '\t', '\r', '\n':
+			return '_'
+		}
+		return r
+	}, value)
+}
+
+// compileTemplates parses the event type and subject templates of the aggregate. An event type
+// has to start with erp.{{aggregate}}. as replays and retention select events by that prefix.
+func (a *Aggregate) compileTemplates() error {
+	eventType, subject := a.EventType, a.Subject
+	if eventType == "" {
+		eventType = defaultEventType
+	}
+	if subject == "" {
+		subject = defaultSubject
+	}
+
+	var err error
+	if a.eventType, err = parseTemplate(eventType); err != nil {
+		return fmt.Errorf("aggregate '%s' has an invalid event_type: %w", a.Name, err)
+	}
+	// placeholders render as a character no literal contains, so only literal text and the
+	// aggregate name can form the prefix
+	probe, err := a.eventType.render(templateValues{aggregate: a.Name, operation: "\x00", env: "\x00"})
+	if err != nil || !strings.HasPrefix(probe, "erp."+a.Name+".") || len(probe) == len("erp."+a.Name+".") {
+		return fmt.Errorf("aggregate '%s' has an invalid event_type: it must start with erp.{{aggregate}}.", a.Name)
+	}
+
+	if a.subject, err = parseTemplate(subject); err != nil {
+		return fmt.Errorf("aggregate '%s' has an invalid subject: %w", a.Name, err)
+	}
+	if a.subject.uses("operation") && !a.eventType.uses("operation") {
+		// replays recover the operation from the stored event type
+		return fmt.Errorf("aggregate '%s' has an invalid subject: {{operation}} requires {{operation}} in the event_type", a.Name)
+	}
+	if a.subject.uses("key") {
+		// every subject is a label of the publish metrics
+		return fmt.Errorf("aggregate '%s' has an invalid subject: {{key}} would create a subject per aggregate", a.Name)
+	}
+	return nil
+}
+
+// CheckEnvironment fails when an aggregate renders {{env}} but the environment is not set
+func CheckEnvironment(aggregates []Aggregate, env string) error {
+	if env != "" {
+		return nil
+	}
+	for _, aggregate := range aggregates {
+		if aggregate.eventType == nil || aggregate.subject == nil {
+			if err := aggregate.compileTemplates(); err != nil {
+				return err
+			}
+		}
+		if aggregate.eventType.uses("env") || aggregate.subject.uses("env") {
+			return fmt.Errorf("aggregate '%s' uses {{env}} but the environment is not set", aggregate.Name)
+		}
+	}
+	return nil
+}
+
+// eventNames renders the event type and subject of the event, aggregates that were not loaded
+// with LoadAggregates use the default names
+func (a Aggregate) eventNames(event ChangeEvent, env string) (eventType string, subject string, err error) {
+	values, err := a.templateValues(event, env)
+	if err != nil {
+		return "", "", err
+	}
+	if eventType, err = a.eventType.render(values); err != nil {
+		return "", "", fmt.Errorf("failed to render event_type: %w", err)
+	}
+	if subject, err = a.subject.render(values); err != nil {
+		return "", "", fmt.Errorf("failed to render subject: %w", err)
+	}
+	return eventType, subject, nil
+}
+
+// templateValues compiles the templates when needed and collects the values of the event
+func (a *Aggregate) templateValues(event ChangeEvent, env string) (templateValues, error) {
+	if a.eventType == nil || a.subject == nil {
+		if err := a.compileTemplates(); err != nil {
+			return templateValues{}, err
+		}
+	}
+
+	values := templateValues{
+		aggregate: a.Name,
+		operation: event.ChangeOperation,
+		key:       event.AggregateKey,
+		env:       env,
+	}
+	if (a.eventType.usesPayload || a.subject.usesPayload) && !event.NullPayload {
+		decoder := json.NewDecoder(bytes.NewReader([]byte(event.Payload)))
+		decoder.UseNumber()
+		if err := decoder.Decode(&values.payload); err != nil {
+			return templateValues{}, fmt.Errorf("failed to decode payload for the event names: %w", err)
+		}
+	}
+	return values, nil
+}
+
+// operation recovers the {{operation}} an event type was rendered with by matching it against
+// the template, the other placeholders render from the values
+func (t *nameTemplate) operation(eventType string, values templateValues) (string, error) {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, part := range t.parts {
+		if part.placeholder == "operation" {
+			pattern.WriteString(`([^.]*)`)
+			continue
+		}
+		rendered, err := (&nameTemplate{parts: []templatePart{part}}).render(values)
+		if err != nil {
+			return "", err
+		}
+		pattern.WriteString(regexp.QuoteMeta(rendered))
+	}
+	pattern.WriteString("$")
+
+	match := regexp.MustCompile(pattern.String()).FindStringSubmatch(eventType)
+	if match == nil {
+		return "", fmt.Errorf("event type '%s' does not match the event_type template", eventType)
+	}
+	if len(match) == 1 {
+		return "", nil
+	}
+	for _, operation := range match[2:] {
+		if operation != match[1] {
+			return "", fmt.Errorf("event type '%s' has different operations", eventType)
+		}
+	}
+	return match[1], nil
+}
+
+// ReplaySubject renders the subject of a stored envelope of the aggregate for a replay. The
+// {{operation}} placeholder renders to the operation matched by the event_type template, so
+// events stored before the template was changed cannot be replayed on their subject.
+func (a Aggregate) ReplaySubject(envelope *messaging.EventEnvelope, env string) (string, error) {
+	event := ChangeEvent{AggregateKey: envelope.AggregateKey}
+	switch payload := envelope.Payload.(type) {
+	case json.RawMessage:
+		event.Payload = string(payload)
+	case string:
+		event.Payload = payload
+	default:
+		encoded, err := json.Marshal(payload)
+		if err != nil {
+			return "", fmt.Errorf("failed to encode payload: %w", err)
+		}
+		event.Payload = string(encoded)
+	}
+	event.NullPayload = event.Payload == "null"
+
+	values, err := a.templateValues(event, env)
+	if err != nil {
+		return "", err
+	}
+	if values.operation, err = a.eventType.operation(envelope.EventType, values); err != nil {
+		return "", fmt.Errorf("failed to match event_type: %w", err)
+	}
+	subject, err := a.subject.render(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to render subject: %w", err)
+	}
+	return subject, nil
+}
@@ -0,0 +1,270 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/salesworks/s-works/slx/internal/messaging"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate_EventNames(t *testing.T) {
+	event := ChangeEvent{
+		ChangeOperation: "updated",
+		AggregateKey:    "C4CA4238A0B923820DCC509A6F75849B",
+		Payload:         `{"customer_sales_area":"OPOLE WSCHÓD","customer_address":{"country":"PL"},"customer_tags":["a"]}`,
+	}
+
+	tests := []struct {
+		name          string
+		eventType     string
+		subject       string
+		event         ChangeEvent
+		wantEventType string
+		wantSubject   string
+		wantErr       string
+	}{
+		{
+			name:          "defaults",
+			event:         event,
+			wantEventType: "erp.customer.updated",
+			wantSubject:   "erp.customer",
+		},
+		{
+			name:          "env and payload fields",
+			eventType:     "erp.{{aggregate}}.{{ payload.customer_address.country }}.{{operation}}",
+			subject:       "erp.{{env}}.{{aggregate}}.{{payload.customer_sales_area}}",
+			event:         event,
+			wantEventType: "erp.customer.PL.updated",
+			wantSubject:   "erp.production.customer.OPOLE_WSCHÓD",
+		},
+		{
+			name:          "key in the event type",
+			eventType:     "erp.{{aggregate}}.{{key}}",
+			event:         event,
+			wantEventType: "erp.customer.C4CA4238A0B923820DCC509A6F75849B",
+			wantSubject:   "erp.customer",
+		},
+		{
+			name:          "tombstone has missing payload fields",
+			subject:       "erp.{{aggregate}}.{{payload.customer_sales_area}}",
+			event:         ChangeEvent{ChangeOperation: "deleted", AggregateKey: "K", NullPayload: true},
+			wantEventType: "erp.customer.deleted",
+			wantSubject:   "erp.customer._",
+		},
+		{
+			name:    "object value",
+			subject: "erp.{{aggregate}}.{{payload.customer_address}}",
+			event:   event,
+			wantErr: "not a scalar",
+		},
+		{
+			name:    "array value",
+			subject: "erp.{{aggregate}}.{{payload.customer_tags}}",
+			event:   event,
+			wantErr: "not a scalar",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			agg := Aggregate{Name: "customer", EventType: tt.eventType, Subject: tt.subject}
+			require.NoError(t, agg.compileTemplates())
+
+			// --- Act ---
+			eventType, subject, err := agg.eventNames(tt.event, "production")
+
+			// --- Assert ---
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEventType, eventType)
+			assert.Equal(t, tt.wantSubject, subject)
+		})
+	}
+}
+
+func TestAggregate_CompileTemplates_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		eventType string
+		subject   string
+		wantErr   string
+	}{
+		{name: "event type without prefix", eventType: "{{aggregate}}.{{operation}}", wantErr: "must start with erp.{{aggregate}}."},
+		{name: "event type of another aggregate", eventType: "erp.invoice.{{operation}}", wantErr: "must start with erp.{{aggregate}}."},
+		{name: "event type prefix from a placeholder", eventType: "erp.{{aggregate}}{{operation}}", wantErr: "must start with erp.{{aggregate}}."},
+		{name: "event type ends after the prefix", eventType: "erp.{{aggregate}}.", wantErr: "must start with erp.{{aggregate}}."},
+		{name: "unknown placeholder", subject: "erp.{{tenant}}", wantErr: "unknown placeholder 'tenant'"},
+		{name: "unterminated placeholder", subject: "erp.{{aggregate", wantErr: "unterminated placeholder"},
+		{name: "empty payload path", subject: "erp.{{payload.}}", wantErr: "invalid payload path"},
+		{name: "key in the subject", subject: "erp.{{aggregate}}.{{key}}", wantErr: "subject per aggregate"},
+		{
+			name:      "operation only in the subject",
+			eventType: "erp.{{aggregate}}.changed",
+			subject:   "erp.{{aggregate}}.{{operation}}",
+			wantErr:   "requires {{operation}} in the event_type",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			agg := Aggregate{Name: "customer", EventType: tt.eventType, Subject: tt.subject}
+
+			// --- Act ---
+			err := agg.compileTemplates()
+
+			// --- Assert ---
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestAggregate_BuildJobs_Templates(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{
+		Name:    "customer",
+		Subject: "erp.{{env}}.{{aggregate}}.{{payload.customer_sales_area}}",
+		Filter:  `envelope.event_type == 'erp.customer.updated'`,
+	}
+	require.NoError(t, agg.compileTemplates())
+	require.NoError(t, agg.compileFilter())
+	event := ChangeEvent{ChangeOperation: "updated", ChangeVersion: 2, AggregateKey: "K", Payload: `{"customer_sales_area":"OPOLE"}`}
+
+	// --- Act ---
+	jobs, err := agg.buildJobs(context.Background(), event, "production")
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "erp.production.customer.OPOLE", jobs[0].EventChannel)
+	assert.Equal(t, "erp.customer.updated", jobs[0].EventEnvelope.EventType)
+}
+
+func TestAggregate_BuildJobs_TombstoneSubject(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{Name: "customer", Subject: "erp.{{env}}.{{aggregate}}.{{payload.customer_sales_area}}"}
+	require.NoError(t, agg.compileTemplates())
+	updated := ChangeEvent{ChangeOperation: "updated", ChangeVersion: 2, AggregateKey: "K", Payload: `{"customer_sales_area":"OPOLE"}`}
+	deleted := ChangeEvent{ChangeOperation: "deleted", ChangeVersion: 3, AggregateKey: "K", NullPayload: true}
+
+	// --- Act ---
+	updatedJobs, updatedErr := agg.buildJobs(context.Background(), updated, "production")
+	deletedJobs, deletedErr := agg.buildJobs(context.Background(), deleted, "production")
+
+	// --- Assert ---
+	require.NoError(t, updatedErr)
+	require.NoError(t, deletedErr)
+	require.Len(t, updatedJobs, 1)
+	require.Len(t, deletedJobs, 1)
+	assert.Equal(t, "erp.production.customer.OPOLE", updatedJobs[0].EventChannel)
+	assert.Equal(t, "erp.production.customer._", deletedJobs[0].EventChannel,
+		"a tombstone has no sales area, consumers of a sales area have to subscribe to _ as well")
+	assert.Equal(t, "K", deletedJobs[0].EventEnvelope.AggregateKey)
+}
+
+func TestAggregate_ReplaySubject(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{Name: "customer", Subject: "erp.{{env}}.{{aggregate}}.{{operation}}.{{payload.customer_sales_area}}"}
+	require.NoError(t, agg.compileTemplates())
+	stored := &messaging.EventEnvelope{
+		EventType:    "erp.customer.updated",
+		AggregateKey: "K",
+		Payload:      json.RawMessage(`{"customer_sales_area":"OPOLE"}`),
+	}
+	tombstone := &messaging.EventEnvelope{EventType: "erp.customer.deleted", AggregateKey: "K", Payload: json.RawMessage("null")}
+
+	// --- Act ---
+	subject, err := agg.ReplaySubject(stored, "production")
+	tombstoneSubject, tombstoneErr := agg.ReplaySubject(tombstone, "production")
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.NoError(t, tombstoneErr)
+	assert.Equal(t, "erp.production.customer.updated.OPOLE", subject)
+	assert.Equal(t, "erp.production.customer.deleted._", tombstoneSubject)
+}
+
+func TestAggregate_Subject_LiveReplayAndReprocess(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{Name: "customer", Subject: "erp.{{env}}.{{aggregate}}.{{payload.customer_sales_area}}"}
+	require.NoError(t, agg.compileTemplates())
+	event := ChangeEvent{ChangeOperation: "updated", ChangeVersion: 3, AggregateKey: "K", Payload: `{"customer_sales_area":"OPOLE"}`}
+	row := QuarantinedRow{
+		ID:        1,
+		Aggregate: "customer",
+		Values: map[string]any{
+			"change_operation": "updated",
+			"change_version":   json.Number("3"),
+			"aggregate_key":    "K",
+			"payload":          `{"customer_sales_area":"OPOLE"}`,
+		},
+	}
+
+	// --- Act ---
+	live, err := agg.buildJobs(context.Background(), event, "staging")
+	require.NoError(t, err)
+	require.Len(t, live, 1)
+	replayed, err := agg.ReplaySubject(live[0].EventEnvelope, "staging")
+	require.NoError(t, err)
+	reprocessed, err := QuarantinedJobs(context.Background(), agg, row, "staging")
+	require.NoError(t, err)
+
+	// --- Assert ---
+	require.Len(t, reprocessed, 1)
+	assert.Equal(t, "erp.staging.customer.OPOLE", live[0].EventChannel)
+	assert.Equal(t, live[0].EventChannel, replayed)
+	assert.Equal(t, live[0].EventChannel, reprocessed[0].EventChannel)
+}
+
+func TestCheckEnvironment(t *testing.T) {
+	// --- Arrange ---
+	aggregates := []Aggregate{{Name: "invoice"}, {Name: "customer", Subject: "erp.{{env}}.{{aggregate}}"}}
+
+	// --- Act ---
+	setErr := CheckEnvironment(aggregates, "production")
+	unsetErr := CheckEnvironment(aggregates, "")
+	withoutEnvErr := CheckEnvironment(aggregates[:1], "")
+
+	// --- Assert ---
+	assert.NoError(t, setErr)
+	assert.ErrorContains(t, unsetErr, "aggregate 'customer' uses {{env}}")
+	assert.NoError(t, withoutEnvErr)
+}
+
+func TestAggregate_ReplaySubject_OperationNotLast(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{
+		Name:      "customer",
+		EventType: "erp.{{aggregate}}.{{operation}}.{{payload.customer_sales_area}}",
+		Subject:   "erp.{{aggregate}}.{{operation}}",
+	}
+	require.NoError(t, agg.compileTemplates())
+	event := ChangeEvent{ChangeOperation: "updated", ChangeVersion: 4, AggregateKey: "K", Payload: `{"customer_sales_area":"OPOLE WSCHÓD"}`}
+	live, err := agg.buildJobs(context.Background(), event, "")
+	require.NoError(t, err)
+	require.Len(t, live, 1)
+	changed := &messaging.EventEnvelope{
+		EventType:    "erp.customer.updated.OPOLE",
+		AggregateKey: "K",
+		Payload:      json.RawMessage(`{"customer_sales_area":"OPOLE WSCHÓD"}`),
+	}
+
+	// --- Act ---
+	subject, err := agg.ReplaySubject(live[0].EventEnvelope, "")
+	_, changedErr := agg.ReplaySubject(changed, "")
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, "erp.customer.updated.OPOLE_WSCHÓD", live[0].EventEnvelope.EventType)
+	assert.Equal(t, "erp.customer.updated", subject)
+	assert.Equal(t, live[0].EventChannel, subject)
+	assert.ErrorContains(t, changedErr, "does not match the event_type template")
+}
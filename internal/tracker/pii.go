@@ -0,0 +1,188 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/salesworks/s-works/slx/internal/transform"
+	"github.com/salesworks/s-works/slx/pkg/pii"
+)
+
+// WithProtector protects the PII fields of the aggregates with the keys of the protector,
+// without it only the mask policy can be used
+func WithProtector(protector *pii.Protector) Option {
+	return func(t *Tracker) {
+		t.protector = protector
+	}
+}
+
+// validatePII checks the PII policies of the aggregate
+func (a Aggregate) validatePII() error {
+	for path, policy := range a.PII {
+		switch policy {
+		case pii.PolicyMask, pii.PolicyHash, pii.PolicyEncrypt:
+		default:
+			return fmt.Errorf(
+				"aggregate '%s' has unknown pii policy '%s' for '%s', use mask, hash or encrypt",
+				a.Name, policy, path,
+			)
+		}
+	}
+	return nil
+}
+
+// Protect prepares the PII policies of the aggregate with the keys of the protector, payloads
+// of an aggregate with policies are only published after it was prepared. Quarantined rows of
+// the aggregate hold the unprotected values, so quarantining requires an encryption key.
+func (a *Aggregate) Protect(protector *pii.Protector) error {
+	if a.OnError == OnErrorQuarantine {
+		if _, err := protector.Policy(pii.PolicyEncrypt); err != nil {
+			return fmt.Errorf("aggregate '%s' quarantines rows with pii: %w", a.Name, err)
+		}
+	}
+	a.protector = protector
+
+	paths := make(map[string][]string)
+	for path, policy := range a.PII {
+		paths[policy] = append(paths[policy], path)
+	}
+	policies := make([]string, 0, len(paths))
+	for policy := range paths {
+		policies = append(policies, policy)
+	}
+	sort.Strings(policies)
+
+	a.protection = nil
+	for _, policy := range policies {
+		protect, err := protector.Policy(policy)
+		if err != nil {
+			return fmt.Errorf("aggregate '%s' cannot protect pii: %w", a.Name, err)
+		}
+		sort.Strings(paths[policy])
+		pipeline, err := transform.Values(paths[policy], protectValue(protect))
+		if err != nil {
+			return fmt.Errorf("aggregate '%s' has an invalid pii path: %w", a.Name, err)
+		}
+		a.protection = append(a.protection, pipeline)
+	}
+	return nil
+}
+
+// protectValue applies a policy to a scalar JSON value, the protected value is a string
+func protectValue(protect func(string) (string, error)) func(any) (any, error) {
+	return func(value any) (any, error) {
+		switch v := value.(type) {
+		case string:
+			return protect(v)
+		case json.Number, bool:
+			return protect(fmt.Sprint(v))
+		}
+		return nil, fmt.Errorf("cannot protect %T, only text, numbers and booleans", value)
+	}
+}
+
+// protectEvent applies the PII policies of the aggregate to the payload and the metadata of
+// the event. Extra columns of the get query and the natural key columns in the metadata are
+// protected like the payload fields of the same name, a tombstone only has metadata to protect.
+func (a Aggregate) protectEvent(event ChangeEvent) (ChangeEvent, error) {
+	if len(a.PII) == 0 {
+		return event, nil
+	}
+	if a.protection == nil {
+		return event, fmt.Errorf("pii policies of aggregate '%s' are not prepared", a.Name)
+	}
+	if !event.NullPayload {
+		payload, err := a.protectJSON([]byte(event.Payload))
+		if err != nil {
+			return event, fmt.Errorf("failed to protect payload: %w", err)
+		}
+		event.Payload = string(payload)
+	}
+	if len(event.Metadata) > 0 {
+		metadata, err := a.protectObject(event.Metadata)
+		if err != nil {
+			return event, fmt.Errorf("failed to protect metadata: %w", err)
+		}
+		if natural, ok := metadata[naturalKeyMetadata].(map[string]any); ok {
+			if metadata[naturalKeyMetadata], err = a.protectObject(natural); err != nil {
+				return event, fmt.Errorf("failed to protect natural key: %w", err)
+			}
+		}
+		event.Metadata = metadata
+	}
+	return event, nil
+}
+
+// protectJSON applies the prepared PII policies to a JSON object
+func (a Aggregate) protectJSON(object []byte) ([]byte, error) {
+	for _, pipeline := range a.protection {
+		protected, err := pipeline.Apply(object)
+		if err != nil {
+			return nil, err
+		}
+		object = protected
+	}
+	return object, nil
+}
+
+// protectObject applies the prepared PII policies to a copy of the object
+func (a Aggregate) protectObject(object map[string]any) (map[string]any, error) {
+	encoded, err := json.Marshal(object)
+	if err != nil {
+		return nil, err
+	}
+	protected, err := a.protectJSON(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var decoded map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(protected))
+	decoder.UseNumber()
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// sealedValues is the only value of a quarantined row encrypted at rest, it holds the
+// encrypted JSON of the row values
+const sealedValues = "pii_sealed"
+
+// sealValues encrypts the values of a row of an aggregate with PII policies before it is
+// quarantined, the values of other aggregates are kept as they are
+func (a Aggregate) sealValues(values map[string]any) (map[string]any, error) {
+	if len(a.PII) == 0 {
+		return values, nil
+	}
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode row values: %w", err)
+	}
+	sealed, err := a.protector.Encrypt(string(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt row values: %w", err)
+	}
+	return map[string]any{sealedValues: sealed}, nil
+}
+
+// openValues decrypts the values of a quarantined row sealed by sealValues
+func (a Aggregate) openValues(values map[string]any) (map[string]any, error) {
+	sealed, ok := values[sealedValues].(string)
+	if !ok || len(values) != 1 {
+		return values, nil
+	}
+	encoded, err := a.protector.Decrypt(sealed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt row values: %w", err)
+	}
+	var opened map[string]any
+	decoder := json.NewDecoder(strings.NewReader(encoded))
+	decoder.UseNumber()
+	if err := decoder.Decode(&opened); err != nil {
+		return nil, fmt.Errorf("failed to decode row values: %w", err)
+	}
+	return opened, nil
+}
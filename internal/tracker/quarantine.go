@@ -0,0 +1,127 @@
+package tracker
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/salesworks/s-works/slx/internal/dispatcher"
+)
+
+const (
+	// OnErrorFail fails the cycle on a row that cannot be turned into an event, it is the default
+	OnErrorFail = "fail"
+	// OnErrorSkip logs the row and continues the cycle
+	OnErrorSkip = "skip"
+	// OnErrorQuarantine stores the row for a later reprocess and continues the cycle
+	OnErrorQuarantine = "quarantine"
+)
+
+// QuarantinedRow is a row of a get query that could not be turned into an event
+type QuarantinedRow struct {
+	ID            int64          `json:"id"`
+	Aggregate     string         `json:"aggregate"`
+	ChangeVersion int64          `json:"change_version"`
+	Values        map[string]any `json:"values"`
+	Error         string         `json:"error"`
+	QuarantinedAt time.Time      `json:"quarantined_at"`
+}
+
+// Quarantine stores the rows rejected by aggregates with the quarantine error policy
+type Quarantine interface {
+	// QuarantineRow stores the row and assigns its ID
+	QuarantineRow(ctx context.Context, row QuarantinedRow) error
+	// ListQuarantined returns the quarantined rows of the aggregate ordered by ID, all rows when
+	// the aggregate name is empty
+	ListQuarantined(ctx context.Context, aggregateName string) ([]QuarantinedRow, error)
+	// DeleteQuarantined removes a quarantined row
+	DeleteQuarantined(ctx context.Context, id int64) error
+}
+
+// WithQuarantine stores the rows rejected by aggregates with the quarantine error policy
+func WithQuarantine(quarantine Quarantine) Option {
+	return func(t *Tracker) {
+		t.quarantine = quarantine
+	}
+}
+
+// validateOnError checks the error policy of the aggregate
+func (a Aggregate) validateOnError() error {
+	switch a.OnError {
+	case "", OnErrorFail, OnErrorSkip, OnErrorQuarantine:
+		return nil
+	}
+	return fmt.Errorf("aggregate '%s' has unknown on_error '%s'", a.Name, a.OnError)
+}
+
+// rejectRow applies the error policy of the aggregate to a row that could not be turned into
+// an event, it returns an error when the cycle has to fail. A quarantined row is handed to
+// store, or stored in the quarantine of the tracker when store is nil.
+func (t *Tracker) rejectRow(
+	ctx context.Context, agg Aggregate, values map[string]any, version int64, rowErr error,
+	store func(QuarantinedRow) error,
+) error {
+	switch agg.OnError {
+	case OnErrorSkip:
+		t.logger.Warn("skipping invalid ERP change", "aggregate", agg.Name, "version", version, "error", rowErr)
+		return nil
+	case OnErrorQuarantine:
+		values, err := agg.sealValues(values)
+		if err != nil {
+			return fmt.Errorf("failed to quarantine ERP change: %w", err)
+		}
+		row := QuarantinedRow{
+			Aggregate:     agg.Name,
+			ChangeVersion: version,
+			Values:        values,
+			Error:         rowErr.Error(),
+			QuarantinedAt: time.Now(),
+		}
+		if store == nil {
+			store = func(row QuarantinedRow) error {
+				return t.quarantine.QuarantineRow(ctx, row)
+			}
+		}
+		if err := store(row); err != nil {
+			return fmt.Errorf("failed to quarantine ERP change: %w", err)
+		}
+		t.logger.Warn("quarantined invalid ERP change", "aggregate", agg.Name, "version", version, "error", rowErr)
+		return nil
+	default:
+		return fmt.Errorf("failed to dispatch ERP change: %w", rowErr)
+	}
+}
+
+// QuarantinedJobs maps the values of a quarantined row again with the current configuration of
+// its aggregate, so fixes made to the configuration apply to the reprocessed events. A row the
+// filter of the aggregate drops has no jobs. The {{env}} placeholder of the event names renders
+// to env.
+func QuarantinedJobs(ctx context.Context, agg Aggregate, row QuarantinedRow, env string) ([]dispatcher.Job, error) {
+	rowValues, err := agg.openValues(row.Values)
+	if err != nil {
+		return nil, err
+	}
+	columns := make([]string, 0, len(rowValues))
+	for column := range rowValues {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	values := make([]any, len(columns))
+	for i, column := range columns {
+		values[i] = rowValues[column]
+	}
+
+	mapper, err := newRowMapper(columns, agg.Defaults, agg.Key)
+	if err != nil {
+		return nil, err
+	}
+	event, _, err := mapper.event(values)
+	if err != nil {
+		return nil, err
+	}
+	if event.NullPayload && !isDelete(event.ChangeOperation) {
+		return nil, fmt.Errorf("change '%s' has no payload", event.ChangeOperation)
+	}
+	return agg.buildJobs(ctx, event, env)
+}
@@ -0,0 +1,111 @@
+package tracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// splitKeySeparator joins the parent aggregate key with the key fields of an element
+const splitKeySeparator = ":"
+
+// Split publishes one event per element of an array in the payload instead of one per row.
+//
+// Split aggregates cannot retract single elements: no element tombstones are published, as the
+// elements of a deleted row are not known anymore. A delete publishes one tombstone under the
+// parent key, consumers remove every element key starting with the parent key and ':'. An
+// element removed from the array by an update is not retracted, and an update with an empty or
+// missing array publishes nothing. Consumers that need removed elements should replace all
+// elements of a parent key with the elements of each change version.
+type Split struct {
+	// Path is the dot separated path of the array, it must not cross other arrays
+	Path string `yaml:"path"`
+	// Key are the element fields appended to the parent aggregate key, e.g. the warehouse and
+	// the batch of a stock level
+	Key []string `yaml:"key"`
+	// Parent are the payload fields copied into every element, elements keep their own value
+	// of a field they already have
+	Parent []string `yaml:"parent"`
+}
+
+// validateSplit checks the split of the aggregate
+func (a Aggregate) validateSplit() error {
+	if a.Split == nil {
+		return nil
+	}
+	if a.Split.Path == "" || strings.Contains(a.Split.Path, "..") ||
+		strings.HasPrefix(a.Split.Path, ".") || strings.HasSuffix(a.Split.Path, ".") {
+		return fmt.Errorf("aggregate '%s' has an invalid split path '%s'", a.Name, a.Split.Path)
+	}
+	if len(a.Split.Key) == 0 {
+		return fmt.Errorf("aggregate '%s' splits '%s' without key fields", a.Name, a.Split.Path)
+	}
+	return nil
+}
+
+// splitEvent returns one event per element of the split array. The key of an element event is
+// the parent key followed by the element key fields, e.g. "<key>:MAG1:B12". A tombstone cannot
+// be split and is returned with the parent key, see Split.
+func (a Aggregate) splitEvent(event ChangeEvent) ([]ChangeEvent, error) {
+	if a.Split == nil || event.NullPayload {
+		return []ChangeEvent{event}, nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader([]byte(event.Payload)))
+	decoder.UseNumber()
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil || doc == nil {
+		return nil, fmt.Errorf("payload to split is not a JSON object")
+	}
+
+	var value any = doc
+	for _, segment := range strings.Split(a.Split.Path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("split path '%s' crosses a value that is not an object", a.Split.Path)
+		}
+		value = object[segment]
+	}
+	if value == nil {
+		return nil, nil
+	}
+	elements, ok := value.([]any)
+	if !ok {
+		return nil, fmt.Errorf("split path '%s' is not an array", a.Split.Path)
+	}
+
+	events := make([]ChangeEvent, 0, len(elements))
+	for i, element := range elements {
+		fields, ok := element.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("element %d of '%s' is not an object", i, a.Split.Path)
+		}
+		key := []string{event.AggregateKey}
+		for _, field := range a.Split.Key {
+			keyValue, ok := fields[field]
+			if !ok || keyValue == nil {
+				return nil, fmt.Errorf("element %d of '%s' has no key field '%s'", i, a.Split.Path, field)
+			}
+			key = append(key, fmt.Sprint(keyValue))
+		}
+		for _, field := range a.Split.Parent {
+			if _, ok := fields[field]; ok {
+				continue
+			}
+			if parentValue, ok := doc[field]; ok {
+				fields[field] = parentValue
+			}
+		}
+		payload, err := json.Marshal(fields)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode element %d of '%s': %w", i, a.Split.Path, err)
+		}
+
+		split := event
+		split.AggregateKey = strings.Join(key, splitKeySeparator)
+		split.Payload = string(payload)
+		events = append(events, split)
+	}
+	return events, nil
+}
@@ -0,0 +1,167 @@
+package tracker
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAggregate_SplitEvent(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{Name: "stock", Split: &Split{
+		Path:   "sku_stock",
+		Key:    []string{"sku_stock_warehouse", "sku_stock_batch"},
+		Parent: []string{"sku_code", "sku_unit"},
+	}}
+	event := ChangeEvent{
+		ChangeOperation: "updated",
+		ChangeVersion:   7,
+		AggregateKey:    "C4CA4238A0B923820DCC509A6F75849B",
+		Payload: `{"sku_code":"A-1","sku_unit":"szt","sku_stock":[
+			{"sku_stock_warehouse":"MAG1","sku_stock_batch":"B12","sku_stock_qty":5},
+			{"sku_stock_warehouse":"MAG2","sku_stock_batch":7,"sku_stock_qty":1,"sku_unit":"kg"}
+		]}`,
+	}
+
+	// --- Act ---
+	events, err := agg.splitEvent(event)
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, "C4CA4238A0B923820DCC509A6F75849B:MAG1:B12", events[0].AggregateKey)
+	assert.JSONEq(t, `{"sku_code":"A-1","sku_unit":"szt","sku_stock_warehouse":"MAG1","sku_stock_batch":"B12","sku_stock_qty":5}`, events[0].Payload)
+	assert.Equal(t, "C4CA4238A0B923820DCC509A6F75849B:MAG2:7", events[1].AggregateKey)
+	assert.JSONEq(t, `{"sku_code":"A-1","sku_unit":"kg","sku_stock_warehouse":"MAG2","sku_stock_batch":7,"sku_stock_qty":1}`, events[1].Payload,
+		"an element should keep its own value of a parent field")
+	assert.Equal(t, int64(7), events[1].ChangeVersion)
+}
+
+func TestAggregate_SplitEvent_Edges(t *testing.T) {
+	agg := Aggregate{Name: "stock", Split: &Split{Path: "stock.levels", Key: []string{"warehouse"}}}
+
+	tests := []struct {
+		name       string
+		event      ChangeEvent
+		wantEvents int
+		wantErr    string
+	}{
+		{name: "tombstone keeps the parent key", event: ChangeEvent{AggregateKey: "K", NullPayload: true}, wantEvents: 1},
+		{name: "missing array", event: ChangeEvent{AggregateKey: "K", Payload: `{"stock":{}}`}, wantEvents: 0},
+		{name: "empty array", event: ChangeEvent{AggregateKey: "K", Payload: `{"stock":{"levels":[]}}`}, wantEvents: 0},
+		{name: "not an array", event: ChangeEvent{AggregateKey: "K", Payload: `{"stock":{"levels":{}}}`}, wantErr: "is not an array"},
+		{name: "path crosses an array", event: ChangeEvent{AggregateKey: "K", Payload: `{"stock":[]}`}, wantErr: "not an object"},
+		{name: "element without key", event: ChangeEvent{AggregateKey: "K", Payload: `{"stock":{"levels":[{"qty":1}]}}`}, wantErr: "no key field 'warehouse'"},
+		{name: "scalar element", event: ChangeEvent{AggregateKey: "K", Payload: `{"stock":{"levels":[1]}}`}, wantErr: "is not an object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			events, err := agg.splitEvent(tt.event)
+
+			// --- Assert ---
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, events, tt.wantEvents)
+		})
+	}
+}
+
+func TestAggregate_BuildJobs_SplitAndFilter(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{
+		Name:   "priceterm",
+		Split:  &Split{Path: "prices", Key: []string{"sku"}},
+		Filter: `payload.price > 0`,
+	}
+	require.NoError(t, agg.compileFilter())
+	event := ChangeEvent{
+		ChangeOperation: "updated",
+		ChangeVersion:   3,
+		AggregateKey:    "C4CA4238A0B923820DCC509A6F75849B",
+		Payload:         `{"prices":[{"sku":"A-1","price":10.5},{"sku":"B-2","price":0},{"sku":"C-3","price":2}]}`,
+	}
+
+	// --- Act ---
+	jobs, err := agg.buildJobs(context.Background(), event, "")
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Len(t, jobs, 2, "the filter should apply to every element")
+	assert.Equal(t, "C4CA4238A0B923820DCC509A6F75849B:A-1", jobs[0].EventEnvelope.AggregateKey)
+	assert.Equal(t, "C4CA4238A0B923820DCC509A6F75849B:C-3", jobs[1].EventEnvelope.AggregateKey)
+	assert.Equal(t, "erp.priceterm", jobs[1].EventChannel)
+}
+
+func TestAggregate_ValidateSplit(t *testing.T) {
+	tests := []struct {
+		name  string
+		split Split
+	}{
+		{name: "no path", split: Split{Key: []string{"sku"}}},
+		{name: "empty segment", split: Split{Path: "a..b", Key: []string{"sku"}}},
+		{name: "no key", split: Split{Path: "prices"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			err := Aggregate{Name: "priceterm", Split: &tt.split}.validateSplit()
+
+			// --- Assert ---
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestAggregate_BuildJobs_SplitDelete(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{Name: "stock", Split: &Split{Path: "sku_stock", Key: []string{"sku_stock_warehouse"}}}
+	update := ChangeEvent{
+		ChangeOperation: "updated",
+		ChangeVersion:   7,
+		AggregateKey:    "K",
+		Payload:         `{"sku_stock":[{"sku_stock_warehouse":"MAG1"},{"sku_stock_warehouse":"MAG2"}]}`,
+	}
+	removed := ChangeEvent{
+		ChangeOperation: "updated",
+		ChangeVersion:   8,
+		AggregateKey:    "K",
+		Payload:         `{"sku_stock":[{"sku_stock_warehouse":"MAG2"}]}`,
+	}
+	emptied := ChangeEvent{ChangeOperation: "updated", ChangeVersion: 9, AggregateKey: "K", Payload: `{"sku_stock":[]}`}
+	deleted := ChangeEvent{ChangeOperation: "deleted", ChangeVersion: 10, AggregateKey: "K", NullPayload: true}
+
+	// --- Act ---
+	elements, err := agg.buildJobs(context.Background(), update, "")
+	require.NoError(t, err)
+	afterRemoval, err := agg.buildJobs(context.Background(), removed, "")
+	require.NoError(t, err)
+	afterEmptying, err := agg.buildJobs(context.Background(), emptied, "")
+	require.NoError(t, err)
+	tombstones, err := agg.buildJobs(context.Background(), deleted, "")
+	require.NoError(t, err)
+
+	// --- Assert ---
+	require.Len(t, elements, 2)
+	require.Len(t, afterRemoval, 1, "a removed element is not retracted")
+	assert.Equal(t, "K:MAG2", afterRemoval[0].EventEnvelope.AggregateKey)
+	assert.Empty(t, afterEmptying, "an empty array publishes nothing")
+	require.Len(t, tombstones, 1, "a delete publishes a single tombstone")
+	tombstone := tombstones[0].EventEnvelope
+	assert.Equal(t, "K", tombstone.AggregateKey)
+	assert.Equal(t, "erp.stock.deleted", tombstone.EventType)
+	assert.Equal(t, json.RawMessage("null"), tombstone.Payload)
+	for _, element := range elements {
+		assert.True(t, strings.HasPrefix(element.EventEnvelope.AggregateKey, tombstone.AggregateKey+splitKeySeparator),
+			"consumers retract the elements by the prefix of the tombstone key")
+	}
+}
@@ -0,0 +1,66 @@
+package tracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrAggregateNotConfigured is returned for aggregates missing in the configuration
+var ErrAggregateNotConfigured = errors.New("aggregate is not configured")
+
+// AggregateStatus is the checkpoint of a configured aggregate together with its schedule
+type AggregateStatus struct {
+	Checkpoint
+	Interval int  `json:"interval"`
+	Lag      *Lag `json:"lag,omitempty"`
+}
+
+// Status returns the status of every configured aggregate
+func (t *Tracker) Status(ctx context.Context) ([]AggregateStatus, error) {
+	statuses := make([]AggregateStatus, 0, len(t.aggregates))
+	for _, agg := range t.aggregates {
+		checkpoint, err := t.repository.GetCheckpoint(ctx, agg.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get checkpoint: %w", err)
+		}
+		statuses = append(statuses, AggregateStatus{
+			Checkpoint: checkpoint,
+			Interval:   agg.Interval,
+			Lag:        t.lag(agg.Name),
+		})
+	}
+	return statuses, nil
+}
+
+// SetChangeVersion moves the change version of a configured aggregate, it waits for a running
+// cycle of the aggregate so the cycle cannot overwrite the new version
+func (t *Tracker) SetChangeVersion(ctx context.Context, aggregateName string, version int64) error {
+	if _, ok := t.aggregate(aggregateName); !ok {
+		return fmt.Errorf("%w: '%s'", ErrAggregateNotConfigured, aggregateName)
+	}
+	if version < 0 {
+		return fmt.Errorf("invalid change version %d", version)
+	}
+
+	lock := t.aggregateLock(aggregateName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	previous, err := t.repository.GetChangeVersion(ctx, aggregateName)
+	if err != nil {
+		return err
+	}
+	if err := t.repository.UpdateChangeVersion(ctx, aggregateName, version); err != nil {
+		return err
+	}
+	t.logger.Warn("change version set", "aggregate", aggregateName, "from", previous, "to", version)
+	return nil
+}
+
+// aggregateLock returns the lock held while a cycle of the aggregate runs
+func (t *Tracker) aggregateLock(aggregateName string) *sync.Mutex {
+	lock, _ := t.cycleLocks.LoadOrStore(aggregateName, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
@@ -4,16 +4,28 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
+	"sync"
 	"time"
 
 	"github.com/salesworks/s-works/slx/internal/dispatcher"
+	"github.com/salesworks/s-works/slx/internal/filter"
 	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/salesworks/s-works/slx/internal/metrics"
+	"github.com/salesworks/s-works/slx/internal/telemetry"
+	"github.com/salesworks/s-works/slx/internal/transform"
+	"github.com/salesworks/s-works/slx/pkg/pii"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/yaml.v3"
 )
 
+var tracer = telemetry.Tracer("tracker")
+
 // TrackerRepository defines the interface for the tracker repository
 type TrackerRepository interface {
 	// RegisterAggregates registers the names of aggregates in the repository and sets change_version
@@ -22,6 +34,26 @@ type TrackerRepository interface {
 	GetChangeVersion(ctx context.Context, aggregateName string) (int64, error)
 	// UpdateChangeVersion updates the change version for the given aggregate name
 	UpdateChangeVersion(ctx context.Context, aggregateName string, newVersion int64) error
+	// RecordCycle stores the outcome of a cycle in the checkpoint and the cycle history
+	RecordCycle(ctx context.Context, aggregateName string, cycle CycleRecord) error
+	// GetCheckpoint returns the checkpoint of the given aggregate name
+	GetCheckpoint(ctx context.Context, aggregateName string) (Checkpoint, error)
+	// CycleHistory returns up to limit of the most recent recorded cycles, newest first
+	CycleHistory(ctx context.Context, aggregateName string, limit int) ([]CycleRecord, error)
+	// RenameAggregate moves the checkpoint and history of an aggregate to a new name, it does
+	// nothing when the old name is not registered
+	RenameAggregate(ctx context.Context, oldName string, newName string) error
+}
+
+// CycleCommitter stores the events of a cycle together with the new change version, so a
+// crash can neither lose nor duplicate a cycle
+type CycleCommitter interface {
+	// CommitCycle stores the envelopes, the quarantined rows and the new change version in one
+	// transaction, it fails when the change version is no longer the version the cycle read from
+	CommitCycle(
+		ctx context.Context, aggregateName string, envelopes []*messaging.EventEnvelope,
+		quarantined []QuarantinedRow, fromVersion int64, newVersion int64,
+	) error
 }
 
 // Config represents the configuration structure for loading aggregates from YAML
@@ -34,17 +66,97 @@ type Aggregate struct {
 	Name     string `yaml:"name"`
 	Interval int    `yaml:"interval"`
 	GetQuery string `yaml:"get_query"`
+	// Retention is how long the aggregate events are kept in the events table, e.g. "7d" or "forever"
+	Retention string `yaml:"retention"`
+	// Archive writes the expired events to compressed JSONL files before they are removed
+	Archive bool `yaml:"archive"`
+	// RenamedFrom is the previous name of the aggregate, its checkpoint moves to the new name
+	RenamedFrom string `yaml:"renamed_from"`
+	// AdvanceToCurrent reads CHANGE_TRACKING_CURRENT_VERSION() before each cycle, passes it to
+	// the query as @to_version and advances the checkpoint to it even when no row matched
+	AdvanceToCurrent bool `yaml:"advance_to_current"`
+	// Tables are the change tracked tables checked for lag, by default the CHANGETABLE tables
+	// of the get query
+	Tables []string `yaml:"tables"`
+	// Isolation is the isolation level of the transaction each cycle reads in: snapshot,
+	// read_committed, read_uncommitted, repeatable_read, serializable or none. It is snapshot
+	// when not set in the configuration. The transaction ends before the events of the cycle
+	// are dispatched, so a stalled publisher does not keep the snapshot open.
+	Isolation string `yaml:"isolation"`
+	// Columns are the relevant columns of the tracked table, updates whose change_columns mask
+	// holds none of them are skipped. The get query has to return c.SYS_CHANGE_COLUMNS as
+	// change_columns and must not end with ORDER BY.
+	Columns []string `yaml:"columns"`
+	// Defaults are the values of the optional change_operation, change_context, user_id and
+	// correlation_id columns when the get query does not return them or returns NULL
+	Defaults map[string]string `yaml:"defaults"`
+	// OnError is what happens to a row that cannot be turned into an event: fail the cycle, skip
+	// the row or quarantine it for a later reprocess. It is fail when not set.
+	OnError string `yaml:"on_error"`
+	// Coalesce keeps only the newest change per aggregate key in each cycle, ordered by change
+	// version: an insert followed by a delete is dropped and an insert followed by an update
+	// stays an insert
+	Coalesce bool `yaml:"coalesce"`
+	// Transform are the steps applied to the payload before the envelope is built
+	Transform []transform.Step `yaml:"transform"`
+	// pipeline is the compiled Transform
+	pipeline *transform.Pipeline
+	// PII are the policies of personal data fields by payload path: mask, hash or encrypt. They
+	// are applied after the transform and the filter, to the payload and to the metadata and
+	// natural key columns of the same name. Quarantined rows of the aggregate are encrypted.
+	PII map[string]string `yaml:"pii"`
+	// protection are the PII policies prepared by Protect
+	protection []*transform.Pipeline
+	// protector encrypts the quarantined rows of an aggregate with PII policies
+	protector *pii.Protector
+	// Filter is the expression events have to match to be published, e.g.
+	// payload.invoice_status in ['ZAMKNIĘTY'], filtered changes still advance the checkpoint. It
+	// sees the values before the PII policies are applied.
+	Filter string `yaml:"filter"`
+	// filter is the compiled Filter
+	filter *filter.Filter
+	// Split publishes one event per element of an array of the payload, the filter applies to
+	// every element event. Deletes are published as one tombstone under the parent key.
+	Split *Split `yaml:"split"`
+	// Key derives the aggregate key from natural key columns of the get query, which then does
+	// not return aggregate_key
+	Key *Key `yaml:"key"`
+	// EventType is the template of the event type, erp.{{aggregate}}.{{operation}} when not
+	// set. Placeholders are {{aggregate}}, {{operation}}, {{key}}, {{env}} and {{payload.<path>}},
+	// the event type has to start with erp.{{aggregate}}.
+	EventType string `yaml:"event_type"`
+	// Subject is the template of the channel the events are published to, erp.{{aggregate}}
+	// when not set. Every subject is a label of the publish metrics, so it should only use
+	// payload fields with few values. A tombstone has no payload, its {{payload.<path>}}
+	// placeholders render as _, so e.g. erp.{{aggregate}}.{{payload.customer_sales_area}} sends
+	// every delete to erp.customer._ and consumers of one sales area have to subscribe to it too.
+	Subject string `yaml:"subject"`
+	// eventType and subject are the compiled templates
+	eventType *nameTemplate
+	subject   *nameTemplate
 	// InsertCommand string `yaml:"insert_command"`
 	// UpdateCommand string `yaml:"update_command"`
 	// DeleteCommand string `yaml:"delete_command"`
 }
 
-// ChangeEvent represents a change event from the ERP system
+// ChangeEvent represents a change event from the ERP system, its fields are read from the
+// get query columns of the same name
 type ChangeEvent struct {
 	ChangeOperation string `json:"change_operation"`
 	ChangeVersion   int64  `json:"change_version"`
 	AggregateKey    string `json:"aggregate_key"`
 	Payload         string `json:"payload"`
+	// NullPayload is set when the payload is NULL, deletes are published as tombstones and other
+	// changes are skipped
+	NullPayload bool `json:"null_payload,omitempty"`
+	// ChangeContext is the SYS_CHANGE_CONTEXT the ERP set when it made the change, it is
+	// published in the change_context metadata of the envelope
+	ChangeContext string     `json:"change_context,omitempty"`
+	CommitTime    *time.Time `json:"commit_time,omitempty"`
+	UserID        string     `json:"user_id,omitempty"`
+	CorrelationID string     `json:"correlation_id,omitempty"`
+	// Metadata holds the columns of the get query without a field
+	Metadata map[string]any `json:"metadata,omitempty"`
 }
 
 type Tracker struct {
@@ -53,25 +165,109 @@ type Tracker struct {
 	logger     *slog.Logger
 	db         *sql.DB
 	dispatcher *dispatcher.Dispatcher
+	committer  CycleCommitter
+	quarantine Quarantine
+	protector  *pii.Protector
+	// env is the environment the {{env}} placeholder of the event names renders to
+	env string
+	// cycleLocks serializes the cycles of an aggregate with changes made through SetChangeVersion
+	cycleLocks sync.Map
+	// lags holds the last Lag computed per aggregate by the lag monitor
+	lags         sync.Map
+	lagInterval  time.Duration
+	lagWarnRatio float64
+}
+
+// Option is a functional option for configuring the Tracker
+type Option func(*Tracker)
+
+// WithCycleCommitter commits every cycle through the committer instead of updating the change
+// version in the repository after the events are dispatched. The committed events are still
+// dispatched afterwards to the publishers of the dispatcher.
+func WithCycleCommitter(committer CycleCommitter) Option {
+	return func(t *Tracker) {
+		t.committer = committer
+	}
+}
+
+// WithEnvironment sets the environment the {{env}} placeholder of event types and subjects
+// renders to
+func WithEnvironment(env string) Option {
+	return func(t *Tracker) {
+		t.env = env
+	}
 }
 
 func NewTracker(
 	ctx context.Context, aggregatesPath string, repo TrackerRepository,
-	logger *slog.Logger, db *sql.DB, dispatcher *dispatcher.Dispatcher,
+	logger *slog.Logger, db *sql.DB, dispatcher *dispatcher.Dispatcher, options ...Option,
 ) (*Tracker, error) {
-	yamlFile, err := os.ReadFile(aggregatesPath)
+	aggregates, err := LoadAggregates(aggregatesPath)
 	if err != nil {
-		logger.Error("failed to read aggregates file", "path", aggregatesPath, "error", err)
-		return nil, fmt.Errorf("failed to read aggregates file: %w", err)
+		logger.Error("failed to load aggregates file", "path", aggregatesPath, "error", err)
+		return nil, err
 	}
 
 	tracker := &Tracker{
+		aggregates: aggregates,
 		repository: repo,
 		logger:     logger,
 		db:         db,
 		dispatcher: dispatcher,
 	}
 
+	// Apply optional configuration
+	for _, option := range options {
+		option(tracker)
+	}
+
+	for _, aggregate := range tracker.aggregates {
+		if aggregate.OnError == OnErrorQuarantine && tracker.quarantine == nil {
+			return nil, fmt.Errorf("aggregate '%s' quarantines rows but the checkpoint backend has no quarantine", aggregate.Name)
+		}
+	}
+	if err := CheckEnvironment(tracker.aggregates, tracker.env); err != nil {
+		return nil, err
+	}
+	for i := range tracker.aggregates {
+		if len(tracker.aggregates[i].PII) == 0 {
+			continue
+		}
+		if err := tracker.aggregates[i].Protect(tracker.protector); err != nil {
+			logger.Error("failed to prepare pii policies", "aggregate", tracker.aggregates[i].Name, "error", err)
+			return nil, err
+		}
+	}
+
+	// Renames are applied before registering, otherwise the new name would start at version 0
+	for _, aggregate := range tracker.aggregates {
+		if aggregate.RenamedFrom == "" {
+			continue
+		}
+		err = tracker.repository.RenameAggregate(ctx, aggregate.RenamedFrom, aggregate.Name)
+		if err != nil {
+			logger.Error("failed to rename aggregate", "from", aggregate.RenamedFrom, "to", aggregate.Name, "error", err)
+			return nil, fmt.Errorf("failed to rename aggregate: %w", err)
+		}
+	}
+
+	err = tracker.repository.RegisterAggregates(ctx, Names(tracker.aggregates))
+	if err != nil {
+		logger.Error("failed to save aggregates", "error", err)
+		return nil, fmt.Errorf("failed to save aggregates: %w", err)
+	}
+
+	logger.Info("tracker initialized", "aggregates_count", len(tracker.aggregates))
+	return tracker, nil
+}
+
+// LoadAggregates reads and validates the aggregates configuration file
+func LoadAggregates(aggregatesPath string) ([]Aggregate, error) {
+	yamlFile, err := os.ReadFile(aggregatesPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aggregates file: %w", err)
+	}
+
 	var config Config
 
 	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
@@ -79,25 +275,91 @@ func NewTracker(
 	err = decoder.Decode(&config)
 
 	if err != nil {
-		logger.Error("failed to unmarshal aggregates file", "path", aggregatesPath, "error", err)
 		return nil, fmt.Errorf("failed to unmarshal aggregates file: %w", err)
 	}
 
-	tracker.aggregates = config.Aggregates
+	names := make(map[string]bool, len(config.Aggregates))
+	for i, aggregate := range config.Aggregates {
+		if names[aggregate.Name] {
+			return nil, fmt.Errorf("duplicate aggregate name '%s'", aggregate.Name)
+		}
+		names[aggregate.Name] = true
+		if err := config.Aggregates[i].validateIsolation(); err != nil {
+			return nil, err
+		}
+		if err := aggregate.validateColumns(); err != nil {
+			return nil, err
+		}
+		if err := aggregate.validateDefaults(); err != nil {
+			return nil, err
+		}
+		if err := aggregate.validateOnError(); err != nil {
+			return nil, err
+		}
+		if err := aggregate.validatePII(); err != nil {
+			return nil, err
+		}
+		if err := config.Aggregates[i].compileFilter(); err != nil {
+			return nil, err
+		}
+		if err := aggregate.validateSplit(); err != nil {
+			return nil, err
+		}
+		if err := config.Aggregates[i].validateKey(); err != nil {
+			return nil, err
+		}
+		if err := config.Aggregates[i].compileTemplates(); err != nil {
+			return nil, err
+		}
+		if len(aggregate.Transform) > 0 {
+			pipeline, err := transform.New(aggregate.Transform)
+			if err != nil {
+				return nil, fmt.Errorf("aggregate '%s' has an invalid transform: %w", aggregate.Name, err)
+			}
+			config.Aggregates[i].pipeline = pipeline
+		}
+	}
+	renamed := make(map[string]bool)
+	for _, aggregate := range config.Aggregates {
+		if aggregate.RenamedFrom == "" {
+			continue
+		}
+		if names[aggregate.RenamedFrom] {
+			return nil, fmt.Errorf(
+				"aggregate '%s' is renamed from '%s' which is still configured", aggregate.Name, aggregate.RenamedFrom,
+			)
+		}
+		if renamed[aggregate.RenamedFrom] {
+			return nil, fmt.Errorf("aggregate '%s' is renamed more than once", aggregate.RenamedFrom)
+		}
+		renamed[aggregate.RenamedFrom] = true
+	}
+
+	return config.Aggregates, nil
+}
 
-	aggregateNames := make([]string, len(tracker.aggregates))
-	for i, aggregate := range tracker.aggregates {
-		aggregateNames[i] = aggregate.Name
+// Names returns the names of the aggregates
+func Names(aggregates []Aggregate) []string {
+	names := make([]string, len(aggregates))
+	for i, aggregate := range aggregates {
+		names[i] = aggregate.Name
 	}
+	return names
+}
 
-	err = tracker.repository.RegisterAggregates(ctx, aggregateNames)
-	if err != nil {
-		logger.Error("failed to save aggregates", "error", err)
-		return nil, fmt.Errorf("failed to save aggregates: %w", err)
+// aggregate returns the configured aggregate with the given name
+func (t *Tracker) aggregate(name string) (Aggregate, bool) {
+	for _, agg := range t.aggregates {
+		if agg.Name == name {
+			return agg, true
+		}
 	}
+	return Aggregate{}, false
+}
 
-	logger.Info("tracker initialized", "aggregates_count", len(tracker.aggregates))
-	return tracker, nil
+// Aggregates returns the aggregates loaded from the configuration
+func (t *Tracker) Aggregates() []Aggregate {
+	return t.aggregates
 }
 
 func (t *Tracker) Start(ctx context.Context) error {
@@ -143,109 +405,451 @@ func (t *Tracker) Start(ctx context.Context) error {
 			}
 		}(ctx, aggregate)
 	}
+
+	t.startLagMonitor(ctx)
 	return nil
 }
 
-func (t *Tracker) runErpCycle(ctx context.Context, agregateName, getQuery string) error {
+func (t *Tracker) runErpCycle(ctx context.Context, agregateName, getQuery string) (err error) {
+	lock := t.aggregateLock(agregateName)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, span := tracer.Start(ctx, "erp.cycle", trace.WithAttributes(attribute.String("aggregate", agregateName)))
+	defer span.End()
+
+	cycle := CycleRecord{Start: time.Now()}
+	defer func() {
+		span.SetAttributes(
+			attribute.Int64("change_version.from", cycle.FromVersion),
+			attribute.Int64("change_version.to", cycle.ToVersion),
+			attribute.Int("rows_fetched", cycle.RowsFetched),
+		)
+		telemetry.RecordError(span, err)
+		t.recordCycle(ctx, agregateName, cycle, err)
+	}()
+
 	lastVersion, err := t.repository.GetChangeVersion(ctx, agregateName)
 	if err != nil {
 		t.logger.Error("failed to get last change version", "aggregate", agregateName, "error", err)
 		return fmt.Errorf("failed to get last change version: %w", err)
 	}
+	cycle.FromVersion = lastVersion
+	cycle.ToVersion = lastVersion
 
-	count, version, err := t.fetchErpChanges(ctx, agregateName, getQuery, lastVersion)
+	agg, _ := t.aggregate(agregateName)
+	q, endRead, err := t.beginRead(ctx, agg)
+	if err != nil {
+		t.logger.Error("failed to begin ERP cycle", "aggregate", agregateName, "error", err)
+		return fmt.Errorf("failed to begin ERP cycle: %w", err)
+	}
+	defer endRead()
+
+	// the current version is read first, in a transaction it is the version of the snapshot and
+	// as upper bound no change committed before the query is skipped
+	upperBound := lastVersion
+	var boundArgs []any
+	if _, inTx := q.(*sql.Tx); inTx || agg.AdvanceToCurrent {
+		current, err := currentVersion(ctx, q)
+		if err != nil {
+			t.logger.Error("failed to read current change version", "aggregate", agregateName, "error", err)
+			return fmt.Errorf("failed to read current change version: %w", err)
+		}
+		span.SetAttributes(attribute.Int64("change_version.current", current))
+		if agg.AdvanceToCurrent {
+			upperBound = max(current, lastVersion)
+			boundArgs = append(boundArgs, sql.Named("to_version", current))
+		}
+	}
+
+	getQuery = agg.filterColumns(getQuery)
+	if t.committer != nil {
+		return t.runCommittedErpCycle(ctx, q, endRead, agregateName, getQuery, &cycle, upperBound, boundArgs)
+	}
+
+	count, skipped, maxVersion, err := t.fetchErpChanges(ctx, q, endRead, agregateName, getQuery, lastVersion, boundArgs...)
 	if err != nil {
 		t.logger.Error("failed to fetch ERP changes", "aggregate", agregateName, "error", err)
 		return fmt.Errorf("failed to fetch ERP changes: %w", err)
 	}
-	if count == 0 {
+	cycle.RowsFetched = count
+	cycle.RowsSkipped = skipped
+	version := max(maxVersion, upperBound)
+	if count == 0 && version == lastVersion {
 		t.logger.Info("no changes found for aggregate", "name", agregateName)
 		return nil
 	}
+	if count == 0 {
+		t.logger.Info("no matching changes, advancing to the current version", "name", agregateName, "version", version)
+	}
 
 	err = t.repository.UpdateChangeVersion(ctx, agregateName, version)
 	if err != nil {
 		t.logger.Error("failed to update change version", "aggregate", agregateName, "error", err)
 		return fmt.Errorf("failed to update change version: %w", err)
 	}
+	cycle.ToVersion = version
 
 	t.logger.Info(
 		"ERP cycle completed",
 		"aggregate", agregateName,
 		"change version", lastVersion,
 		"records fetched", count,
+		"records skipped", skipped,
 		"updated change version", version,
 	)
 
 	return nil
 }
 
-func (t *Tracker) fetchErpChanges(ctx context.Context, name, query string, version int64) (int, int64, error) {
+// runCommittedErpCycle collects the changes of a cycle and commits them together with the new
+// change version, the committed events are dispatched afterwards. The read transaction ends
+// before the commit.
+func (t *Tracker) runCommittedErpCycle(
+	ctx context.Context, q queryer, endRead func(), agregateName, getQuery string, cycle *CycleRecord,
+	upperBound int64, boundArgs []any,
+) error {
+	lastVersion := cycle.FromVersion
+	var jobs []dispatcher.Job
+	// quarantined rows are committed with the cycle, so a retried cycle does not store them twice
+	var quarantined []QuarantinedRow
+	count, skipped, maxVersion, err := t.scanErpChanges(ctx, q, agregateName, getQuery, lastVersion, func(job dispatcher.Job) error {
+		jobs = append(jobs, job)
+		return nil
+	}, func(row QuarantinedRow) error {
+		quarantined = append(quarantined, row)
+		return nil
+	}, boundArgs...)
+	endRead()
+	if err != nil {
+		t.logger.Error("failed to fetch ERP changes", "aggregate", agregateName, "error", err)
+		return fmt.Errorf("failed to fetch ERP changes: %w", err)
+	}
+	cycle.RowsFetched = count
+	cycle.RowsSkipped = skipped
+	version := max(maxVersion, upperBound)
+	if count == 0 && version == lastVersion {
+		t.logger.Info("no changes found for aggregate", "name", agregateName)
+		return nil
+	}
+	if count == 0 {
+		t.logger.Info("no matching changes, advancing to the current version", "name", agregateName, "version", version)
+	}
+
+	envelopes := make([]*messaging.EventEnvelope, len(jobs))
+	for i, job := range jobs {
+		envelopes[i] = job.EventEnvelope
+	}
+	err = t.committer.CommitCycle(ctx, agregateName, envelopes, quarantined, lastVersion, version)
+	if err != nil {
+		t.logger.Error("failed to commit ERP cycle", "aggregate", agregateName, "error", err)
+		return fmt.Errorf("failed to commit ERP cycle: %w", err)
+	}
+	cycle.ToVersion = version
+
+	for _, job := range jobs {
+		t.dispatcher.Dispatch(job)
+	}
+
+	t.logger.Info(
+		"ERP cycle committed",
+		"aggregate", agregateName,
+		"change version", lastVersion,
+		"records fetched", count,
+		"records skipped", skipped,
+		"updated change version", version,
+	)
+
+	return nil
+}
+
+// recordCycle stores the outcome of the cycle, a failure to record does not fail the cycle
+func (t *Tracker) recordCycle(ctx context.Context, aggregateName string, cycle CycleRecord, cycleErr error) {
+	cycle.End = time.Now()
+	if cycleErr != nil {
+		cycle.Error = cycleErr.Error()
+	}
+	metrics.ObserveCycle(aggregateName, cycle.End.Sub(cycle.Start), cycle.RowsFetched, cycle.ToVersion, cycleErr)
+	metrics.RowsSkipped.WithLabelValues(aggregateName).Add(float64(cycle.RowsSkipped))
+
+	if err := t.repository.RecordCycle(ctx, aggregateName, cycle); err != nil {
+		t.logger.Warn("failed to record ERP cycle", "aggregate", aggregateName, "error", err)
+	}
+}
+
+// fetchErpChanges collects the jobs of the changes, ends the read transaction and dispatches the
+// jobs. A dispatch blocked by a full queue would otherwise keep the snapshot of the transaction
+// open and its rows in the version store of SQL Server, the jobs of a cycle are held in memory
+// instead.
+func (t *Tracker) fetchErpChanges(
+	ctx context.Context, q queryer, endRead func(), name, query string, version int64, args ...any,
+) (int, int, int64, error) {
+	var jobs []dispatcher.Job
+	count, skipped, maxVersion, err := t.scanErpChanges(ctx, q, name, query, version, func(job dispatcher.Job) error {
+		jobs = append(jobs, job)
+		return nil
+	}, nil, args...)
+	endRead()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, job := range jobs {
+		t.dispatcher.Dispatch(job)
+	}
+	return count, skipped, maxVersion, nil
+}
+
+// scanErpChanges runs the aggregate query on q and hands a job for every change to handle, it returns
+// the number of changes, the number of changes that were not handed to handle and the highest
+// change version seen. Rows quarantined by the error policy are handed to quarantine, a nil
+// quarantine stores them in the quarantine of the tracker right away. The args are passed to the
+// query after @version.
+func (t *Tracker) scanErpChanges(
+	ctx context.Context, q queryer, name, query string, version int64, handle func(dispatcher.Job) error,
+	quarantine func(QuarantinedRow) error, args ...any,
+) (counter int, skipped int, maxVersion int64, err error) {
+	ctx, span := tracer.Start(ctx, "erp.fetch", trace.WithAttributes(
+		attribute.String("aggregate", name),
+		attribute.String("db.system", "mssql"),
+	))
+	defer func() {
+		span.SetAttributes(attribute.Int("rows_fetched", counter), attribute.Int("rows_skipped", skipped))
+		telemetry.RecordError(span, err)
+		span.End()
+	}()
+
 	// TODO: limit the number of records that can be returned, but do not cross the version boundary
 	// version represenets the transaction in the erp system, if we set up blind limit to the select
 	// statement we can crate a gap as one cycle will be limited to fetch only a part of the version
 	// changes, update the version to the maxVersion and the next cycle will start from the Next
 	// version
-	rows, err := t.db.QueryContext(ctx, query, sql.Named("version", version))
+	rows, err := q.QueryContext(ctx, query, append([]any{sql.Named("version", version)}, args...)...)
 	if err != nil {
 		t.logger.Error("failed to execute query", "query", query, "error", err)
-		return 0, 0, fmt.Errorf("query execution failed for query '%s': %w", query, err)
+		return 0, 0, 0, fmt.Errorf("query execution failed for query '%s': %w", query, err)
 	}
 	defer rows.Close()
 
-	var counter int
-	var maxVersion int64 = version
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to read query columns: %w", err)
+	}
+	agg, _ := t.aggregate(name)
+	mapper, err := newRowMapper(columns, agg.Defaults, agg.Key)
+	if err != nil {
+		t.logger.Error("failed to map query columns", "query", query, "error", err)
+		return 0, 0, 0, fmt.Errorf("failed to map query columns: %w", err)
+	}
+
+	// emit hands the job of a change to handle, the error policy of the aggregate decides
+	// whether a change that is not a valid event fails the cycle
+	emit := func(event ChangeEvent, values []any, err error) error {
+		var jobs []dispatcher.Job
+		if err == nil {
+			jobs, err = agg.buildJobs(ctx, event, t.env)
+		}
+		if err != nil {
+			if err := t.rejectRow(ctx, agg, mapper.values(values), event.ChangeVersion, err, quarantine); err != nil {
+				t.logger.Error("failed to dispatch ERP change", "aggregate", name, "key", event.AggregateKey,
+					"version", event.ChangeVersion, "error", err)
+				return err
+			}
+			skipped++
+			return nil
+		}
+		if len(jobs) == 0 {
+			t.logger.Debug("skipping change without events to publish", "aggregate", name, "key", event.AggregateKey,
+				"version", event.ChangeVersion)
+			skipped++
+			return nil
+		}
+		for _, job := range jobs {
+			if err := handle(job); err != nil {
+				t.logger.Error("failed to dispatch ERP change", "aggregate", name, "key", event.AggregateKey,
+					"version", event.ChangeVersion, "error", err)
+				return fmt.Errorf("failed to dispatch ERP change: %w", err)
+			}
+		}
+		return nil
+	}
+
+	var pending *coalescer
+	if agg.Coalesce {
+		pending = newCoalescer()
+	}
+
+	maxVersion = version
 	for rows.Next() {
-		var event ChangeEvent
-		if err := rows.Scan(
-			&event.ChangeOperation,
-			&event.ChangeVersion,
-			&event.AggregateKey,
-			&event.Payload,
-		); err != nil {
+		values, err := mapper.read(rows)
+		if err != nil {
 			t.logger.Error("failed to scan row", "error", err)
-			return 0, 0, fmt.Errorf("row scan failed: %w", err)
+			return 0, 0, 0, err
 		}
+		counter++
+		event, relevant, err := mapper.event(values)
 		if event.ChangeVersion > maxVersion {
 			maxVersion = event.ChangeVersion
 		}
-		// dispatch the change event
-		err := t.dispatchErpChange(event, name)
-		if err != nil {
-			t.logger.Error("failed to dispatch ERP change", "event", event, "error", err)
-			return 0, 0, fmt.Errorf("failed to dispatch ERP change: %w", err)
+		if err == nil && !relevant {
+			skipped++
+			continue
+		}
+		if err == nil && event.NullPayload && !isDelete(event.ChangeOperation) {
+			t.logger.Warn("skipping change without payload", "aggregate", name, "key", event.AggregateKey,
+				"operation", event.ChangeOperation, "version", event.ChangeVersion)
+			skipped++
+			continue
+		}
+		if err == nil && pending != nil {
+			pending.add(event, values)
+			continue
+		}
+		if err := emit(event, values, err); err != nil {
+			return 0, 0, 0, err
 		}
-		counter++
 	}
 	if err := rows.Err(); err != nil {
 		t.logger.Error("error encountered during row iteration", "error", err)
-		return 0, 0, fmt.Errorf("row iteration error: %w", err)
+		return 0, 0, 0, fmt.Errorf("row iteration error: %w", err)
 	}
 
-	return counter, maxVersion, nil
+	changes, coalesced := pending.changes()
+	skipped += coalesced
+	for _, change := range changes {
+		if err := emit(change.event, change.values, nil); err != nil {
+			return 0, 0, 0, err
+		}
+	}
+
+	return counter, skipped, maxVersion, nil
+}
+
+func (t *Tracker) dispatchErpChange(ctx context.Context, event ChangeEvent, agggergateName string) error {
+	agg, ok := t.aggregate(agggergateName)
+	if !ok {
+		agg = Aggregate{Name: agggergateName}
+	}
+	eventType, subject, err := agg.eventNames(event, t.env)
+	if err != nil {
+		return err
+	}
+	job, err := buildErpJob(ctx, event, eventType, subject)
+	if err != nil {
+		return err
+	}
+
+	t.dispatcher.Dispatch(job)
+	return nil
+}
+
+// buildJobs turns a change into the jobs of its events: the payload is transformed, protected
+// and split, and only the events matching the filter are kept. The filter sees the values
+// before the PII policies are applied.
+func (a Aggregate) buildJobs(ctx context.Context, event ChangeEvent, env string) ([]dispatcher.Job, error) {
+	event, err := a.transformPayload(event)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := a.splitEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	event, err = a.protectEvent(event)
+	if err != nil {
+		return nil, err
+	}
+	events, err := a.splitEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]dispatcher.Job, 0, len(events))
+	for i, event := range events {
+		eventType, subject, err := a.eventNames(event, env)
+		if err != nil {
+			return nil, err
+		}
+		job, err := buildErpJob(ctx, event, eventType, subject)
+		if err != nil {
+			return nil, err
+		}
+		// policies only replace values, so the split of both payloads has the same elements
+		matched, err := a.matchFilter(plain[i], job.EventEnvelope)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs, nil
+}
+
+// transformPayload applies the transform of the aggregate to the payload of the event, a
+// tombstone has no payload to transform
+func (a Aggregate) transformPayload(event ChangeEvent) (ChangeEvent, error) {
+	if a.pipeline == nil || event.NullPayload {
+		return event, nil
+	}
+	payload, err := a.pipeline.Apply([]byte(event.Payload))
+	if err != nil {
+		return event, fmt.Errorf("failed to transform payload: %w", err)
+	}
+	event.Payload = string(payload)
+	return event, nil
+}
+
+// envelopeMetadata returns the metadata of the envelope of the event. The change context is kept
+// next to the other source values, the causation ID of the envelope refers to the event that
+// caused this one.
+func envelopeMetadata(event ChangeEvent) map[string]any {
+	if event.ChangeContext == "" {
+		return event.Metadata
+	}
+	metadata := make(map[string]any, len(event.Metadata)+1)
+	maps.Copy(metadata, event.Metadata)
+	metadata[changeContextMetadata] = event.ChangeContext
+	return metadata
 }
 
-func (t *Tracker) dispatchErpChange(event ChangeEvent, agggergateName string) error {
-	eventType := fmt.Sprintf("erp.%s.%s", agggergateName, event.ChangeOperation)
-	eventChannel := fmt.Sprintf("erp.%s", agggergateName)
+// buildErpJob wraps the change event in a validated envelope of the event type routed to the
+// channel, the envelope carries the trace context of ctx
+func buildErpJob(ctx context.Context, event ChangeEvent, eventType string, eventChannel string) (dispatcher.Job, error) {
+	options := []messaging.EnvelopeOption{messaging.WithTraceContext(ctx)}
+	if metadata := envelopeMetadata(event); len(metadata) > 0 {
+		options = append(options, messaging.WithMetadata(metadata))
+	}
+	if event.CommitTime != nil {
+		options = append(options, messaging.WithSourceTimestamp(*event.CommitTime))
+	}
+	if event.UserID != "" {
+		options = append(options, messaging.WithUserID(event.UserID))
+	}
+	if event.CorrelationID != "" {
+		options = append(options, messaging.WithCorrelationID(event.CorrelationID))
+	}
+
+	// a delete without payload is published as a tombstone with a JSON null payload
+	var payload any = event.Payload
+	if event.NullPayload {
+		payload = json.RawMessage("null")
+	}
 
 	envelope := messaging.NewEventEnvelope(
 		eventType,
 		event.AggregateKey,
 		event.ChangeVersion,
-		event.Payload,
+		payload,
+		options...,
 	)
 
 	err := envelope.Validate()
 	if err != nil {
-		return fmt.Errorf("invalid event envelope: %w", err)
+		return dispatcher.Job{}, fmt.Errorf("invalid event envelope: %w", err)
 	}
 
-	job := dispatcher.Job{
+	return dispatcher.Job{
 		EventChannel:  eventChannel,
 		EventEnvelope: envelope,
-	}
-
-	t.dispatcher.Dispatch(job)
-	return nil
+	}, nil
 }
@@ -1,18 +1,23 @@
 package tracker
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
 	"os"
 	"regexp"
 	"runtime"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/salesworks/s-works/slx/internal/dispatcher"
 	"github.com/salesworks/s-works/slx/internal/messaging"
+	"github.com/salesworks/s-works/slx/pkg/pii"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -42,6 +47,9 @@ type mockTrackerRepository struct {
 	RegisterAggregatesCalled  bool
 	GetChangeVersionCalled    bool
 	UpdateChangeVersionCalled bool
+	RecordCycleCalled         bool
+	RecordedCycle             CycleRecord
+	Renames                   map[string]string
 	errToReturn               error
 }
 
@@ -73,6 +81,67 @@ func (m *mockTrackerRepository) UpdateChangeVersion(
 	return nil
 }
 
+type mockCycleCommitter struct {
+	envelopes   []*messaging.EventEnvelope
+	quarantined []QuarantinedRow
+	fromVersion int64
+	version     int64
+	errToReturn error
+}
+
+func (m *mockCycleCommitter) CommitCycle(
+	ctx context.Context, aggregateName string, envelopes []*messaging.EventEnvelope,
+	quarantined []QuarantinedRow, fromVersion int64, newVersion int64,
+) error {
+	if m.errToReturn != nil {
+		return m.errToReturn
+	}
+	m.envelopes = envelopes
+	m.quarantined = quarantined
+	m.fromVersion = fromVersion
+	m.version = newVersion
+	return nil
+}
+
+func (m *mockTrackerRepository) RecordCycle(
+	ctx context.Context, aggregateName string, cycle CycleRecord,
+) error {
+	m.RecordCycleCalled = true
+	m.RecordedCycle = cycle
+	return m.errToReturn
+}
+
+func (m *mockTrackerRepository) GetCheckpoint(
+	ctx context.Context, aggregateName string,
+) (Checkpoint, error) {
+	if m.errToReturn != nil {
+		return Checkpoint{}, m.errToReturn
+	}
+	return Checkpoint{Aggregate: aggregateName, ChangeVersion: 1}, nil
+}
+
+func (m *mockTrackerRepository) CycleHistory(
+	ctx context.Context, aggregateName string, limit int,
+) ([]CycleRecord, error) {
+	return nil, m.errToReturn
+}
+
+func (m *mockTrackerRepository) RenameAggregate(
+	ctx context.Context, oldName string, newName string,
+) error {
+	if m.errToReturn != nil {
+		return m.errToReturn
+	}
+	if m.RegisterAggregatesCalled {
+		return errors.New("rename after register")
+	}
+	if m.Renames == nil {
+		m.Renames = make(map[string]string)
+	}
+	m.Renames[oldName] = newName
+	return nil
+}
+
 func TestTracker_NewTracker_HappyPath(t *testing.T) {
 	// --- Arrange ---
 	db, _, err := sqlmock.New()
@@ -168,6 +237,82 @@ func TestTracker_NewTracker_InvalidFile(t *testing.T) {
 	assert.Error(t, err, "expected an error due to invalid YAML structure")
 }
 
+func TestTracker_NewTracker_RenamedAggregate(t *testing.T) {
+	// --- Arrange ---
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	trackerRepo := &mockTrackerRepository{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	testFile := t.TempDir() + "/aggregates.yaml"
+	testContent := `aggregates:
+  - name: "customer"
+    renamed_from: "contractor"
+    interval: 30
+    get_query: "SELECT 1"
+`
+	require.NoError(t, os.WriteFile(testFile, []byte(testContent), 0644))
+
+	// --- Act ---
+	dispatcher := dispatcher.NewDispatcher(1, 10, &mockPublisher{}, logger)
+	tracker, err := NewTracker(context.Background(), testFile, trackerRepo, logger, db, dispatcher)
+
+	// --- Assert ---
+	require.NoError(t, err, "NewTracker should not return an error")
+	assert.NotNil(t, tracker)
+	assert.Equal(t, map[string]string{"contractor": "customer"}, trackerRepo.Renames,
+		"the checkpoint should move before the new name is registered")
+	assert.True(t, trackerRepo.RegisterAggregatesCalled)
+}
+
+func TestLoadAggregates_InvalidRenames(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{
+			name: "duplicate name",
+			content: `aggregates:
+  - name: "customer"
+  - name: "customer"
+`,
+		},
+		{
+			name: "renamed from a configured aggregate",
+			content: `aggregates:
+  - name: "customer"
+    renamed_from: "contractor"
+  - name: "contractor"
+`,
+		},
+		{
+			name: "renamed twice",
+			content: `aggregates:
+  - name: "customer"
+    renamed_from: "contractor"
+  - name: "client"
+    renamed_from: "contractor"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			testFile := t.TempDir() + "/aggregates.yaml"
+			require.NoError(t, os.WriteFile(testFile, []byte(tt.content), 0644))
+
+			// --- Act ---
+			aggregates, err := LoadAggregates(testFile)
+
+			// --- Assert ---
+			assert.Error(t, err)
+			assert.Nil(t, aggregates)
+		})
+	}
+}
+
 func TestTracker_Start_TrackErpChanges(t *testing.T) {
 	// --- Arrange ---
 	db, _, err := sqlmock.New()
@@ -202,6 +347,8 @@ func TestTracker_Start_TrackErpChanges(t *testing.T) {
 	}
 
 	// --- Act ---
+	// let goroutines left by previous tests finish so they do not skew the count
+	waitForStableGoroutines()
 	initialCount := runtime.NumGoroutine()
 	err = tracker.Start(ctx)
 	require.NoError(t, err, "Start should not return an error")
@@ -219,6 +366,19 @@ func TestTracker_Start_TrackErpChanges(t *testing.T) {
 	)
 }
 
+// waitForStableGoroutines waits until the number of goroutines stops changing
+func waitForStableGoroutines() {
+	previous := runtime.NumGoroutine()
+	for i := 0; i < 50; i++ {
+		time.Sleep(10 * time.Millisecond)
+		current := runtime.NumGoroutine()
+		if current == previous {
+			return
+		}
+		previous = current
+	}
+}
+
 func TestTracker_RunErpCycle(t *testing.T) {
 	// --- Arrange ---
 	publisher := &mockPublisher{}
@@ -256,6 +416,91 @@ func TestTracker_RunErpCycle(t *testing.T) {
 	// --- Assert ---
 	assert.True(t, trackerRepo.GetChangeVersionCalled, "GetChangeVersion should be called")
 	assert.True(t, trackerRepo.UpdateChangeVersionCalled, "UpdateChangeVersion should not be called")
+	assert.True(t, trackerRepo.RecordCycleCalled, "RecordCycle should be called")
+	assert.Equal(t, int64(1), trackerRepo.RecordedCycle.FromVersion, "cycle should start at the stored version")
+	assert.Equal(t, 3, trackerRepo.RecordedCycle.RowsFetched, "cycle should record the fetched rows")
+	assert.Empty(t, trackerRepo.RecordedCycle.Error, "cycle should not record an error")
+}
+
+func TestTracker_RunErpCycle_WithCycleCommitter(t *testing.T) {
+	// --- Arrange ---
+	publisher := &mockPublisher{}
+	trackerRepo := &mockTrackerRepository{}
+	committer := &mockCycleCommitter{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+
+	dispatcher := dispatcher.NewDispatcher(1, 10, publisher, logger)
+	tracker := &Tracker{
+		aggregates: []Aggregate{{Name: "fabric"}},
+		repository: trackerRepo,
+		logger:     logger,
+		db:         db,
+		dispatcher: dispatcher,
+		committer:  committer,
+	}
+	query := "SELECT * FROM changes WHERE version > @version"
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+			AddRow("I", 2, "C4CA4238A0B923820DCC509A6F75849A", `{}`).
+			AddRow("U", 3, "C4CA4238A0B923820DCC509A6F75849B", `{}`),
+	)
+
+	// --- Act ---
+	err = tracker.runErpCycle(ctx, "fabric", query)
+	require.NoError(t, err, "runErpCycle should not return an error")
+
+	// --- Assert ---
+	assert.Len(t, committer.envelopes, 2, "both events should be committed")
+	assert.Equal(t, int64(3), committer.version, "the highest change version should be committed")
+	assert.Equal(t, trackerRepo.RecordedCycle.FromVersion, committer.fromVersion,
+		"the commit should only advance from the version the cycle read from")
+	assert.False(t, trackerRepo.UpdateChangeVersionCalled, "the committer owns the change version")
+	assert.Equal(t, int64(3), trackerRepo.RecordedCycle.ToVersion, "cycle should record the committed version")
+}
+
+func TestTracker_RunErpCycle_WithCycleCommitter_Failure(t *testing.T) {
+	// --- Arrange ---
+	publisher := &mockPublisher{}
+	trackerRepo := &mockTrackerRepository{}
+	committer := &mockCycleCommitter{errToReturn: errors.New("commit failed")}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	dispatcher := dispatcher.NewDispatcher(1, 10, publisher, logger)
+	tracker := &Tracker{
+		repository: trackerRepo,
+		logger:     logger,
+		db:         db,
+		dispatcher: dispatcher,
+		committer:  committer,
+	}
+	query := "SELECT * FROM changes WHERE version > @version"
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+			AddRow("I", 2, "C4CA4238A0B923820DCC509A6F75849A", `{}`),
+	)
+
+	// --- Act ---
+	dispatcher.Start()
+	err = tracker.runErpCycle(context.Background(), "fabric", query)
+	dispatcher.Stop()
+
+	// --- Assert ---
+	require.Error(t, err)
+	assert.False(t, publisher.PublishCalled, "nothing should be published when the commit fails")
+	assert.Contains(t, trackerRepo.RecordedCycle.Error, "commit failed", "cycle should record the error")
 }
 
 func TestTracker_FetchErpChanges(t *testing.T) {
@@ -292,7 +537,7 @@ func TestTracker_FetchErpChanges(t *testing.T) {
 	defer cancel()
 
 	// --- Act ---
-	counter, ver, err := tracker.fetchErpChanges(ctx, tracker.aggregates[0].Name, query, version)
+	counter, _, ver, err := tracker.fetchErpChanges(ctx, tracker.db, func() {}, tracker.aggregates[0].Name, query, version)
 	require.NoError(t, err, "runErpCycle should start without error")
 
 	// --- Assert ---
@@ -324,10 +569,944 @@ func TestTracker_ErpDispatcher(t *testing.T) {
 	corruptedEvent := ChangeEvent{}
 
 	// --- Act & Assert ---
-	err := tracker.dispatchErpChange(correctEvent, "test")
+	err := tracker.dispatchErpChange(context.Background(), correctEvent, "test")
 	require.NoError(t, err)
 
-	err = tracker.dispatchErpChange(corruptedEvent, "test")
+	err = tracker.dispatchErpChange(context.Background(), corruptedEvent, "test")
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "invalid event envelope")
 }
+
+func TestTracker_SetChangeVersion(t *testing.T) {
+	// --- Arrange ---
+	trackerRepo := &mockTrackerRepository{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	tracker := &Tracker{
+		aggregates: []Aggregate{{Name: "customer", Interval: 30}},
+		repository: trackerRepo,
+		logger:     logger,
+	}
+
+	// --- Act ---
+	err := tracker.SetChangeVersion(context.Background(), "customer", 5)
+	unknownErr := tracker.SetChangeVersion(context.Background(), "missing", 5)
+
+	// --- Assert ---
+	require.NoError(t, err, "SetChangeVersion should not return an error")
+	assert.True(t, trackerRepo.UpdateChangeVersionCalled)
+	assert.ErrorIs(t, unknownErr, ErrAggregateNotConfigured)
+
+	statuses, err := tracker.Status(context.Background())
+	require.NoError(t, err, "Status should not return an error")
+	require.Len(t, statuses, 1)
+	assert.Equal(t, "customer", statuses[0].Aggregate)
+	assert.Equal(t, 30, statuses[0].Interval)
+}
+
+func TestAggregate_TrackedTables(t *testing.T) {
+	// --- Arrange ---
+	agg := Aggregate{GetQuery: `
+		SELECT * FROM CHANGETABLE(CHANGES CDN.ZamNag, @version) AS c
+		UNION ALL
+		SELECT * FROM CHANGETABLE (CHANGES CDN.ZamElem , @version) AS e
+		UNION ALL
+		SELECT * FROM changetable(changes cdn.zamnag, @version) AS d`}
+
+	// --- Act ---
+	tables := agg.TrackedTables()
+	configured := Aggregate{GetQuery: agg.GetQuery, Tables: []string{"CDN.TwrKarty"}}.TrackedTables()
+
+	// --- Assert ---
+	assert.Equal(t, []string{"CDN.ZamNag", "CDN.ZamElem"}, tables)
+	assert.Equal(t, []string{"CDN.TwrKarty"}, configured, "configured tables should win over the query")
+}
+
+func TestTracker_CheckLag(t *testing.T) {
+	tests := []struct {
+		name        string
+		minValid    int64
+		wantAtRisk  bool
+		wantExpired bool
+	}{
+		{name: "inside the window", minValid: 0, wantAtRisk: false},
+		{name: "close to retention", minValid: 1, wantAtRisk: true},
+		{name: "behind retention", minValid: 10, wantAtRisk: true, wantExpired: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+			// the mock repository keeps every aggregate at version 1
+			tracker := &Tracker{
+				aggregates: []Aggregate{
+					{Name: "customer", GetQuery: "SELECT * FROM CHANGETABLE(CHANGES CDN.KntKarty, @version) AS c"},
+				},
+				repository:   &mockTrackerRepository{},
+				logger:       logger,
+				db:           db,
+				lagWarnRatio: 0.2,
+			}
+
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT CHANGE_TRACKING_CURRENT_VERSION()")).
+				WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT CHANGE_TRACKING_MIN_VALID_VERSION")).
+				WithArgs(sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(tt.minValid))
+
+			// --- Act ---
+			tracker.checkLag(context.Background(), tracker.aggregates)
+
+			// --- Assert ---
+			assert.NoError(t, mock.ExpectationsWereMet())
+			lag := tracker.lag("customer")
+			require.NotNil(t, lag, "the lag should be stored")
+			assert.Empty(t, lag.Error)
+			assert.Equal(t, int64(4), lag.Versions)
+			assert.Equal(t, tt.minValid, lag.MinValidVersion)
+			assert.Equal(t, tt.wantAtRisk, lag.AtRisk)
+			assert.Equal(t, tt.wantExpired, lag.Expired)
+		})
+	}
+}
+
+func TestTracker_LagAggregates(t *testing.T) {
+	// --- Arrange ---
+	var logs bytes.Buffer
+	tracker := &Tracker{
+		aggregates: []Aggregate{
+			{Name: "customer", GetQuery: "SELECT * FROM CHANGETABLE(CHANGES CDN.KntKarty, @version) AS c"},
+			{Name: "stock", GetQuery: "SELECT * FROM stock_changes WHERE version > @version"},
+			{Name: "item", GetQuery: "SELECT * FROM item_changes WHERE version > @version", Tables: []string{"CDN.TwrKarty"}},
+		},
+		logger: slog.New(slog.NewTextHandler(&logs, nil)),
+	}
+
+	// --- Act ---
+	aggregates := tracker.lagAggregates()
+
+	// --- Assert ---
+	assert.Equal(t, []string{"customer", "item"}, Names(aggregates))
+	assert.Equal(t, 1, strings.Count(logs.String(), "no tracked tables found"))
+	assert.Contains(t, logs.String(), "aggregate=stock")
+}
+
+func TestTracker_RunErpCycle_AdvanceToCurrent(t *testing.T) {
+	// --- Arrange ---
+	trackerRepo := &mockTrackerRepository{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{
+		aggregates: []Aggregate{{Name: "customer", AdvanceToCurrent: true}},
+		repository: trackerRepo,
+		logger:     logger,
+		db:         db,
+		dispatcher: dispatcher.NewDispatcher(1, 10, &mockPublisher{}, logger),
+	}
+	query := "SELECT * FROM changes WHERE version > @version AND version <= @to_version"
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT CHANGE_TRACKING_CURRENT_VERSION()")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(40))
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}),
+	)
+
+	// --- Act ---
+	err = tracker.runErpCycle(context.Background(), "customer", query)
+
+	// --- Assert ---
+	require.NoError(t, err, "runErpCycle should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet(), "the query should receive @to_version")
+	assert.True(t, trackerRepo.UpdateChangeVersionCalled, "the checkpoint should advance without matching rows")
+	assert.Equal(t, int64(40), trackerRepo.RecordedCycle.ToVersion)
+	assert.Equal(t, 0, trackerRepo.RecordedCycle.RowsFetched)
+}
+
+func TestLoadAggregates_Isolation(t *testing.T) {
+	// --- Arrange ---
+	testFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(testFile, []byte(`aggregates:
+  - name: "customer"
+  - name: "fabric"
+    isolation: read_committed
+`), 0644))
+	invalidFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(invalidFile, []byte(`aggregates:
+  - name: "customer"
+    isolation: chaos
+`), 0644))
+
+	// --- Act ---
+	aggregates, err := LoadAggregates(testFile)
+	_, invalidErr := LoadAggregates(invalidFile)
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, IsolationSnapshot, aggregates[0].Isolation, "isolation should default to snapshot")
+	assert.Equal(t, "read_committed", aggregates[1].Isolation)
+	assert.ErrorContains(t, invalidErr, "unknown isolation 'chaos'")
+}
+
+func TestTracker_RunErpCycle_SnapshotIsolation(t *testing.T) {
+	// --- Arrange ---
+	trackerRepo := &mockTrackerRepository{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{
+		aggregates: []Aggregate{{Name: "customer", Isolation: IsolationSnapshot}},
+		repository: trackerRepo,
+		logger:     logger,
+		db:         db,
+		dispatcher: dispatcher.NewDispatcher(1, 10, &mockPublisher{}, logger),
+	}
+	query := "SELECT * FROM changes WHERE version > @version"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT CHANGE_TRACKING_CURRENT_VERSION()")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(40))
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+			AddRow("U", 12, "C4CA4238A0B923820DCC509A6F75849B", `{}`),
+	)
+	mock.ExpectRollback()
+
+	// --- Act ---
+	err = tracker.runErpCycle(context.Background(), "customer", query)
+
+	// --- Assert ---
+	require.NoError(t, err, "runErpCycle should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet(), "the cycle should read inside one transaction")
+	assert.Equal(t, int64(12), trackerRepo.RecordedCycle.ToVersion, "the version should not advance past the rows")
+}
+
+func TestTracker_RunErpCycle_EndsReadBeforeDispatch(t *testing.T) {
+	// --- Arrange ---
+	trackerRepo := &mockTrackerRepository{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	// the queue holds one job and has no workers yet, so the second dispatch blocks
+	disp := dispatcher.NewDispatcher(1, 1, &mockPublisher{}, logger)
+	tracker := &Tracker{
+		aggregates: []Aggregate{{Name: "customer", Isolation: IsolationSnapshot}},
+		repository: trackerRepo,
+		logger:     logger,
+		db:         db,
+		dispatcher: disp,
+	}
+	query := "SELECT * FROM changes WHERE version > @version"
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT CHANGE_TRACKING_CURRENT_VERSION()")).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(40))
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+			AddRow("U", 12, "C4CA4238A0B923820DCC509A6F75849B", `{}`).
+			AddRow("U", 13, "C4CA4238A0B923820DCC509A6F75849C", `{}`),
+	)
+	mock.ExpectRollback()
+
+	// --- Act ---
+	done := make(chan error, 1)
+	go func() {
+		done <- tracker.runErpCycle(context.Background(), "customer", query)
+	}()
+
+	// --- Assert ---
+	assert.Eventually(t, func() bool { return mock.ExpectationsWereMet() == nil }, time.Second, 10*time.Millisecond,
+		"the read transaction should end while the dispatch is blocked")
+	select {
+	case <-done:
+		t.Fatal("the cycle should wait for the full queue")
+	default:
+	}
+	disp.Start()
+	defer disp.Stop()
+	require.NoError(t, <-done)
+	assert.Equal(t, int64(13), trackerRepo.RecordedCycle.ToVersion)
+}
+
+func TestTracker_CheckSnapshotIsolation(t *testing.T) {
+	tests := []struct {
+		name       string
+		isolation  string
+		state      int
+		expectRead bool
+		wantErr    bool
+	}{
+		{name: "allowed", isolation: IsolationSnapshot, state: 1, expectRead: true},
+		{name: "not allowed", isolation: IsolationSnapshot, state: 0, expectRead: true, wantErr: true},
+		{name: "not used", isolation: "read_committed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			tracker := &Tracker{
+				aggregates: []Aggregate{{Name: "customer", Isolation: tt.isolation}},
+				logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+				db:         db,
+			}
+			if tt.expectRead {
+				mock.ExpectQuery(regexp.QuoteMeta("snapshot_isolation_state FROM sys.databases")).
+					WillReturnRows(sqlmock.NewRows([]string{"name", "state"}).AddRow("ERPXL_GO", tt.state))
+			}
+
+			// --- Act ---
+			err = tracker.CheckSnapshotIsolation(context.Background())
+
+			// --- Assert ---
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "ALLOW_SNAPSHOT_ISOLATION")
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAggregate_ValidateColumns(t *testing.T) {
+	tests := []struct {
+		name      string
+		aggregate Aggregate
+		wantErr   bool
+	}{
+		{name: "no columns", aggregate: Aggregate{Name: "customer"}},
+		{
+			name: "one table",
+			aggregate: Aggregate{
+				Name: "customer", Columns: []string{"Knt_Akronim"},
+				GetQuery: "SELECT * FROM CHANGETABLE(CHANGES CDN.KntKarty, @version) AS c",
+			},
+		},
+		{
+			name:      "no table",
+			aggregate: Aggregate{Name: "customer", Columns: []string{"Knt_Akronim"}, GetQuery: "SELECT 1"},
+			wantErr:   true,
+		},
+		{
+			name: "invalid column",
+			aggregate: Aggregate{
+				Name: "customer", Columns: []string{"Knt_Akronim'--"}, Tables: []string{"CDN.KntKarty"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			err := tt.aggregate.validateColumns()
+
+			// --- Assert ---
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestTracker_RunErpCycle_SkipsIrrelevantColumns(t *testing.T) {
+	// --- Arrange ---
+	trackerRepo := &mockTrackerRepository{}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	publisher := &mockPublisher{}
+
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{
+		aggregates: []Aggregate{{
+			Name: "customer", Columns: []string{"Knt_Akronim", "Knt_Nazwa1"}, Tables: []string{"CDN.KntKarty"},
+		}},
+		repository: trackerRepo,
+		logger:     logger,
+		db:         db,
+		dispatcher: dispatcher.NewDispatcher(1, 10, publisher, logger),
+	}
+	query := "SELECT * FROM changes WHERE version > @version;"
+
+	mock.ExpectQuery(regexp.QuoteMeta("CHANGE_TRACKING_IS_COLUMN_IN_MASK(COLUMNPROPERTY(OBJECT_ID('CDN.KntKarty'), 'Knt_Nazwa1', 'ColumnId'), q.change_columns)")).
+		WithArgs(sqlmock.AnyArg()).
+		WillReturnRows(
+			sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload", relevantColumn}).
+				AddRow("U", 11, "C4CA4238A0B923820DCC509A6F75849B", `{}`, 1).
+				AddRow("U", 12, "C4CA4238A0B923820DCC509A6F75849C", `{}`, 0),
+		)
+
+	// --- Act ---
+	err = tracker.runErpCycle(context.Background(), "customer", query)
+
+	// --- Assert ---
+	require.NoError(t, err, "runErpCycle should not return an error")
+	assert.NoError(t, mock.ExpectationsWereMet(), "the query should be wrapped by the column filter")
+	assert.Equal(t, 2, trackerRepo.RecordedCycle.RowsFetched)
+	assert.Equal(t, 1, trackerRepo.RecordedCycle.RowsSkipped)
+	assert.Equal(t, int64(12), trackerRepo.RecordedCycle.ToVersion, "skipped rows should still advance the version")
+}
+
+func TestTracker_ScanErpChanges_OptionalColumns(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{logger: logger, db: db}
+	query := "SELECT * FROM changes WHERE version > @version"
+	commitTime := time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC)
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{
+			"change_operation", "change_version", "aggregate_key", "payload",
+			"change_context", "commit_time", "user_id", "correlation_id",
+		}).
+			AddRow("U", 7, "C4CA4238A0B923820DCC509A6F75849B", `{}`, []byte("xl:ADMIN"), commitTime, "ADMIN", "order-17").
+			AddRow("U", 8, "C4CA4238A0B923820DCC509A6F75849C", `{}`, []byte{0xff, 0x01}, nil, nil, nil),
+	)
+	var jobs []dispatcher.Job
+
+	// --- Act ---
+	count, _, _, err := tracker.scanErpChanges(context.Background(), db, "customer", query, 0, func(job dispatcher.Job) error {
+		jobs = append(jobs, job)
+		return nil
+	}, nil)
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Equal(t, 2, count)
+	first := jobs[0].EventEnvelope
+	require.NotNil(t, first.SourceTimestamp)
+	assert.True(t, commitTime.Equal(*first.SourceTimestamp), "commit_time should be the source timestamp")
+	assert.Equal(t, "ADMIN", first.UserID)
+	assert.Equal(t, "order-17", first.CorrelationID)
+	assert.Equal(t, "xl:ADMIN", first.Metadata["change_context"], "change_context should be in the metadata")
+	assert.Empty(t, first.CausationID, "change_context is not the causation id")
+	second := jobs[1].EventEnvelope
+	assert.Nil(t, second.SourceTimestamp)
+	assert.Empty(t, second.UserID)
+	assert.Equal(t, "ff01", second.Metadata["change_context"], "binary change_context should be hex encoded")
+}
+
+func TestTracker_ScanErpChanges_ColumnMapping(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{
+		aggregates: []Aggregate{{Name: "customer", Defaults: map[string]string{"user_id": "ERP"}}},
+		logger:     logger,
+		db:         db,
+	}
+	query := "SELECT * FROM changes WHERE version > @version"
+
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"aggregate_key", "payload", "change_version", "change_operation", "knt_typ"}).
+			AddRow("C4CA4238A0B923820DCC509A6F75849B", `{"name":"ACME"}`, 5, "updated", []byte("32")).
+			AddRow("C4CA4238A0B923820DCC509A6F75849C", nil, 6, "deleted", nil).
+			AddRow("C4CA4238A0B923820DCC509A6F75849D", nil, 7, "updated", nil),
+	)
+	var jobs []dispatcher.Job
+
+	// --- Act ---
+	count, skipped, maxVersion, err := tracker.scanErpChanges(context.Background(), db, "customer", query, 0,
+		func(job dispatcher.Job) error {
+			jobs = append(jobs, job)
+			return nil
+		}, nil)
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.Equal(t, 1, skipped, "an update without payload should be skipped")
+	assert.Equal(t, int64(7), maxVersion, "skipped rows should still advance the version")
+	require.Len(t, jobs, 2)
+
+	updated := jobs[0].EventEnvelope
+	assert.Equal(t, "erp.customer.updated", updated.EventType)
+	assert.Equal(t, int64(5), updated.ChangeVersion)
+	assert.Equal(t, `{"name":"ACME"}`, updated.Payload)
+	assert.Equal(t, "ERP", updated.UserID, "a missing column should fall back to the default")
+	assert.Equal(t, map[string]any{"knt_typ": "32"}, updated.Metadata, "extra columns should become metadata")
+
+	deleted := jobs[1].EventEnvelope
+	assert.Equal(t, "erp.customer.deleted", deleted.EventType)
+	assert.Equal(t, json.RawMessage("null"), deleted.Payload, "a delete without payload should be a tombstone")
+}
+
+func TestTracker_ScanErpChanges_MissingRequiredColumn(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{logger: logger, db: db}
+	query := "SELECT * FROM changes WHERE version > @version"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "aggregate_key", "payload"}).
+			AddRow("U", "C4CA4238A0B923820DCC509A6F75849B", `{}`),
+	)
+
+	// --- Act ---
+	_, _, _, err = tracker.scanErpChanges(context.Background(), db, "customer", query, 0, func(dispatcher.Job) error {
+		return nil
+	}, nil)
+
+	// --- Assert ---
+	assert.ErrorContains(t, err, "'change_version'")
+}
+
+type mockQuarantine struct {
+	rows []QuarantinedRow
+}
+
+func (m *mockQuarantine) QuarantineRow(ctx context.Context, row QuarantinedRow) error {
+	row.ID = int64(len(m.rows) + 1)
+	m.rows = append(m.rows, row)
+	return nil
+}
+
+func (m *mockQuarantine) ListQuarantined(ctx context.Context, aggregateName string) ([]QuarantinedRow, error) {
+	return m.rows, nil
+}
+
+func (m *mockQuarantine) DeleteQuarantined(ctx context.Context, id int64) error {
+	return nil
+}
+
+func TestTracker_RunErpCycle_CommitsQuarantinedRows(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	committer := &mockCycleCommitter{errToReturn: errors.New("connection reset")}
+	quarantine := &mockQuarantine{}
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{
+		aggregates: []Aggregate{{Name: "customer", OnError: OnErrorQuarantine}},
+		repository: &mockTrackerRepository{},
+		logger:     logger,
+		db:         db,
+		dispatcher: dispatcher.NewDispatcher(1, 10, &mockPublisher{}, logger),
+		committer:  committer,
+		quarantine: quarantine,
+	}
+	query := "SELECT * FROM changes WHERE version > @version"
+	for range 2 {
+		mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+			sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+				AddRow("U", 4, nil, `{}`).
+				AddRow("U", 5, "C4CA4238A0B923820DCC509A6F75849B", `{}`),
+		)
+	}
+
+	// --- Act ---
+	failedErr := tracker.runErpCycle(context.Background(), "customer", query)
+	committer.errToReturn = nil
+	retryErr := tracker.runErpCycle(context.Background(), "customer", query)
+
+	// --- Assert ---
+	assert.ErrorContains(t, failedErr, "connection reset")
+	require.NoError(t, retryErr)
+	assert.Empty(t, quarantine.rows, "rows of a committed cycle should only be stored by the commit")
+	require.Len(t, committer.quarantined, 1, "the retried cycle should commit the row once")
+	assert.Equal(t, int64(4), committer.quarantined[0].ChangeVersion)
+	assert.Len(t, committer.envelopes, 1)
+}
+
+func TestTracker_ScanErpChanges_OnError(t *testing.T) {
+	tests := []struct {
+		onError         string
+		wantErr         bool
+		wantQuarantined int
+	}{
+		{onError: OnErrorFail, wantErr: true},
+		{onError: OnErrorSkip},
+		{onError: OnErrorQuarantine, wantQuarantined: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.onError, func(t *testing.T) {
+			// --- Arrange ---
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			quarantine := &mockQuarantine{}
+			tracker := &Tracker{
+				aggregates: []Aggregate{{Name: "customer", OnError: tt.onError}},
+				logger:     logger,
+				db:         db,
+				quarantine: quarantine,
+			}
+			query := "SELECT * FROM changes WHERE version > @version"
+			mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+				sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+					AddRow("U", 4, nil, `{}`).
+					AddRow("U", 5, "C4CA4238A0B923820DCC509A6F75849B", `{}`),
+			)
+			var jobs []dispatcher.Job
+
+			// --- Act ---
+			count, skipped, maxVersion, err := tracker.scanErpChanges(context.Background(), db, "customer", query, 0,
+				func(job dispatcher.Job) error {
+					jobs = append(jobs, job)
+					return nil
+				}, nil)
+
+			// --- Assert ---
+			if tt.wantErr {
+				assert.ErrorContains(t, err, "aggregate Key is required")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, 2, count)
+			assert.Equal(t, 1, skipped, "the invalid row should be skipped")
+			assert.Equal(t, int64(5), maxVersion)
+			assert.Len(t, jobs, 1, "the valid row should still be dispatched")
+			require.Len(t, quarantine.rows, tt.wantQuarantined)
+			if tt.wantQuarantined > 0 {
+				assert.Equal(t, int64(4), quarantine.rows[0].ChangeVersion)
+				assert.Equal(t, "U", quarantine.rows[0].Values["change_operation"])
+				assert.Contains(t, quarantine.rows[0].Error, "aggregate Key is required")
+			}
+		})
+	}
+}
+
+func TestQuarantinedJobs(t *testing.T) {
+	// --- Arrange ---
+	row := QuarantinedRow{
+		ID:        1,
+		Aggregate: "customer",
+		Values: map[string]any{
+			"change_version": json.Number("9007199254740993"),
+			"aggregate_key":  "C4CA4238A0B923820DCC509A6F75849B",
+			"payload":        `{}`,
+			"commit_time":    "2024-03-01T12:30:00Z",
+		},
+	}
+	agg := Aggregate{Name: "customer", Defaults: map[string]string{"change_operation": "updated"}}
+
+	// --- Act ---
+	jobs, err := QuarantinedJobs(context.Background(), agg, row, "")
+
+	// --- Assert ---
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	job := jobs[0]
+	assert.Equal(t, "erp.customer", job.EventChannel)
+	assert.Equal(t, "erp.customer.updated", job.EventEnvelope.EventType, "the current defaults should apply")
+	assert.Equal(t, int64(9007199254740993), job.EventEnvelope.ChangeVersion)
+	require.NotNil(t, job.EventEnvelope.SourceTimestamp)
+	assert.Equal(t, 2024, job.EventEnvelope.SourceTimestamp.Year())
+}
+
+func TestTracker_ScanErpChanges_QuarantineEncryptsPII(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	config := `aggregates:
+  - name: "customer"
+    on_error: quarantine
+    pii:
+      customer_email: mask
+`
+	testFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(testFile, []byte(config+`    transform:
+      - convert: {converter: clarion_date, paths: [customer_since]}
+`), 0644))
+	fixedFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(fixedFile, []byte(config), 0644))
+	keyring, err := pii.NewKeyring(map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	require.NoError(t, err)
+	protector := pii.NewProtector(nil, keyring)
+
+	aggregates, err := LoadAggregates(testFile)
+	require.NoError(t, err)
+	noKeyErr := aggregates[0].Protect(pii.NewProtector(nil, nil))
+	require.NoError(t, aggregates[0].Protect(protector))
+	fixed, err := LoadAggregates(fixedFile)
+	require.NoError(t, err)
+	require.NoError(t, fixed[0].Protect(protector))
+
+	quarantine := &mockQuarantine{}
+	tracker := &Tracker{aggregates: aggregates, logger: logger, db: db, quarantine: quarantine}
+	query := "SELECT * FROM changes WHERE version > @version"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+			AddRow("updated", 4, "K", `{"customer_email":"jan@example.com","customer_since":"yesterday"}`),
+	)
+
+	// --- Act ---
+	_, skipped, _, scanErr := tracker.scanErpChanges(context.Background(), db, "customer", query, 0,
+		func(job dispatcher.Job) error { return nil }, nil)
+	require.Len(t, quarantine.rows, 1)
+	stored, err := json.Marshal(quarantine.rows[0].Values)
+	require.NoError(t, err)
+	jobs, reprocessErr := QuarantinedJobs(context.Background(), fixed[0], quarantine.rows[0], "")
+
+	// --- Assert ---
+	assert.ErrorContains(t, noKeyErr, "quarantines rows with pii")
+	require.NoError(t, scanErr)
+	assert.Equal(t, 1, skipped)
+	assert.NotContains(t, string(stored), "jan@example.com", "quarantined values should be encrypted")
+	assert.Contains(t, quarantine.rows[0].Values, "pii_sealed")
+	require.NoError(t, reprocessErr)
+	require.Len(t, jobs, 1)
+	assert.JSONEq(t, `{"customer_email":"***********.com","customer_since":"yesterday"}`, jobs[0].EventEnvelope.Payload.(string))
+}
+
+func TestTracker_ScanErpChanges_Coalesce(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	tracker := &Tracker{aggregates: []Aggregate{{Name: "order", Coalesce: true}}, logger: logger, db: db}
+	query := "SELECT * FROM changes WHERE version > @version"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+			// the rows of a get query have no defined order
+			AddRow("updated", 6, "C4CA4238A0B923820DCC509A6F75849B", `{"lines":3}`).
+			AddRow("updated", 4, "C4CA4238A0B923820DCC509A6F75849C", `{"lines":5}`).
+			AddRow("inserted", 3, "C4CA4238A0B923820DCC509A6F75849B", `{"lines":1}`).
+			AddRow("updated", 5, "C4CA4238A0B923820DCC509A6F75849B", `{"lines":2}`),
+	)
+	var jobs []dispatcher.Job
+
+	// --- Act ---
+	count, skipped, maxVersion, err := tracker.scanErpChanges(context.Background(), db, "order", query, 0,
+		func(job dispatcher.Job) error {
+			jobs = append(jobs, job)
+			return nil
+		}, nil)
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, 2, skipped, "the older changes of a key should be coalesced")
+	assert.Equal(t, int64(6), maxVersion)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "erp.order.inserted", jobs[0].EventEnvelope.EventType, "insert then update should stay an insert")
+	assert.Equal(t, int64(6), jobs[0].EventEnvelope.ChangeVersion)
+	assert.Equal(t, `{"lines":3}`, jobs[0].EventEnvelope.Payload)
+	assert.Equal(t, "erp.order.updated", jobs[1].EventEnvelope.EventType)
+}
+
+func TestLoadAggregates_Transform(t *testing.T) {
+	// --- Arrange ---
+	testFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(testFile, []byte(`aggregates:
+  - name: "customer"
+    transform:
+      - rename: {from: knt_akronim, to: code}
+      - map: {path: knt_typ, values: {8: supplier, 16: customer}}
+`), 0644))
+	invalidFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(invalidFile, []byte(`aggregates:
+  - name: "customer"
+    transform:
+      - coerce: {path: knt_typ, type: decimal}
+`), 0644))
+
+	// --- Act ---
+	aggregates, err := LoadAggregates(testFile)
+	require.NoError(t, err)
+	event, transformErr := aggregates[0].transformPayload(ChangeEvent{Payload: `{"knt_akronim":"ACME","knt_typ":16}`})
+	tombstone, _ := aggregates[0].transformPayload(ChangeEvent{NullPayload: true})
+	_, invalidErr := LoadAggregates(invalidFile)
+
+	// --- Assert ---
+	require.NoError(t, transformErr)
+	assert.JSONEq(t, `{"code":"ACME","knt_typ":"customer"}`, event.Payload)
+	assert.Empty(t, tombstone.Payload, "a tombstone should not be transformed")
+	assert.ErrorContains(t, invalidErr, "invalid transform")
+}
+
+func TestAggregate_ProtectPayload(t *testing.T) {
+	// --- Arrange ---
+	testFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(testFile, []byte(`aggregates:
+  - name: "customer"
+    pii:
+      customer_email: encrypt
+      customer_tax_number: hash
+      customer_phone_number: mask
+      addresses.address_phone: mask
+`), 0644))
+	invalidFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(invalidFile, []byte(`aggregates:
+  - name: "customer"
+    pii:
+      customer_email: scramble
+`), 0644))
+	hashKey := []byte("hash-key")
+	keyring, err := pii.NewKeyring(map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	require.NoError(t, err)
+
+	aggregates, err := LoadAggregates(testFile)
+	require.NoError(t, err)
+	unprepared := aggregates[0]
+	noKeys := aggregates[0]
+	require.NoError(t, aggregates[0].Protect(pii.NewProtector(hashKey, keyring)))
+
+	// --- Act ---
+	event, protectErr := aggregates[0].protectEvent(ChangeEvent{Payload: `{
+		"customer_email":"jan@example.com","customer_tax_number":1234567890,
+		"customer_phone_number":"+48 77 123 45 67","addresses":[{"address_phone":"77 555 01 02"}]
+	}`})
+	_, unpreparedErr := unprepared.protectEvent(ChangeEvent{Payload: `{}`})
+	noKeysErr := noKeys.Protect(nil)
+	_, invalidErr := LoadAggregates(invalidFile)
+
+	// --- Assert ---
+	require.NoError(t, protectErr)
+	var payload map[string]any
+	require.NoError(t, json.Unmarshal([]byte(event.Payload), &payload))
+	email, err := keyring.Decrypt(payload["customer_email"].(string))
+	require.NoError(t, err)
+	assert.Equal(t, "jan@example.com", email)
+	assert.Equal(t, pii.Hash(hashKey, "1234567890"), payload["customer_tax_number"])
+	assert.Equal(t, "************5 67", payload["customer_phone_number"])
+	assert.Equal(t, []any{map[string]any{"address_phone": "********1 02"}}, payload["addresses"])
+	assert.ErrorContains(t, unpreparedErr, "not prepared")
+	assert.ErrorContains(t, noKeysErr, "requires")
+	assert.ErrorContains(t, invalidErr, "unknown pii policy")
+}
+
+func TestAggregate_BuildJobs_ProtectsMetadata(t *testing.T) {
+	// --- Arrange ---
+	testFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(testFile, []byte(`aggregates:
+  - name: "customer"
+    filter: "payload.customer_tax_number == '1234567890' or envelope.metadata.customer_email == 'jan@example.com'"
+    pii:
+      customer_email: mask
+      customer_tax_number: hash
+`), 0644))
+	hashKey := []byte("hash-key")
+	aggregates, err := LoadAggregates(testFile)
+	require.NoError(t, err)
+	agg := aggregates[0]
+	require.NoError(t, agg.Protect(pii.NewProtector(hashKey, nil)))
+	updated := ChangeEvent{
+		ChangeOperation: "updated", ChangeVersion: 3, AggregateKey: "K",
+		Payload:  `{"customer_tax_number":"1234567890"}`,
+		Metadata: map[string]any{"customer_email": "anna@example.com", naturalKeyMetadata: map[string]any{"customer_tax_number": "1234567890"}},
+	}
+	deleted := ChangeEvent{
+		ChangeOperation: "deleted", ChangeVersion: 4, AggregateKey: "K", NullPayload: true,
+		Metadata: map[string]any{"customer_email": "jan@example.com"},
+	}
+	other := ChangeEvent{
+		ChangeOperation: "updated", ChangeVersion: 5, AggregateKey: "L",
+		Payload: `{"customer_tax_number":"999"}`, Metadata: map[string]any{"customer_email": "ewa@example.com"},
+	}
+
+	// --- Act ---
+	updatedJobs, updatedErr := agg.buildJobs(context.Background(), updated, "")
+	deletedJobs, deletedErr := agg.buildJobs(context.Background(), deleted, "")
+	otherJobs, otherErr := agg.buildJobs(context.Background(), other, "")
+
+	// --- Assert ---
+	require.NoError(t, updatedErr)
+	require.NoError(t, deletedErr)
+	require.NoError(t, otherErr)
+	require.Len(t, updatedJobs, 1, "the filter should compare the values before protection")
+	hashed := pii.Hash(hashKey, "1234567890")
+	assert.Equal(t, `{"customer_tax_number":"`+hashed+`"}`, updatedJobs[0].EventEnvelope.Payload)
+	assert.Equal(t, map[string]any{
+		"customer_email":   pii.Mask("anna@example.com"),
+		naturalKeyMetadata: map[string]any{"customer_tax_number": hashed},
+	}, updatedJobs[0].EventEnvelope.Metadata)
+	require.Len(t, deletedJobs, 1, "the filter should see the metadata before protection")
+	assert.Equal(t, map[string]any{"customer_email": pii.Mask("jan@example.com")}, deletedJobs[0].EventEnvelope.Metadata,
+		"the metadata of a tombstone should be protected")
+	assert.Empty(t, otherJobs)
+	assert.Equal(t, "anna@example.com", updated.Metadata["customer_email"], "the metadata of the change should not be changed")
+}
+
+func TestTracker_ScanErpChanges_Filter(t *testing.T) {
+	// --- Arrange ---
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	agg := Aggregate{Name: "invoice", Filter: `payload.invoice_status in ['ZAMKNIĘTY'] or envelope.event_type == 'erp.invoice.deleted'`}
+	require.NoError(t, agg.compileFilter())
+	tracker := &Tracker{aggregates: []Aggregate{agg}, logger: logger, db: db}
+	query := "SELECT * FROM changes WHERE version > @version"
+	mock.ExpectQuery(regexp.QuoteMeta(query)).WithArgs(sqlmock.AnyArg()).WillReturnRows(
+		sqlmock.NewRows([]string{"change_operation", "change_version", "aggregate_key", "payload"}).
+			AddRow("updated", 3, "C4CA4238A0B923820DCC509A6F75849B", `{"invoice_status":"W BUFORZE"}`).
+			AddRow("updated", 4, "C4CA4238A0B923820DCC509A6F75849C", `{"invoice_status":"ZAMKNIĘTY"}`).
+			AddRow("deleted", 5, "C4CA4238A0B923820DCC509A6F75849D", nil).
+			AddRow("updated", 6, "C4CA4238A0B923820DCC509A6F75849E", `{"invoice_status":"ANULOWANY"}`),
+	)
+	var jobs []dispatcher.Job
+
+	// --- Act ---
+	count, skipped, maxVersion, err := tracker.scanErpChanges(context.Background(), db, "invoice", query, 0,
+		func(job dispatcher.Job) error {
+			jobs = append(jobs, job)
+			return nil
+		}, nil)
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.Equal(t, 4, count)
+	assert.Equal(t, 2, skipped)
+	assert.Equal(t, int64(6), maxVersion, "filtered changes should still advance the checkpoint")
+	require.Len(t, jobs, 2)
+	assert.Equal(t, int64(4), jobs[0].EventEnvelope.ChangeVersion)
+	assert.Equal(t, "erp.invoice.deleted", jobs[1].EventEnvelope.EventType)
+}
+
+func TestLoadAggregates_InvalidFilter(t *testing.T) {
+	// --- Arrange ---
+	testFile := t.TempDir() + "/aggregates.yaml"
+	require.NoError(t, os.WriteFile(testFile, []byte(`aggregates:
+  - name: "invoice"
+    filter: "payload.invoice_status in 'ZAMKNIĘTY'"
+`), 0644))
+
+	// --- Act ---
+	_, err := LoadAggregates(testFile)
+
+	// --- Assert ---
+	assert.ErrorContains(t, err, "aggregate 'invoice' has an invalid filter")
+}
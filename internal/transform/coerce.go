@@ -0,0 +1,99 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// coercions convert a JSON value to the type of their name
+var coercions = map[string]func(any) (any, error){
+	"string": toString,
+	"int":    toInt,
+	"float":  toFloat,
+	"bool":   toBool,
+}
+
+func toString(value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool, float64, int64:
+		return fmt.Sprint(v), nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to string", value)
+}
+
+func toInt(value any) (any, error) {
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = strings.TrimSpace(v)
+	case int64:
+		return v, nil
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	default:
+		return nil, fmt.Errorf("cannot convert %T to int", value)
+	}
+
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	// decimals such as 12.0000 returned for numeric columns are integers as well
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || f != math.Trunc(f) || math.Abs(f) > math.MaxInt64 {
+		return nil, fmt.Errorf("'%s' is not an integer", s)
+	}
+	return int64(f), nil
+}
+
+func toFloat(value any) (any, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		// decimal commas are common in ERP text fields
+		f, err := strconv.ParseFloat(strings.Replace(strings.TrimSpace(v), ",", ".", 1), 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", v)
+		}
+		return f, nil
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to float", value)
+}
+
+func toBool(value any) (any, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case json.Number:
+		return v.String() != "0", nil
+	case int64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		b, err := strconv.ParseBool(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a boolean", v)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("cannot convert %T to bool", value)
+}
@@ -0,0 +1,110 @@
+package transform
+
+import "time"
+
+// XL stores dates as days since 1800-12-28 (the Clarion convention) and timestamps as seconds
+// since 1990-01-01, both in the local time of the ERP without a zone. Zero means no date.
+var (
+	clarionEpoch = time.Date(1800, 12, 28, 0, 0, 0, 0, time.UTC)
+	xlEpoch      = time.Date(1990, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// xlTimestampLayout formats XL timestamps without a zone, as they are local to the ERP
+const xlTimestampLayout = "2006-01-02T15:04:05"
+
+// gidTypeNames are the symbols of the XL object types (Ob_Skrot of CDN.Obiekty) by GID type
+var gidTypeNames = map[int64]string{
+	16:   "TW",
+	-16:  "TWG",
+	32:   "KNT",
+	-32:  "KNG",
+	960:  "ZS",
+	1152: "ZZ",
+	1489: "PZ",
+	1497: "PZK",
+	1521: "FZ",
+	1529: "FZK",
+	1603: "MMW",
+	1604: "MMP",
+	1616: "RW",
+	1617: "PW",
+	2001: "WZ",
+	2005: "WZE",
+	2009: "WZK",
+	2013: "WKE",
+	2033: "FS",
+	2034: "PA",
+	2037: "FSE",
+	2041: "FSK",
+	2042: "PAK",
+	2045: "FKE",
+}
+
+// documentStates are the labels of the trade document states (TrN_Stan)
+var documentStates = map[int64]string{
+	1: "W BUFORZE",
+	2: "W BUFORZE PO REEDYCJI",
+	3: "ZAMKNIĘTY NIE ROZLICZONY",
+	4: "W TRAKCIE ROZLICZENIA",
+	5: "ZAMKNIĘTY",
+	6: "ANULOWANY",
+}
+
+// converters convert XL values to their readable form, aggregates refer to them by name
+var converters = map[string]func(any) (any, error){
+	"clarion_date":      clarionDate,
+	"xl_timestamp":      xlTimestamp,
+	"gid_type_name":     gidTypeName,
+	"xl_document_state": documentState,
+}
+
+// clarionDate converts days since 1800-12-28 to a date, zero is no date
+func clarionDate(value any) (any, error) {
+	days, err := toXLInt(value)
+	if err != nil || days == 0 {
+		return nil, err
+	}
+	return clarionEpoch.AddDate(0, 0, int(days)).Format(time.DateOnly), nil
+}
+
+// xlTimestamp converts seconds since 1990-01-01 to a timestamp without zone, zero is no time
+func xlTimestamp(value any) (any, error) {
+	seconds, err := toXLInt(value)
+	if err != nil || seconds == 0 {
+		return nil, err
+	}
+	return xlEpoch.Add(time.Duration(seconds) * time.Second).Format(xlTimestampLayout), nil
+}
+
+// gidTypeName converts a GID type to the symbol of its object type, unknown types are kept
+func gidTypeName(value any) (any, error) {
+	gidType, err := toXLInt(value)
+	if err != nil {
+		return nil, err
+	}
+	if name, ok := gidTypeNames[gidType]; ok {
+		return name, nil
+	}
+	return value, nil
+}
+
+// documentState converts a trade document state to its label, unknown states are INNY (other)
+func documentState(value any) (any, error) {
+	state, err := toXLInt(value)
+	if err != nil {
+		return nil, err
+	}
+	if label, ok := documentStates[state]; ok {
+		return label, nil
+	}
+	return "INNY", nil
+}
+
+// toXLInt reads the integer an XL column holds
+func toXLInt(value any) (int64, error) {
+	i, err := toInt(value)
+	if err != nil {
+		return 0, err
+	}
+	return i.(int64), nil
+}
@@ -0,0 +1,78 @@
+package transform
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConverters(t *testing.T) {
+	tests := []struct {
+		name      string
+		converter string
+		value     any
+		want      any
+		wantErr   bool
+	}{
+		{name: "clarion date", converter: "clarion_date", value: json.Number("81453"), want: "2024-01-01"},
+		{name: "clarion date leap day", converter: "clarion_date", value: json.Number("72746"), want: "2000-02-29"},
+		{name: "clarion date day one", converter: "clarion_date", value: int64(1), want: "1800-12-29"},
+		{name: "clarion date as text", converter: "clarion_date", value: "81453", want: "2024-01-01"},
+		{name: "clarion date zero is no date", converter: "clarion_date", value: json.Number("0"), want: nil},
+		{name: "clarion date not a number", converter: "clarion_date", value: "yesterday", wantErr: true},
+		{name: "xl timestamp", converter: "xl_timestamp", value: json.Number("1079358330"), want: "2024-03-15T13:45:30"},
+		{name: "xl timestamp epoch second", converter: "xl_timestamp", value: int64(1), want: "1990-01-01T00:00:01"},
+		{name: "xl timestamp zero is no time", converter: "xl_timestamp", value: json.Number("0"), want: nil},
+		{name: "xl timestamp fraction", converter: "xl_timestamp", value: json.Number("1.5"), wantErr: true},
+		{name: "gid type of invoice", converter: "gid_type_name", value: json.Number("2033"), want: "FS"},
+		{name: "gid type of correction", converter: "gid_type_name", value: json.Number("2041"), want: "FSK"},
+		{name: "gid type of item group", converter: "gid_type_name", value: json.Number("-16"), want: "TWG"},
+		{name: "unknown gid type is kept", converter: "gid_type_name", value: json.Number("4711"), want: json.Number("4711")},
+		{name: "document in buffer", converter: "xl_document_state", value: json.Number("1"), want: "W BUFORZE"},
+		{name: "document closed", converter: "xl_document_state", value: json.Number("5"), want: "ZAMKNIĘTY"},
+		{name: "document cancelled", converter: "xl_document_state", value: int64(6), want: "ANULOWANY"},
+		{name: "unknown document state", converter: "xl_document_state", value: json.Number("9"), want: "INNY"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			convert := converters[tt.converter]
+			require.NotNil(t, convert)
+
+			// --- Act ---
+			got, err := convert(tt.value)
+
+			// --- Assert ---
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPipeline_Apply_Convert(t *testing.T) {
+	// --- Arrange ---
+	pipeline, err := New([]Step{
+		{Convert: &Convert{Converter: "clarion_date", Paths: []string{"invoice_date", "lines.delivery_date"}}},
+		{Convert: &Convert{Converter: "xl_document_state", Paths: []string{"invoice_status"}}},
+	})
+	require.NoError(t, err)
+
+	// --- Act ---
+	got, err := pipeline.Apply([]byte(
+		`{"invoice_date":81453,"invoice_status":3,"lines":[{"delivery_date":0},{"delivery_date":null}]}`,
+	))
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.JSONEq(t,
+		`{"invoice_date":"2024-01-01","invoice_status":"ZAMKNIĘTY NIE ROZLICZONY","lines":[{"delivery_date":null},{"delivery_date":null}]}`,
+		string(got),
+	)
+}
@@ -0,0 +1,410 @@
+// Package transform reshapes JSON payloads with declarative steps configured per aggregate
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Step is a single transformation, exactly one of its operations is set
+type Step struct {
+	// Rename renames the last field of the path, e.g. {from: address.zip, to: postal_code}
+	Rename *Rename `yaml:"rename"`
+	// Drop removes the fields at the paths
+	Drop []string `yaml:"drop"`
+	// Default sets the field when it is missing or null
+	Default *Default `yaml:"default"`
+	// Coerce converts the field to string, int, float or bool
+	Coerce *Coerce `yaml:"coerce"`
+	// Move moves a field to another path, missing objects of the target path are created
+	Move *Move `yaml:"move"`
+	// Map replaces the field with the value it maps to
+	Map *ValueMap `yaml:"map"`
+	// Convert converts the fields with a built-in XL converter such as clarion_date
+	Convert *Convert `yaml:"convert"`
+}
+
+// Rename renames the field at From to To within the same object
+type Rename struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// Default is the value set at Path when the field is missing or null
+type Default struct {
+	Path  string `yaml:"path"`
+	Value any    `yaml:"value"`
+}
+
+// Coerce converts the field at Path to Type
+type Coerce struct {
+	Path string `yaml:"path"`
+	Type string `yaml:"type"`
+}
+
+// Move moves the field at From to To
+type Move struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// ValueMap replaces the field at Path with its entry in Values, values without an entry are
+// replaced with Default when it is set and kept otherwise
+type ValueMap struct {
+	Path    string         `yaml:"path"`
+	Values  map[string]any `yaml:"values"`
+	Default any            `yaml:"default"`
+}
+
+// Convert converts the fields at Paths with the named Converter: clarion_date, xl_timestamp,
+// gid_type_name or xl_document_state
+type Convert struct {
+	Converter string   `yaml:"converter"`
+	Paths     []string `yaml:"paths"`
+}
+
+// Pipeline applies steps to JSON objects in order. Paths are dot separated and descend into
+// every element of the arrays they cross, except for the target path of a move.
+type Pipeline struct {
+	steps []func(doc map[string]any) error
+}
+
+// New validates the steps and returns their pipeline
+func New(steps []Step) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	for i, step := range steps {
+		apply, err := compile(step)
+		if err != nil {
+			return nil, fmt.Errorf("invalid transform step %d: %w", i+1, err)
+		}
+		pipeline.steps = append(pipeline.steps, apply)
+	}
+	return pipeline, nil
+}
+
+// Apply runs the pipeline on a JSON object and returns the transformed object
+func (p *Pipeline) Apply(payload []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	// numbers keep their exact value unless a step converts them
+	decoder.UseNumber()
+	var doc map[string]any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("payload is not a JSON object: %w", err)
+	}
+	if doc == nil {
+		return nil, fmt.Errorf("payload is not a JSON object")
+	}
+
+	for i, step := range p.steps {
+		if err := step(doc); err != nil {
+			return nil, fmt.Errorf("transform step %d failed: %w", i+1, err)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// Values returns a pipeline that replaces every non-null value at the paths with its conversion
+func Values(paths []string, convert func(any) (any, error)) (*Pipeline, error) {
+	pipeline := &Pipeline{}
+	for _, p := range paths {
+		path, err := parsePath(p)
+		if err != nil {
+			return nil, err
+		}
+		pipeline.steps = append(pipeline.steps, eachValue(path, convert))
+	}
+	return pipeline, nil
+}
+
+func compile(step Step) (func(doc map[string]any) error, error) {
+	var ops int
+	for _, set := range []bool{
+		step.Rename != nil, step.Drop != nil, step.Default != nil,
+		step.Coerce != nil, step.Move != nil, step.Map != nil, step.Convert != nil,
+	} {
+		if set {
+			ops++
+		}
+	}
+	if ops != 1 {
+		return nil, fmt.Errorf("a step needs exactly one of rename, drop, default, coerce, move, map or convert")
+	}
+
+	switch {
+	case step.Rename != nil:
+		from, err := parsePath(step.Rename.From)
+		if err != nil {
+			return nil, err
+		}
+		to := step.Rename.To
+		if to == "" || strings.Contains(to, ".") {
+			return nil, fmt.Errorf("rename target '%s' must be a field name, use move for paths", to)
+		}
+		return func(doc map[string]any) error {
+			return eachParent(doc, from, false, func(parent map[string]any, key string) error {
+				if value, ok := parent[key]; ok {
+					delete(parent, key)
+					parent[to] = value
+				}
+				return nil
+			})
+		}, nil
+
+	case step.Drop != nil:
+		paths := make([][]string, len(step.Drop))
+		for i, p := range step.Drop {
+			path, err := parsePath(p)
+			if err != nil {
+				return nil, err
+			}
+			paths[i] = path
+		}
+		return func(doc map[string]any) error {
+			for _, path := range paths {
+				err := eachParent(doc, path, false, func(parent map[string]any, key string) error {
+					delete(parent, key)
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+
+	case step.Default != nil:
+		path, err := parsePath(step.Default.Path)
+		if err != nil {
+			return nil, err
+		}
+		value, err := jsonValue(step.Default.Value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default of '%s': %w", step.Default.Path, err)
+		}
+		return func(doc map[string]any) error {
+			return eachParent(doc, path, true, func(parent map[string]any, key string) error {
+				if parent[key] == nil {
+					// later steps change the copy, not the configured value
+					parent[key] = copyValue(value)
+				}
+				return nil
+			})
+		}, nil
+
+	case step.Coerce != nil:
+		path, err := parsePath(step.Coerce.Path)
+		if err != nil {
+			return nil, err
+		}
+		convert, ok := coercions[step.Coerce.Type]
+		if !ok {
+			return nil, fmt.Errorf("unknown coerce type '%s', use string, int, float or bool", step.Coerce.Type)
+		}
+		return eachValue(path, convert), nil
+
+	case step.Move != nil:
+		from, err := parsePath(step.Move.From)
+		if err != nil {
+			return nil, err
+		}
+		to, err := parsePath(step.Move.To)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc map[string]any) error {
+			value, ok := take(doc, from)
+			if !ok {
+				return nil
+			}
+			return set(doc, to, value)
+		}, nil
+
+	case step.Convert != nil:
+		convert, ok := converters[step.Convert.Converter]
+		if !ok {
+			return nil, fmt.Errorf("unknown converter '%s'", step.Convert.Converter)
+		}
+		if len(step.Convert.Paths) == 0 {
+			return nil, fmt.Errorf("converter '%s' has no paths", step.Convert.Converter)
+		}
+		pipeline, err := Values(step.Convert.Paths, convert)
+		if err != nil {
+			return nil, err
+		}
+		return func(doc map[string]any) error {
+			for _, apply := range pipeline.steps {
+				if err := apply(doc); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, nil
+
+	default:
+		path, err := parsePath(step.Map.Path)
+		if err != nil {
+			return nil, err
+		}
+		if len(step.Map.Values) == 0 {
+			return nil, fmt.Errorf("map of '%s' has no values", step.Map.Path)
+		}
+		values := make(map[string]any, len(step.Map.Values))
+		for key, mapped := range step.Map.Values {
+			if values[key], err = jsonValue(mapped); err != nil {
+				return nil, fmt.Errorf("invalid value of '%s' in map of '%s': %w", key, step.Map.Path, err)
+			}
+		}
+		fallback, err := jsonValue(step.Map.Default)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default in map of '%s': %w", step.Map.Path, err)
+		}
+		return eachValue(path, func(value any) (any, error) {
+			if mapped, ok := values[fmt.Sprint(value)]; ok {
+				return copyValue(mapped), nil
+			}
+			if fallback != nil {
+				return copyValue(fallback), nil
+			}
+			return value, nil
+		}), nil
+	}
+}
+
+// jsonValue returns a configured value as decoded from a JSON payload, so numbers decoded from
+// YAML as int or float64 become json.Number like the numbers of the payload
+func jsonValue(value any) (any, error) {
+	if value == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	var decoded any
+	if err := decoder.Decode(&decoded); err != nil {
+		return nil, err
+	}
+	return decoded, nil
+}
+
+// copyValue returns a deep copy of a configured object or list, scalars are returned as they are
+func copyValue(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		copied := make(map[string]any, len(v))
+		for key, field := range v {
+			copied[key] = copyValue(field)
+		}
+		return copied
+	case []any:
+		copied := make([]any, len(v))
+		for i, element := range v {
+			copied[i] = copyValue(element)
+		}
+		return copied
+	}
+	return value
+}
+
+// eachValue returns a step that replaces every non-null value at the path with its conversion
+func eachValue(path []string, convert func(any) (any, error)) func(doc map[string]any) error {
+	return func(doc map[string]any) error {
+		return eachParent(doc, path, false, func(parent map[string]any, key string) error {
+			value, ok := parent[key]
+			if !ok || value == nil {
+				return nil
+			}
+			converted, err := convert(value)
+			if err != nil {
+				return fmt.Errorf("field '%s': %w", strings.Join(path, "."), err)
+			}
+			parent[key] = converted
+			return nil
+		})
+	}
+}
+
+func parsePath(path string) ([]string, error) {
+	if path == "" {
+		return nil, fmt.Errorf("path is required")
+	}
+	segments := strings.Split(path, ".")
+	for _, segment := range segments {
+		if segment == "" {
+			return nil, fmt.Errorf("invalid path '%s'", path)
+		}
+	}
+	return segments, nil
+}
+
+// eachParent calls fn with every object holding the last field of the path, arrays on the way
+// are descended element by element. Missing objects are created when create is set.
+func eachParent(
+	node any, path []string, create bool, fn func(parent map[string]any, key string) error,
+) error {
+	switch n := node.(type) {
+	case []any:
+		for _, element := range n {
+			if err := eachParent(element, path, create, fn); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		if len(path) == 1 {
+			return fn(n, path[0])
+		}
+		child, ok := n[path[0]]
+		if !ok || child == nil {
+			if !create {
+				return nil
+			}
+			child = map[string]any{}
+			n[path[0]] = child
+		}
+		return eachParent(child, path[1:], create, fn)
+	default:
+		// a scalar on the way has no fields
+		return nil
+	}
+}
+
+// take removes and returns the field at the path, the path must not cross arrays
+func take(doc map[string]any, path []string) (any, bool) {
+	parent := doc
+	for _, segment := range path[:len(path)-1] {
+		child, ok := parent[segment].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		parent = child
+	}
+	value, ok := parent[path[len(path)-1]]
+	if ok {
+		delete(parent, path[len(path)-1])
+	}
+	return value, ok
+}
+
+// set stores the value at the path and creates missing objects on the way
+func set(doc map[string]any, path []string, value any) error {
+	parent := doc
+	for _, segment := range path[:len(path)-1] {
+		switch child := parent[segment].(type) {
+		case map[string]any:
+			parent = child
+		case nil:
+			created := map[string]any{}
+			parent[segment] = created
+			parent = created
+		default:
+			return fmt.Errorf("cannot move into '%s', it is not an object", segment)
+		}
+	}
+	parent[path[len(path)-1]] = value
+	return nil
+}
@@ -0,0 +1,188 @@
+package transform
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestPipeline_Apply(t *testing.T) {
+	tests := []struct {
+		name    string
+		steps   string
+		payload string
+		want    string
+	}{
+		{
+			name:    "rename",
+			steps:   `[{rename: {from: knt_akronim, to: code}}]`,
+			payload: `{"knt_akronim":"ACME","knt_nip":"123"}`,
+			want:    `{"code":"ACME","knt_nip":"123"}`,
+		},
+		{
+			name:    "rename in array elements",
+			steps:   `[{rename: {from: sku_stock.sku_stock_qty, to: qty}}]`,
+			payload: `{"sku_stock":[{"sku_stock_qty":1},{"sku_stock_qty":2}]}`,
+			want:    `{"sku_stock":[{"qty":1},{"qty":2}]}`,
+		},
+		{
+			name:    "drop",
+			steps:   `[{drop: [internal, address.fax]}]`,
+			payload: `{"internal":1,"address":{"fax":"-","city":"Opole"}}`,
+			want:    `{"address":{"city":"Opole"}}`,
+		},
+		{
+			name:    "default for missing and null",
+			steps:   `[{default: {path: country, value: PL}}, {default: {path: address.city, value: unknown}}]`,
+			payload: `{"country":null}`,
+			want:    `{"address":{"city":"unknown"},"country":"PL"}`,
+		},
+		{
+			name:    "default keeps values",
+			steps:   `[{default: {path: country, value: PL}}]`,
+			payload: `{"country":"DE"}`,
+			want:    `{"country":"DE"}`,
+		},
+		{
+			name:    "coerce",
+			steps:   `[{coerce: {path: id, type: string}}, {coerce: {path: qty, type: int}}, {coerce: {path: price, type: float}}, {coerce: {path: active, type: bool}}]`,
+			payload: `{"id":9007199254740993,"qty":"12.0000","price":"10,50","active":"true"}`,
+			want:    `{"active":true,"id":"9007199254740993","price":10.5,"qty":12}`,
+		},
+		{
+			name:    "nested move",
+			steps:   `[{move: {from: knt_miasto, to: address.city}}, {move: {from: missing, to: address.zip}}]`,
+			payload: `{"knt_miasto":"Opole"}`,
+			want:    `{"address":{"city":"Opole"}}`,
+		},
+		{
+			name:    "value map",
+			steps:   `[{map: {path: trn_stan, values: {1: draft, 3: confirmed}, default: other}}, {map: {path: kind, values: {A: active}}}]`,
+			payload: `{"trn_stan":3,"kind":"B","docs":[]}`,
+			want:    `{"docs":[],"kind":"B","trn_stan":"confirmed"}`,
+		},
+		{
+			name:    "value map default",
+			steps:   `[{map: {path: trn_stan, values: {1: draft}, default: other}}]`,
+			payload: `{"trn_stan":5}`,
+			want:    `{"trn_stan":"other"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			var steps []Step
+			require.NoError(t, yaml.Unmarshal([]byte(tt.steps), &steps))
+			pipeline, err := New(steps)
+			require.NoError(t, err)
+
+			// --- Act ---
+			got, err := pipeline.Apply([]byte(tt.payload))
+
+			// --- Assert ---
+			require.NoError(t, err)
+			assert.JSONEq(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestNew_InvalidSteps(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps string
+	}{
+		{name: "no operation", steps: `[{}]`},
+		{name: "two operations", steps: `[{drop: [a], rename: {from: b, to: c}}]`},
+		{name: "rename to a path", steps: `[{rename: {from: a, to: b.c}}]`},
+		{name: "unknown coerce type", steps: `[{coerce: {path: a, type: date}}]`},
+		{name: "empty path", steps: `[{drop: [""]}]`},
+		{name: "empty value map", steps: `[{map: {path: a}}]`},
+		{name: "unknown converter", steps: `[{convert: {converter: clarion_time, paths: [a]}}]`},
+		{name: "converter without paths", steps: `[{convert: {converter: clarion_date}}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Arrange ---
+			var steps []Step
+			require.NoError(t, yaml.Unmarshal([]byte(tt.steps), &steps))
+
+			// --- Act ---
+			pipeline, err := New(steps)
+
+			// --- Assert ---
+			assert.Error(t, err)
+			assert.Nil(t, pipeline)
+		})
+	}
+}
+
+func TestPipeline_Apply_Errors(t *testing.T) {
+	// --- Arrange ---
+	pipeline, err := New([]Step{{Coerce: &Coerce{Path: "qty", Type: "int"}}})
+	require.NoError(t, err)
+
+	// --- Act ---
+	_, notObject := pipeline.Apply([]byte(`[1,2]`))
+	_, notInt := pipeline.Apply([]byte(`{"qty":"1.5"}`))
+
+	// --- Assert ---
+	assert.ErrorContains(t, notObject, "not a JSON object")
+	assert.ErrorContains(t, notInt, "field 'qty'")
+}
+
+func TestPipeline_Apply_ObjectDefault(t *testing.T) {
+	// --- Arrange ---
+	var steps []Step
+	require.NoError(t, yaml.Unmarshal([]byte(`[
+		{default: {path: address, value: {lines: [{kind: main}]}}},
+		{default: {path: address.city, value: OPOLE}},
+		{rename: {from: address.city, to: town}},
+		{move: {from: note, to: address.note}},
+		{drop: [address.lines]},
+		{map: {path: kind, values: {A: {active: true}}}},
+		{rename: {from: kind.active, to: enabled}}
+	]`), &steps))
+	pipeline, err := New(steps)
+	require.NoError(t, err)
+
+	// --- Act ---
+	first, firstErr := pipeline.Apply([]byte(`{"kind":"A","note":"pilne"}`))
+	second, secondErr := pipeline.Apply([]byte(`{"kind":"A"}`))
+
+	// --- Assert ---
+	require.NoError(t, firstErr)
+	require.NoError(t, secondErr)
+	assert.JSONEq(t, `{"address":{"town":"OPOLE","note":"pilne"},"kind":{"enabled":true}}`, string(first))
+	assert.JSONEq(t, `{"address":{"town":"OPOLE"},"kind":{"enabled":true}}`, string(second),
+		"values of an event should not leak into the next event")
+	assert.Equal(t, map[string]any{"lines": []any{map[string]any{"kind": "main"}}}, steps[0].Default.Value)
+	assert.Equal(t, map[string]any{"active": true}, steps[5].Map.Values["A"])
+}
+
+func TestPipeline_Apply_NumericConfigValues(t *testing.T) {
+	// --- Arrange ---
+	var steps []Step
+	require.NoError(t, yaml.Unmarshal([]byte(`[
+		{default: {path: qty, value: 0}},
+		{coerce: {path: qty, type: int}},
+		{map: {path: unit, values: {szt: 1, kg: 2.5}, default: 0}},
+		{coerce: {path: unit, type: float}},
+		{map: {path: status, values: {open: 1, closed: 5}}},
+		{convert: {converter: xl_document_state, paths: [status]}},
+		{default: {path: flags, value: {count: 3}}},
+		{coerce: {path: flags.count, type: string}}
+	]`), &steps))
+	pipeline, err := New(steps)
+	require.NoError(t, err)
+
+	// --- Act ---
+	got, err := pipeline.Apply([]byte(`{"unit":"kg","status":"closed"}`))
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"qty":0,"unit":2.5,"status":"ZAMKNIĘTY","flags":{"count":"3"}}`, string(got))
+}
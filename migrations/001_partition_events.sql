@@ -0,0 +1,58 @@
+-- Converts the events table into a table range partitioned by month on "timestamp".
+-- Run once while SLX is stopped, then enable EVENTS_PARTITIONED so the maintenance
+-- job keeps creating upcoming partitions and drops expired ones.
+
+BEGIN;
+
+-- partitions are named and bounded by UTC months, the same way the maintenance job does
+SET LOCAL timezone = 'UTC';
+
+ALTER TABLE events RENAME TO events_legacy;
+
+CREATE TABLE events (
+    event_id       UUID        NOT NULL,
+    event_type     TEXT        NOT NULL,
+    event_version  INTEGER     NOT NULL,
+    aggregate_key  TEXT        NOT NULL,
+    change_version BIGINT      NOT NULL,
+    timestamp      TIMESTAMPTZ NOT NULL,
+    correlation_id TEXT,
+    causation_id   TEXT,
+    user_id        TEXT,
+    payload        JSONB       NOT NULL,
+    PRIMARY KEY (event_id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE INDEX events_event_type_timestamp_idx ON events (event_type, timestamp);
+CREATE INDEX events_aggregate_key_idx ON events (aggregate_key);
+
+-- one partition per month from the oldest legacy event up to the current month
+DO $$
+DECLARE
+    month DATE := date_trunc('month', COALESCE((SELECT min(timestamp) FROM events_legacy), now()));
+BEGIN
+    WHILE month <= date_trunc('month', now()) LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF events FOR VALUES FROM (%L) TO (%L)',
+            'events_' || to_char(month, 'YYYY_MM'),
+            month::timestamptz,
+            (month + INTERVAL '1 month')::timestamptz
+        );
+        month := month + INTERVAL '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO events (
+    event_id, event_type, event_version, aggregate_key,
+    change_version, timestamp, correlation_id, causation_id,
+    user_id, payload
+)
+SELECT
+    event_id, event_type, event_version, aggregate_key,
+    change_version, timestamp, correlation_id, causation_id,
+    user_id, payload
+FROM events_legacy;
+
+DROP TABLE events_legacy;
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Adds the source commit time and the metadata of the envelope to the events table, so
+-- replays restore them. The service and the replay command add the columns at startup,
+-- run it before upgrading when their database user cannot alter the events table.
+-- Partitions of a partitioned events table get the columns from their parent.
+
+BEGIN;
+
+ALTER TABLE events
+    ADD COLUMN IF NOT EXISTS source_timestamp TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS metadata         JSONB;
+
+COMMIT;
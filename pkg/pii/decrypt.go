@@ -0,0 +1,50 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// DecryptPayload decrypts every encrypted string of a JSON payload, wherever it is. Consumers
+// call it with a keyring holding the keys the payloads were encrypted with.
+func (k *Keyring) DecryptPayload(payload []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	var doc any
+	if err := decoder.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("payload is not JSON: %w", err)
+	}
+	doc, err := k.decryptValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func (k *Keyring) decryptValue(value any) (any, error) {
+	switch v := value.(type) {
+	case string:
+		if !IsEncrypted(v) {
+			return v, nil
+		}
+		return k.Decrypt(v)
+	case map[string]any:
+		for key, field := range v {
+			decrypted, err := k.decryptValue(field)
+			if err != nil {
+				return nil, fmt.Errorf("field '%s': %w", key, err)
+			}
+			v[key] = decrypted
+		}
+	case []any:
+		for i, element := range v {
+			decrypted, err := k.decryptValue(element)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = decrypted
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,205 @@
+// Package pii protects personal data in event payloads. Fields are masked, hashed with a keyed
+// HMAC so they can still be joined on, or encrypted with AES-GCM under a key ID so keys can be
+// rotated. Consumers allowed to read encrypted fields decrypt them with a Keyring of the same keys.
+package pii
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+const (
+	// PolicyMask replaces all but the last four characters with asterisks
+	PolicyMask = "mask"
+	// PolicyHash replaces the value with its hex encoded HMAC-SHA256
+	PolicyHash = "hash"
+	// PolicyEncrypt replaces the value with its AES-GCM ciphertext
+	PolicyEncrypt = "encrypt"
+)
+
+// encryptedPrefix starts every encrypted value, the key ID and the ciphertext follow it
+const encryptedPrefix = "pii:v1:"
+
+// maskVisible is the number of trailing characters a mask keeps
+const maskVisible = 4
+
+// Mask replaces all but the last four characters of the value with asterisks, values of four
+// characters or less are masked completely
+func Mask(value string) string {
+	runes := []rune(value)
+	visible := 0
+	if len(runes) > maskVisible {
+		visible = maskVisible
+	}
+	for i := range runes[:len(runes)-visible] {
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// Hash returns the hex encoded HMAC-SHA256 of the value, equal values have equal hashes
+func Hash(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Keyring holds the AES keys by ID, new values are encrypted with the current key and values
+// of any key in the ring can be decrypted
+type Keyring struct {
+	current string
+	aeads   map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring of AES-128, AES-192 or AES-256 keys. The current key encrypts,
+// it may be empty for a keyring that only decrypts.
+func NewKeyring(keys map[string][]byte, current string) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("keyring has no keys")
+	}
+	ring := &Keyring{current: current, aeads: make(map[string]cipher.AEAD, len(keys))}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid key ID '%s', it must be set and must not contain ':'", id)
+		}
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %w", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key '%s': %w", id, err)
+		}
+		ring.aeads[id] = aead
+	}
+	if _, ok := ring.aeads[current]; current != "" && !ok {
+		return nil, fmt.Errorf("current key '%s' is not in the keyring", current)
+	}
+	return ring, nil
+}
+
+// ParseKeys parses keys written as comma separated id:base64 pairs, e.g. "2025a:q83v...,2025b:..."
+func ParseKeys(spec string) (map[string][]byte, error) {
+	keys := make(map[string][]byte)
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("key '%s' is not written as id:base64", pair)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key '%s' is not base64 encoded: %w", id, err)
+		}
+		if _, ok := keys[id]; ok {
+			return nil, fmt.Errorf("key '%s' is set more than once", id)
+		}
+		keys[id] = key
+	}
+	return keys, nil
+}
+
+// Encrypt encrypts the value with the current key. The result is "pii:v1:<key id>:" followed by
+// the base64 encoded nonce and ciphertext, the prefix is authenticated with the value.
+func (k *Keyring) Encrypt(value string) (string, error) {
+	aead, ok := k.aeads[k.current]
+	if !ok {
+		return "", fmt.Errorf("keyring has no current key")
+	}
+	prefix := encryptedPrefix + k.current + ":"
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(value), []byte(prefix))
+	return prefix + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts a value returned by Encrypt with the key it names
+func (k *Keyring) Decrypt(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return "", fmt.Errorf("value is not encrypted")
+	}
+	id, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return "", fmt.Errorf("encrypted value has no key ID")
+	}
+	aead, ok := k.aeads[id]
+	if !ok {
+		return "", fmt.Errorf("unknown key '%s'", id)
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("encrypted value is malformed")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plain, err := aead.Open(nil, nonce, ciphertext, []byte(encryptedPrefix+id+":"))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with key '%s': %w", id, err)
+	}
+	return string(plain), nil
+}
+
+// IsEncrypted reports whether the value was returned by Encrypt
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix)
+}
+
+// Protector applies the policies with the keys it was configured with
+type Protector struct {
+	hashKey []byte
+	keyring *Keyring
+}
+
+// NewProtector creates a protector, without a hash key the hash policy and without a keyring
+// the encrypt policy cannot be used
+func NewProtector(hashKey []byte, keyring *Keyring) *Protector {
+	return &Protector{hashKey: hashKey, keyring: keyring}
+}
+
+// Policy returns the function that protects a value with the policy
+func (p *Protector) Policy(policy string) (func(string) (string, error), error) {
+	switch policy {
+	case PolicyMask:
+		return func(value string) (string, error) { return Mask(value), nil }, nil
+	case PolicyHash:
+		if p == nil || len(p.hashKey) == 0 {
+			return nil, fmt.Errorf("the hash policy requires a hash key")
+		}
+		return func(value string) (string, error) { return Hash(p.hashKey, value), nil }, nil
+	case PolicyEncrypt:
+		if p == nil || p.keyring == nil || p.keyring.current == "" {
+			return nil, fmt.Errorf("the encrypt policy requires an encryption key")
+		}
+		return p.keyring.Encrypt, nil
+	default:
+		return nil, fmt.Errorf("unknown policy '%s', use mask, hash or encrypt", policy)
+	}
+}
+
+// Encrypt encrypts a value with the current key of the keyring, e.g. to keep data that is not
+// published protected at rest
+func (p *Protector) Encrypt(value string) (string, error) {
+	if p == nil || p.keyring == nil || p.keyring.current == "" {
+		return "", fmt.Errorf("encryption requires an encryption key")
+	}
+	return p.keyring.Encrypt(value)
+}
+
+// Decrypt decrypts a value returned by Encrypt
+func (p *Protector) Decrypt(value string) (string, error) {
+	if p == nil || p.keyring == nil {
+		return "", fmt.Errorf("decryption requires the encryption keys")
+	}
+	return p.keyring.Decrypt(value)
+}
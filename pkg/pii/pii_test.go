@@ -0,0 +1,144 @@
+package pii
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMask(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "jan@example.com", want: "***********.com"},
+		{value: "PL1234567890", want: "********7890"},
+		{value: "Łódź", want: "****"},
+		{value: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			// --- Act ---
+			got := Mask(tt.value)
+
+			// --- Assert ---
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHash(t *testing.T) {
+	// --- Act ---
+	first := Hash([]byte("key"), "1234567890")
+	second := Hash([]byte("key"), "1234567890")
+	otherKey := Hash([]byte("other"), "1234567890")
+
+	// --- Assert ---
+	assert.Equal(t, first, second, "equal values should have equal hashes")
+	assert.NotEqual(t, first, otherKey)
+	assert.Len(t, first, 64)
+}
+
+func TestKeyring_EncryptDecrypt(t *testing.T) {
+	// --- Arrange ---
+	oldKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+	newKey := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 16))
+	keys, err := ParseKeys("old:" + oldKey + ", new:" + newKey)
+	require.NoError(t, err)
+	old, err := NewKeyring(keys, "old")
+	require.NoError(t, err)
+	rotated, err := NewKeyring(keys, "new")
+	require.NoError(t, err)
+	readOnly, err := NewKeyring(map[string][]byte{"new": keys["new"]}, "")
+	require.NoError(t, err)
+
+	// --- Act ---
+	oldValue, err := old.Encrypt("jan@example.com")
+	require.NoError(t, err)
+	newValue, err := rotated.Encrypt("jan@example.com")
+	require.NoError(t, err)
+	decryptedOld, oldErr := rotated.Decrypt(oldValue)
+	decryptedNew, newErr := readOnly.Decrypt(newValue)
+	_, unknownKeyErr := readOnly.Decrypt(oldValue)
+	_, tamperedErr := rotated.Decrypt(strings.Replace(oldValue, ":old:", ":new:", 1))
+	_, encryptErr := readOnly.Encrypt("x")
+
+	// --- Assert ---
+	assert.True(t, strings.HasPrefix(oldValue, "pii:v1:old:"))
+	assert.True(t, strings.HasPrefix(newValue, "pii:v1:new:"))
+	require.NoError(t, oldErr)
+	require.NoError(t, newErr)
+	assert.Equal(t, "jan@example.com", decryptedOld)
+	assert.Equal(t, "jan@example.com", decryptedNew)
+	assert.ErrorContains(t, unknownKeyErr, "unknown key 'old'")
+	assert.Error(t, tamperedErr, "a value moved to another key should not decrypt")
+	assert.ErrorContains(t, encryptErr, "no current key")
+}
+
+func TestNewKeyring_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    map[string][]byte
+		current string
+	}{
+		{name: "no keys", keys: nil},
+		{name: "short key", keys: map[string][]byte{"k1": []byte("short")}},
+		{name: "colon in ID", keys: map[string][]byte{"k:1": bytes.Repeat([]byte{1}, 16)}},
+		{name: "unknown current key", keys: map[string][]byte{"k1": bytes.Repeat([]byte{1}, 16)}, current: "k2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// --- Act ---
+			keyring, err := NewKeyring(tt.keys, tt.current)
+
+			// --- Assert ---
+			assert.Error(t, err)
+			assert.Nil(t, keyring)
+		})
+	}
+}
+
+func TestKeyring_DecryptPayload(t *testing.T) {
+	// --- Arrange ---
+	keyring, err := NewKeyring(map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)}, "k1")
+	require.NoError(t, err)
+	email, err := keyring.Encrypt("jan@example.com")
+	require.NoError(t, err)
+	phone, err := keyring.Encrypt("77 555 01 02")
+	require.NoError(t, err)
+	payload := `{"customer_email":"` + email + `","customer_id":9007199254740993,` +
+		`"addresses":[{"address_phone":"` + phone + `","address_city":"Opole"}]}`
+
+	// --- Act ---
+	got, err := keyring.DecryptPayload([]byte(payload))
+
+	// --- Assert ---
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"customer_email":"jan@example.com","customer_id":9007199254740993,`+
+		`"addresses":[{"address_phone":"77 555 01 02","address_city":"Opole"}]}`, string(got))
+}
+
+func TestProtector_Policy(t *testing.T) {
+	// --- Arrange ---
+	withoutKeys := NewProtector(nil, nil)
+
+	// --- Act ---
+	mask, maskErr := withoutKeys.Policy(PolicyMask)
+	_, hashErr := withoutKeys.Policy(PolicyHash)
+	_, encryptErr := withoutKeys.Policy(PolicyEncrypt)
+	_, unknownErr := withoutKeys.Policy("scramble")
+
+	// --- Assert ---
+	require.NoError(t, maskErr)
+	masked, _ := mask("secret value")
+	assert.Equal(t, "********alue", masked)
+	assert.ErrorContains(t, hashErr, "hash key")
+	assert.ErrorContains(t, encryptErr, "encryption key")
+	assert.ErrorContains(t, unknownErr, "unknown policy")
+}